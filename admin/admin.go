@@ -0,0 +1,318 @@
+// Package admin provides http.Handler of JSON endpoints for operating a tok hub.
+//
+// Endpoints, relative to where the handler is mounted:
+//
+//	GET    /online                             online uid and connection count
+//	GET    /connections?uid=&offset=&limit=    connections ordered by uid and device, uid is optional
+//	POST   /kick          {"uid", "device"}    kick all connections of uid, or the device only
+//	GET    /queue?uid=                         length of offline queue of uid
+//	DELETE /queue?uid=                         purge offline queue of uid
+//	POST   /send          {"uid", "payload", "base64", "ttl"}  send test message to uid
+//
+// Every request must pass Authorizer. Errors are answered as {"error": "..."}.
+package admin
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/internal/httpapi"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Option option of Handler
+type Option func(*Handler)
+
+// WithUIDParser set parser of uid in requests, default keeps uid as string
+func WithUIDParser(parse func(s string) (interface{}, error)) Option {
+	return func(h *Handler) {
+		h.parseUID = parse
+	}
+}
+
+// WithTimeout set timeout of operating hub for each request, default 5s
+func WithTimeout(d time.Duration) Option {
+	return func(h *Handler) {
+		h.timeout = d
+	}
+}
+
+// Handler is http.Handler operating hub
+type Handler struct {
+	hub      *tok.Hub
+	auth     Authorizer
+	parseUID func(s string) (interface{}, error)
+	timeout  time.Duration
+	mux      *http.ServeMux
+}
+
+// New create admin handler of hub, requests are authorized by auth, nil auth denies all requests.
+// Mount it with http.StripPrefix if it's not served at root.
+func New(hub *tok.Hub, auth Authorizer, opts ...Option) *Handler {
+	h := &Handler{
+		hub:      hub,
+		auth:     auth,
+		parseUID: httpapi.StringUID,
+		timeout:  5 * time.Second,
+		mux:      http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	h.mux.HandleFunc("GET /online", h.online)
+	h.mux.HandleFunc("GET /connections", h.connections)
+	h.mux.HandleFunc("POST /kick", h.kick)
+	h.mux.HandleFunc("GET /queue", h.queueLen)
+	h.mux.HandleFunc("DELETE /queue", h.purgeQueue)
+	h.mux.HandleFunc("POST /send", h.send)
+	return h
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.auth == nil {
+		writeError(w, http.StatusForbidden, errors.New("no authorizer"))
+		return
+	}
+	if err := h.auth.Authorize(r); err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			writeError(w, http.StatusUnauthorized, err)
+		} else {
+			writeError(w, http.StatusForbidden, err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+	h.mux.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// Connection is connection in listing, see tok.ConnInfo
+type Connection struct {
+	UID          interface{} `json:"uid"`
+	DeviceID     string      `json:"device_id"`
+	RemoteAddr   string      `json:"remote_addr"`
+	Transport    string      `json:"transport"`
+	ConnectedAt  time.Time   `json:"connected_at"`
+	LastRead     time.Time   `json:"last_read"`
+	LastWrite    time.Time   `json:"last_write"`
+	LastActivity time.Time   `json:"last_activity"`
+	BytesIn      uint64      `json:"bytes_in"`
+	BytesOut     uint64      `json:"bytes_out"`
+	MessagesIn   uint64      `json:"messages_in"`
+	MessagesOut  uint64      `json:"messages_out"`
+}
+
+// ConnectionPage is a page of connection listing
+type ConnectionPage struct {
+	Total       int          `json:"total"`
+	Offset      int          `json:"offset"`
+	Limit       int          `json:"limit"`
+	Connections []Connection `json:"connections"`
+}
+
+func (h *Handler) online(w http.ResponseWriter, r *http.Request) {
+	uids, conns := h.hub.OnlineCount(r.Context())
+	if err := r.Context().Err(); err != nil {
+		writeError(w, http.StatusGatewayTimeout, err)
+		return
+	}
+	writeJSON(w, map[string]int{"uids": uids, "connections": conns})
+}
+
+func (h *Handler) connections(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	offset, err := intParam(q.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bad offset %q", q.Get("offset")))
+		return
+	}
+	limit, err := intParam(q.Get("limit"), defaultLimit)
+	if err != nil || limit < 1 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bad limit %q", q.Get("limit")))
+		return
+	}
+	limit = min(limit, maxLimit)
+
+	var infos []tok.ConnInfo
+	if s := q.Get("uid"); s != "" {
+		uid, err := h.parseUID(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		infos = h.hub.Devices(r.Context(), uid)
+	} else {
+		infos = h.hub.Connections(r.Context(), nil)
+	}
+	if err := r.Context().Err(); err != nil {
+		writeError(w, http.StatusGatewayTimeout, err)
+		return
+	}
+
+	// order is stable between pages, as long as connections don't change
+	slices.SortFunc(infos, func(a, b tok.ConnInfo) int {
+		return cmp.Or(
+			cmp.Compare(fmt.Sprint(a.UID), fmt.Sprint(b.UID)),
+			cmp.Compare(a.DeviceID, b.DeviceID),
+			a.ConnectedAt.Compare(b.ConnectedAt),
+		)
+	})
+
+	page := ConnectionPage{Total: len(infos), Offset: offset, Limit: limit, Connections: []Connection{}}
+	for _, info := range infos[min(offset, len(infos)):min(offset+limit, len(infos))] {
+		page.Connections = append(page.Connections, Connection(info))
+	}
+	writeJSON(w, page)
+}
+
+// KickRequest is body of kick, all connections of uid are kicked if device is empty
+type KickRequest struct {
+	UID    string `json:"uid"`
+	Device string `json:"device"`
+}
+
+func (h *Handler) kick(w http.ResponseWriter, r *http.Request) {
+	var req KickRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	uid, ok := h.uid(w, req.UID)
+	if !ok {
+		return
+	}
+
+	if req.Device == "" {
+		h.hub.Kick(r.Context(), uid)
+	} else if err := h.hub.KickDevice(r.Context(), uid, req.Device); err != nil {
+		writeHubError(w, err)
+		return
+	}
+	if err := r.Context().Err(); err != nil {
+		// hub might not have accepted the kick
+		writeError(w, http.StatusGatewayTimeout, err)
+		return
+	}
+	writeJSON(w, map[string]bool{"ok": true})
+}
+
+func (h *Handler) queueLen(w http.ResponseWriter, r *http.Request) {
+	uid, ok := h.uid(w, r.URL.Query().Get("uid"))
+	if !ok {
+		return
+	}
+	n, err := h.hub.QueueLen(r.Context(), uid)
+	if err != nil {
+		writeHubError(w, err)
+		return
+	}
+	writeJSON(w, map[string]int{"len": n})
+}
+
+func (h *Handler) purgeQueue(w http.ResponseWriter, r *http.Request) {
+	uid, ok := h.uid(w, r.URL.Query().Get("uid"))
+	if !ok {
+		return
+	}
+	n, err := h.hub.PurgeQueue(r.Context(), uid)
+	if err != nil {
+		writeHubError(w, err)
+		return
+	}
+	writeJSON(w, map[string]int{"purged": n})
+}
+
+// SendRequest is body of send, payload is base64 encoded if Base64 is true
+type SendRequest struct {
+	UID     string `json:"uid"`
+	Payload string `json:"payload"`
+	Base64  bool   `json:"base64"`
+	TTL     uint32 `json:"ttl"`
+}
+
+func (h *Handler) send(w http.ResponseWriter, r *http.Request) {
+	var req SendRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	uid, ok := h.uid(w, req.UID)
+	if !ok {
+		return
+	}
+	payload := []byte(req.Payload)
+	if req.Base64 {
+		var err error
+		if payload, err = base64.StdEncoding.DecodeString(req.Payload); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("bad payload: %w", err))
+			return
+		}
+	}
+
+	if err := h.hub.Send(r.Context(), uid, payload, req.TTL); err != nil {
+		writeHubError(w, err)
+		return
+	}
+	writeJSON(w, map[string]bool{"ok": true})
+}
+
+// uid parses uid, bad request is answered if it fails
+func (h *Handler) uid(w http.ResponseWriter, s string) (interface{}, bool) {
+	uid, err := httpapi.ParseUID(h.parseUID, s)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	return uid, true
+}
+
+func intParam(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bad body: %w", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	httpapi.WriteJSON(w, http.StatusOK, v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	httpapi.WriteJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeHubError answers error of hub with matching status
+func writeHubError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, tok.ErrOffline):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, tok.ErrQueueRequired):
+		writeError(w, http.StatusNotImplemented, err)
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		writeError(w, http.StatusGatewayTimeout, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,161 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/admin"
+	"github.com/quexer/tok/internal/testutil"
+)
+
+var _ = Describe("Admin", func() {
+	var (
+		hub     *tok.Hub
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		hub = testutil.NewHub(ctl, tok.WithHubConfigQueue(tok.NewMemoryQueue()))
+		handler = admin.New(hub, admin.BearerToken("secret"))
+	})
+
+	// do serves request with token, and decodes json response into v
+	do := func(method, target, body string, v interface{}) int {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if v != nil {
+			Ω(json.Unmarshal(w.Body.Bytes(), v)).To(Succeed())
+		}
+		return w.Code
+	}
+
+	It("should authorize requests", func() {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/online", nil))
+		Ω(w.Code).To(Equal(http.StatusUnauthorized))
+
+		w = httptest.NewRecorder()
+		admin.New(hub, nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/online", nil))
+		Ω(w.Code).To(Equal(http.StatusForbidden))
+
+		w = httptest.NewRecorder()
+		admin.New(hub, admin.AuthorizerFunc(func(*http.Request) error { return io.EOF })).
+			ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/online", nil))
+		Ω(w.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should count online uids and connections", func() {
+		testutil.Connect(ctx, hub, tok.CreateDevice("u1", "phone"))
+		testutil.Connect(ctx, hub, tok.CreateDevice("u1", "tablet"))
+		testutil.Connect(ctx, hub, tok.CreateDevice("u2", "phone"))
+
+		var count map[string]int
+		Ω(do(http.MethodGet, "/online", "", &count)).To(Equal(http.StatusOK))
+		Ω(count).To(Equal(map[string]int{"uids": 2, "connections": 3}))
+	})
+
+	It("should list connections by page", func() {
+		for i := 0; i < 5; i++ {
+			testutil.Connect(ctx, hub, tok.CreateDevice("u"+strconv.Itoa(i), "phone"))
+		}
+		testutil.Connect(ctx, hub, tok.CreateDevice("u1", "tablet"))
+
+		var page admin.ConnectionPage
+		Ω(do(http.MethodGet, "/connections?offset=1&limit=2", "", &page)).To(Equal(http.StatusOK))
+		Ω(page.Total).To(Equal(6))
+		Ω(page.Connections).To(HaveLen(2))
+		Ω(page.Connections[0].UID).To(Equal("u1"))
+		Ω(page.Connections[0].DeviceID).To(Equal("phone"))
+		Ω(page.Connections[0].Transport).To(Equal(tok.TransportCustom))
+		Ω(page.Connections[1].DeviceID).To(Equal("tablet"))
+
+		Ω(do(http.MethodGet, "/connections?offset=10", "", &page)).To(Equal(http.StatusOK))
+		Ω(page.Total).To(Equal(6))
+		Ω(page.Connections).To(BeEmpty())
+
+		Ω(do(http.MethodGet, "/connections?uid=u1", "", &page)).To(Equal(http.StatusOK))
+		Ω(page.Total).To(Equal(2))
+
+		Ω(do(http.MethodGet, "/connections?limit=0", "", nil)).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should kick uid or device", func() {
+		phone := testutil.Connect(ctx, hub, tok.CreateDevice("u1", "phone"))
+		tablet := testutil.Connect(ctx, hub, tok.CreateDevice("u1", "tablet"))
+
+		Ω(do(http.MethodPost, "/kick", `{"uid":"u1","device":"phone"}`, nil)).To(Equal(http.StatusOK))
+		Eventually(phone.ChClosed).Should(BeClosed())
+		Ω(hub.CheckOnline(ctx, "u1")).To(BeTrue())
+
+		Ω(do(http.MethodPost, "/kick", `{"uid":"u1","device":"phone"}`, nil)).To(Equal(http.StatusNotFound))
+
+		Ω(do(http.MethodPost, "/kick", `{"uid":"u1"}`, nil)).To(Equal(http.StatusOK))
+		Eventually(tablet.ChClosed).Should(BeClosed())
+
+		Ω(do(http.MethodPost, "/kick", `{}`, nil)).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should fail kick of cancelled request", func() {
+		testutil.Connect(ctx, hub, tok.CreateDevice("u1", "phone"))
+		c, cancel := context.WithCancel(ctx)
+		cancel()
+		for _, body := range []string{`{"uid":"u1"}`, `{"uid":"u1","device":"phone"}`} {
+			r := httptest.NewRequest(http.MethodPost, "/kick", strings.NewReader(body)).WithContext(c)
+			r.Header.Set("Authorization", "Bearer secret")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			Ω(w.Code).To(Equal(http.StatusGatewayTimeout))
+		}
+		Ω(hub.CheckOnline(ctx, "u1")).To(BeTrue())
+	})
+
+	It("should show and purge offline queue", func() {
+		Ω(hub.Send(ctx, "u1", []byte("m1"), 60)).To(Succeed())
+		Ω(hub.Send(ctx, "u1", []byte("m2"), 60)).To(Succeed())
+
+		var result map[string]int
+		Ω(do(http.MethodGet, "/queue?uid=u1", "", &result)).To(Equal(http.StatusOK))
+		Ω(result).To(Equal(map[string]int{"len": 2}))
+
+		var purged map[string]int
+		Ω(do(http.MethodDelete, "/queue?uid=u1", "", &purged)).To(Equal(http.StatusOK))
+		Ω(purged).To(Equal(map[string]int{"purged": 2}))
+		Ω(hub.QueueLen(ctx, "u1")).To(BeZero())
+	})
+
+	It("should send test message", func() {
+		adapter := testutil.Connect(ctx, hub, tok.CreateDevice("u1", "phone"))
+
+		Ω(do(http.MethodPost, "/send", `{"uid":"u1","payload":"hi"}`, nil)).To(Equal(http.StatusOK))
+		Eventually(adapter.ChWritten).Should(Receive(Equal([]byte("hi"))))
+
+		Ω(do(http.MethodPost, "/send", `{"uid":"u1","payload":"AAE=","base64":true}`, nil)).To(Equal(http.StatusOK))
+		Eventually(adapter.ChWritten).Should(Receive(Equal([]byte{0, 1})))
+
+		var e map[string]string
+		Ω(do(http.MethodPost, "/send", `{"uid":"nobody","payload":"hi"}`, &e)).To(Equal(http.StatusNotFound))
+		Ω(e["error"]).To(ContainSubstring("offline"))
+	})
+
+	It("should parse uid", func() {
+		handler = admin.New(hub, admin.BearerToken("secret"), admin.WithUIDParser(func(s string) (interface{}, error) {
+			return strconv.ParseInt(s, 10, 64)
+		}))
+		adapter := testutil.Connect(ctx, hub, tok.CreateDevice(int64(7), ""))
+
+		Ω(do(http.MethodPost, "/send", `{"uid":"7","payload":"hi"}`, nil)).To(Equal(http.StatusOK))
+		Eventually(adapter.ChWritten).Should(Receive(Equal([]byte("hi"))))
+		Ω(do(http.MethodPost, "/send", `{"uid":"x","payload":"hi"}`, nil)).To(Equal(http.StatusBadRequest))
+	})
+})
@@ -0,0 +1,36 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/quexer/tok/internal/httpapi"
+)
+
+// ErrUnauthorized is returned by authorizers for requests without valid credentials
+var ErrUnauthorized = errors.New("tok admin: unauthorized")
+
+// Authorizer decides whether request may operate the hub.
+// Request is answered with 401 for ErrUnauthorized, and 403 for other errors.
+type Authorizer interface {
+	Authorize(r *http.Request) error
+}
+
+// AuthorizerFunc adapts function to Authorizer
+type AuthorizerFunc func(r *http.Request) error
+
+// Authorize implements Authorizer
+func (f AuthorizerFunc) Authorize(r *http.Request) error {
+	return f(r)
+}
+
+// BearerToken returns Authorizer accepting requests with header "Authorization: Bearer <token>"
+func BearerToken(token string) Authorizer {
+	return AuthorizerFunc(func(r *http.Request) error {
+		got, ok := httpapi.BearerToken(r)
+		if !ok || !httpapi.TokenEqual(got, token) {
+			return ErrUnauthorized
+		}
+		return nil
+	})
+}
@@ -0,0 +1,246 @@
+// Package client is a reconnecting Go client for tok servers.
+// It speaks tok tcp framing and websocket, so Go services and test bots can act as tok users.
+package client
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed occurs while sending message with closed client
+var ErrClosed = errors.New("tok client: closed")
+
+// Client keeps connected to tok server.
+// It sends auth on each connect, reconnects with jittered exponential backoff,
+// and buffers outbound messages while disconnected.
+type Client struct {
+	dialer      Dialer
+	auth        func(ctx context.Context) ([]byte, error)     // optional auth payload builder
+	pingHandler func(data []byte) (reply []byte, isPing bool) // optional server ping handler
+	minBackoff  time.Duration                                 // min reconnect delay, default 500ms
+	maxBackoff  time.Duration                                 // max reconnect delay, default 30s
+	sendBuffer  int                                           // size of outbound buffer
+	recvBuffer  int                                           // size of inbound channel
+	chSend      chan []byte                                   // outbound messages
+	chRecv      chan []byte                                   // inbound messages
+	connected   atomic.Bool                                   // connection status
+	ctx         context.Context                               // closed while client is closed
+	cancelFunc  context.CancelFunc                            // cancel function of ctx
+	done        chan struct{}                                 // closed after run loop quit
+}
+
+// New create client and start connecting in background
+func New(dialer Dialer, opts ...Option) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Client{
+		dialer:     dialer,
+		minBackoff: 500 * time.Millisecond, // default
+		maxBackoff: 30 * time.Second,       // default
+		sendBuffer: 256,                    // default
+		recvBuffer: 256,                    // default
+		ctx:        ctx,
+		cancelFunc: cancel,
+		done:       make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	c.chSend = make(chan []byte, c.sendBuffer)
+	c.chRecv = make(chan []byte, c.recvBuffer)
+
+	go c.run()
+	return c
+}
+
+// Send queues message to server. It blocks while outbound buffer is full, until ctx is done.
+// Message written during connection failure will be resent after reconnect, so server might receive it twice.
+func (c *Client) Send(ctx context.Context, data []byte) error {
+	if c.ctx.Err() != nil {
+		return ErrClosed
+	}
+
+	select {
+	case c.chSend <- data:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.ctx.Done():
+		return ErrClosed
+	}
+}
+
+// Recv returns channel of messages from server, it's closed after client is closed
+func (c *Client) Recv() <-chan []byte {
+	return c.chRecv
+}
+
+// Connected return whether client is connected or not
+func (c *Client) Connected() bool {
+	return c.connected.Load()
+}
+
+// Close closes client and its connection. Buffered outbound messages are dropped.
+func (c *Client) Close() error {
+	c.cancelFunc()
+	<-c.done
+	return nil
+}
+
+func (c *Client) run() {
+	defer close(c.done)
+	defer close(c.chRecv)
+
+	var pending []byte // message failed to write, resent after reconnect
+	attempt := 0
+	for {
+		conn, err := c.connect()
+		if err != nil {
+			slog.Warn("[tok client] connect failed", "err", err, "attempt", attempt)
+			attempt++
+		} else {
+			var healthy bool
+			pending, healthy = c.serve(conn, pending)
+			if healthy {
+				attempt = 0
+			} else {
+				// e.g. server rejected auth and closed connection
+				attempt++
+			}
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(c.backoff(attempt)):
+		}
+	}
+}
+
+// backoff returns reconnect delay of attempt, with jitter in [d/2, d)
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.minBackoff
+	for i := 0; i < attempt && d < c.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+func (c *Client) connect() (Conn, error) {
+	conn, err := c.dialer.Dial(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.auth == nil {
+		return conn, nil
+	}
+
+	b, err := c.auth(c.ctx)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if err := conn.Write(b); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// serve pumps messages on conn until it's broken or client is closed.
+// It returns the message failed to write, if any, and whether the session proved healthy,
+// i.e. something was read from server, or it stayed up longer than max backoff.
+func (c *Client) serve(conn Conn, pending []byte) ([]byte, bool) {
+	c.connected.Store(true)
+	defer c.connected.Store(false)
+
+	start := time.Now()
+	stop := make(chan struct{})
+	chReply := make(chan []byte)
+	readDone := make(chan struct{})
+	var readErr error
+	var gotFrame atomic.Bool
+	go func() {
+		defer close(readDone)
+		readErr = c.readLoop(conn, chReply, stop, &gotFrame)
+	}()
+
+	pending = c.pump(conn, pending, chReply, readDone, &readErr)
+
+	close(stop)
+	_ = conn.Close()
+	<-readDone // make sure no one writes chRecv after return
+	return pending, gotFrame.Load() || time.Since(start) > c.maxBackoff
+}
+
+// pump writes messages to conn until it's broken, read is done, or client is closed.
+// It returns the message failed to write, if any.
+func (c *Client) pump(conn Conn, pending []byte, chReply <-chan []byte, readDone <-chan struct{}, readErr *error) []byte {
+	for {
+		if pending != nil {
+			if err := conn.Write(pending); err != nil {
+				slog.Warn("[tok client] write failed", "err", err)
+				return pending
+			}
+			pending = nil
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case <-readDone:
+			slog.Warn("[tok client] read failed", "err", *readErr)
+			return nil
+		case b := <-chReply:
+			if err := conn.Write(b); err != nil {
+				slog.Warn("[tok client] write ping reply failed", "err", err)
+				return nil
+			}
+		case b := <-c.chSend:
+			pending = b
+		}
+	}
+}
+
+// readLoop passes messages from server to chRecv, and ping replies to chReply. gotFrame is set after the first read.
+func (c *Client) readLoop(conn Conn, chReply chan<- []byte, stop <-chan struct{}, gotFrame *atomic.Bool) error {
+	for {
+		b, err := conn.Read()
+		if err != nil {
+			return err
+		}
+		gotFrame.Store(true)
+
+		if c.pingHandler != nil {
+			if reply, isPing := c.pingHandler(b); isPing {
+				if reply == nil {
+					continue
+				}
+				select {
+				case chReply <- reply:
+				case <-stop:
+					return nil
+				}
+				continue
+			}
+		}
+
+		select {
+		case c.chRecv <- b:
+		case <-stop:
+			return nil
+		}
+	}
+}
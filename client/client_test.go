@@ -0,0 +1,185 @@
+package client_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/client"
+	"github.com/quexer/tok/mocks"
+)
+
+// freeAddr returns a local tcp address which is free to listen on
+func freeAddr() string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	Ω(err).To(Succeed())
+	defer l.Close()
+	return l.Addr().String()
+}
+
+var _ = Describe("Client", func() {
+	const uid = "u1"
+	var (
+		mActor   *mocks.MockActor
+		mPingGen *mocks.MockPingGenerator
+		chUp     chan []byte
+		addr     string
+		hubAuth  tok.TCPAuthFunc
+		cliAuth  func(ctx context.Context) ([]byte, error)
+		authed   atomic.Int32
+	)
+
+	BeforeEach(func() {
+		mActor = mocks.NewMockActor(ctl)
+		mPingGen = mocks.NewMockPingGenerator(ctl)
+		ch := make(chan []byte, 10)
+		chUp = ch
+		mActor.EXPECT().OnReceive(gomock.Any(), gomock.Any()).Do(func(_ *tok.Device, data []byte) {
+			ch <- data
+		}).AnyTimes()
+
+		addr = freeAddr()
+		authed.Store(0)
+		hubAuth = func(b []byte) (*tok.Device, error) {
+			authed.Add(1)
+			return tok.CreateDevice(string(b), ""), nil
+		}
+		cliAuth = func(_ context.Context) ([]byte, error) {
+			return []byte(uid), nil
+		}
+	})
+
+	listen := func(opts ...tok.HubConfigOption) *tok.Hub {
+		opts = append(opts, tok.WithHubConfigPingProducer(mPingGen))
+		hub, err := tok.Listen(nil, tok.NewHubConfig(mActor, opts...), addr, hubAuth)
+		Ω(err).To(Succeed())
+		return hub
+	}
+
+	Describe("TCP", func() {
+		var cli *client.Client
+
+		JustBeforeEach(func() {
+			cli = client.New(&client.TCPDialer{Addr: addr},
+				client.WithAuth(cliAuth),
+				client.WithBackoff(10*time.Millisecond, 50*time.Millisecond),
+				client.WithPingHandler(func(data []byte) ([]byte, bool) {
+					if string(data) == "ping" {
+						return []byte("pong"), true
+					}
+					return nil, false
+				}))
+		})
+
+		AfterEach(func() {
+			Ω(cli.Close()).To(Succeed())
+		})
+
+		It("should send and receive messages", func() {
+			hub := listen()
+			Eventually(func() bool { return hub.CheckOnline(ctx, uid) }).Should(BeTrue())
+			Ω(cli.Connected()).To(BeTrue())
+
+			Ω(cli.Send(ctx, []byte("hello"))).To(Succeed())
+			Eventually(chUp).Should(Receive(Equal([]byte("hello"))))
+
+			Ω(hub.Send(ctx, uid, []byte("world"), 0)).To(Succeed())
+			Eventually(cli.Recv()).Should(Receive(Equal([]byte("world"))))
+		})
+
+		It("should answer server ping", func() {
+			mPingGen.EXPECT().Ping().Return([]byte("ping")).AnyTimes()
+			listen(tok.WithHubConfigServerPingInterval(50 * time.Millisecond))
+
+			Eventually(chUp).Should(Receive(Equal([]byte("pong"))))
+			Consistently(cli.Recv(), 100*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("should buffer messages while disconnected", func() {
+			Ω(cli.Send(ctx, []byte("early"))).To(Succeed())
+			Consistently(cli.Connected, 50*time.Millisecond).Should(BeFalse())
+
+			listen()
+			Eventually(chUp).Should(Receive(Equal([]byte("early"))))
+		})
+
+		It("should reconnect after kicked", func() {
+			hub := listen()
+			Eventually(func() bool { return hub.CheckOnline(ctx, uid) }).Should(BeTrue())
+
+			hub.Kick(ctx, uid)
+			Eventually(authed.Load).Should(BeNumerically(">=", 2))
+			Eventually(func() bool { return hub.CheckOnline(ctx, uid) }).Should(BeTrue())
+
+			Ω(hub.Send(ctx, uid, []byte("again"), 0)).To(Succeed())
+			Eventually(cli.Recv()).Should(Receive(Equal([]byte("again"))))
+		})
+
+		It("should back off while server closes connection right after accept", func() {
+			l, err := net.Listen("tcp", addr)
+			Ω(err).To(Succeed())
+			defer l.Close()
+			var accepted atomic.Int32
+			go func() {
+				for {
+					conn, err := l.Accept()
+					if err != nil {
+						return
+					}
+					accepted.Add(1)
+					_ = conn.Close()
+				}
+			}()
+
+			// 5~10ms apart if backoff were reset, no less than 25ms apart while it grows to max
+			time.Sleep(500 * time.Millisecond)
+			Ω(accepted.Load()).To(BeNumerically(">", 1))
+			Ω(accepted.Load()).To(BeNumerically("<=", 25))
+			Ω(cli.Connected()).To(BeFalse())
+		})
+
+		It("should refuse to send after closed", func() {
+			Ω(cli.Close()).To(Succeed())
+			Ω(cli.Send(ctx, []byte("late"))).To(MatchError(client.ErrClosed))
+			Eventually(cli.Recv()).Should(BeClosed())
+		})
+	})
+
+	Describe("WebSocket", func() {
+		It("should send and receive messages", func() {
+			auth := func(r *http.Request) (*tok.Device, error) {
+				return tok.CreateDevice(r.Header.Get("Authorization"), ""), nil
+			}
+			hub, hdl := tok.CreateWsHandler(auth,
+				tok.WithWsHandlerEngine(tok.WsEngineGorilla),
+				tok.WithWsHandlerHubConfig(tok.NewHubConfig(mActor, tok.WithHubConfigPingProducer(mPingGen))))
+			server := httptest.NewServer(hdl)
+			defer server.Close()
+
+			cli := client.New(&client.WsDialer{
+				URL: "ws" + server.URL[4:],
+				Header: func() http.Header {
+					return http.Header{"Authorization": {uid}}
+				},
+				Txt: true,
+			})
+			defer cli.Close()
+
+			Eventually(func() bool { return hub.CheckOnline(ctx, uid) }).Should(BeTrue())
+
+			Ω(cli.Send(ctx, []byte("hello"))).To(Succeed())
+			Eventually(chUp).Should(Receive(Equal([]byte("hello"))))
+
+			Ω(hub.Send(ctx, uid, []byte("world"), 0)).To(Succeed())
+			Eventually(cli.Recv()).Should(Receive(Equal([]byte("world"))))
+		})
+	})
+})
@@ -0,0 +1,160 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/quexer/tok"
+)
+
+// Conn is a message oriented connection to tok server.
+// Read is called from a single goroutine, so is Write.
+type Conn interface {
+	// Read reads the next message from server
+	Read() ([]byte, error)
+	// Write writes a message to server
+	Write(data []byte) error
+	// Close closes the connection
+	Close() error
+}
+
+// Dialer creates connections to tok server
+type Dialer interface {
+	// Dial connects to tok server
+	Dial(ctx context.Context) (Conn, error)
+}
+
+// TCPDialer dials tok tcp listener, see tok.Listen
+type TCPDialer struct {
+	Addr         string        // server address
+	Framer       tok.Framer    // framing codec, must match the listener. default is tok.Uint32Framer
+	MaxFrameLen  uint32        // upper limit for single inbound message, default is 4M
+	WriteTimeout time.Duration // write timeout, default is 1 minute
+}
+
+// Dial implements Dialer
+func (p *TCPDialer) Dial(ctx context.Context) (Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &tcpConn{
+		conn:         conn,
+		br:           bufio.NewReader(conn),
+		framer:       p.Framer,
+		maxFrameLen:  p.MaxFrameLen,
+		writeTimeout: p.WriteTimeout,
+	}
+	if c.framer == nil {
+		c.framer = tok.Uint32Framer
+	}
+	if c.maxFrameLen == 0 {
+		c.maxFrameLen = 4 * 1024 * 1024
+	}
+	if c.writeTimeout == 0 {
+		c.writeTimeout = time.Minute
+	}
+	return c, nil
+}
+
+type tcpConn struct {
+	conn         net.Conn
+	br           *bufio.Reader
+	framer       tok.Framer
+	maxFrameLen  uint32
+	writeTimeout time.Duration
+}
+
+func (p *tcpConn) Read() ([]byte, error) {
+	return p.framer.ReadFrame(p.br, p.maxFrameLen)
+}
+
+func (p *tcpConn) Write(data []byte) error {
+	if err := p.conn.SetWriteDeadline(time.Now().Add(p.writeTimeout)); err != nil {
+		return fmt.Errorf("setting write deadline err: %w", err)
+	}
+
+	buf, err := p.framer.AppendHeader(nil, data)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, data...)
+	buf = append(buf, p.framer.Trailer()...)
+	_, err = p.conn.Write(buf)
+	return err
+}
+
+func (p *tcpConn) Close() error {
+	return p.conn.Close()
+}
+
+// WsDialer dials tok websocket handler, see tok.CreateWsHandler.
+// WebSocket ping control frames are answered automatically.
+type WsDialer struct {
+	URL          string             // websocket url, e.g. ws://localhost:8080/ws
+	Header       func() http.Header // optional, builds handshake header on each dial, e.g. for authorization
+	Txt          bool               // send text frames if true, otherwise binary frames
+	Dialer       *websocket.Dialer  // optional, default is websocket.DefaultDialer
+	WriteTimeout time.Duration      // write timeout, default is 1 minute
+}
+
+// Dial implements Dialer
+func (p *WsDialer) Dial(ctx context.Context) (Conn, error) {
+	dialer := p.Dialer
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+
+	var header http.Header
+	if p.Header != nil {
+		header = p.Header()
+	}
+
+	conn, _, err := dialer.DialContext(ctx, p.URL, header)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &wsConn{
+		conn:         conn,
+		messageType:  websocket.BinaryMessage,
+		writeTimeout: p.WriteTimeout,
+	}
+	if p.Txt {
+		c.messageType = websocket.TextMessage
+	}
+	if c.writeTimeout == 0 {
+		c.writeTimeout = time.Minute
+	}
+	return c, nil
+}
+
+type wsConn struct {
+	conn         *websocket.Conn
+	messageType  int
+	writeTimeout time.Duration
+}
+
+func (p *wsConn) Read() ([]byte, error) {
+	_, data, err := p.conn.ReadMessage()
+	return data, err
+}
+
+func (p *wsConn) Write(data []byte) error {
+	if err := p.conn.SetWriteDeadline(time.Now().Add(p.writeTimeout)); err != nil {
+		return fmt.Errorf("setting ws write deadline err: %w", err)
+	}
+	return p.conn.WriteMessage(p.messageType, data)
+}
+
+func (p *wsConn) Close() error {
+	return p.conn.Close()
+}
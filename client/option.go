@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+type Option func(*Client)
+
+// WithAuth set auth payload builder, the payload is sent as the first message on each connect.
+// It's required by tcp listener, which takes the first frame as auth frame.
+func WithAuth(auth func(ctx context.Context) ([]byte, error)) Option {
+	return func(c *Client) {
+		c.auth = auth
+	}
+}
+
+// WithPingHandler set server ping handler.
+// hdl is called for every inbound message, if isPing is true, the message is not delivered to Recv,
+// and reply is sent back to server unless it's nil.
+func WithPingHandler(hdl func(data []byte) (reply []byte, isPing bool)) Option {
+	return func(c *Client) {
+		c.pingHandler = hdl
+	}
+}
+
+// WithBackoff set reconnect backoff range, default is 500ms ~ 30s.
+// Delay doubles on each failed attempt, with random jitter.
+func WithBackoff(min, max time.Duration) Option {
+	return func(c *Client) {
+		c.minBackoff = min
+		c.maxBackoff = max
+	}
+}
+
+// WithSendBuffer set size of outbound buffer, default is 256.
+// Messages are buffered while disconnected, Send blocks if buffer is full.
+func WithSendBuffer(size int) Option {
+	return func(c *Client) {
+		c.sendBuffer = size
+	}
+}
+
+// WithRecvBuffer set size of inbound channel returned by Recv, default is 256.
+func WithRecvBuffer(size int) Option {
+	return func(c *Client) {
+		c.recvBuffer = size
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"log/slog"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 //go:generate mockgen -destination=mocks/conn.go -package=mocks . ConAdapter
@@ -20,15 +21,33 @@ import (
 // - wLock: Ensures write operations are serialized (Write method only)
 // - closed: Uses atomic operations for lock-free status check
 // - offlineTriggered: Uses atomic operations for exactly-once semantics
+// - lastRead/lastWrite: Uses atomic operations, updated by read loop and writers
+// - qLock: Ensures frames queued after writer goroutine exited are not left in outq
 type connection struct {
 	// wLock ensures write operations are serialized
 	wLock            sync.Mutex
 	dv               *Device            // device of this connection
 	adapter          ConAdapter         // real connection adapter
 	hub              *Hub               // hub of this connection
-	cancelFunc       context.CancelFunc // cancel function for ping goroutine
+	ctx              context.Context    // connection context, handler contexts are derived from it
+	cancelFunc       context.CancelFunc // cancel function of connection context, stops keepalive
 	closed           int32              // connection closed flag (atomic: 0=open, 1=closed)
 	offlineTriggered int32              // ensure offline state change is triggered only once (atomic)
+	offlineReason    string             // why offline is triggered, set by the only trigger, read by hub shard after state change
+	lastRead         atomic.Int64       // unix nano time of the last message read from peer
+	lastWrite        atomic.Int64       // unix nano time of the last successful write to peer
+	outq             chan *outFrame     // write queue drained by writer goroutine, nil if async write is disabled
+	qLock            sync.Mutex         // guards qClosed, and draining outq after writer goroutine exited
+	qClosed          bool               // writer goroutine has exited
+	inSeq            atomic.Uint64      // sequence of inbound messages
+	outSeq           atomic.Uint64      // sequence of outbound messages
+	strikes          atomic.Int32       // receive errors counted by error policy
+	lastSeenAt       atomic.Int64       // unix nano time last seen was recorded on inbound message
+	connectedAt      time.Time          // when the connection was registered
+	transport        string             // transport name, one of TransportXxx
+	bytesIn          atomic.Uint64      // bytes of messages read from peer
+	bytesOut         atomic.Uint64      // bytes of messages written to peer
+	msgsOut          atomic.Uint64      // messages written to peer, messages read are counted by inSeq
 }
 
 // conState is the state of connection
@@ -67,13 +86,36 @@ type ConAdapter interface {
 	ShareConn(adapter ConAdapter) bool
 }
 
+// ControlPinger is an optional interface for adapters able to send protocol-level ping, e.g. websocket ping control frame.
+// It's used for server keepalive if control ping is enabled, see WithHubConfigControlPing.
+type ControlPinger interface {
+	// ControlPing sends a protocol-level ping to peer.
+	// It's serialized with Write, and shouldn't block waiting for the pong.
+	ControlPing() error
+}
+
+// BatchWriter is an optional interface for adapters able to write several messages at once, e.g. with one writev syscall.
+// It's used by write queue to flush queued messages, see WithHubConfigWriteQueue.
+type BatchWriter interface {
+	// WriteBatch writes messages in order. It's serialized with Write.
+	WriteBatch(data [][]byte) error
+}
+
+// PongTracker is an optional interface for ControlPinger adapters able to observe pong replies.
+// Without it, peers are never closed for missing pongs.
+type PongTracker interface {
+	// LastPong returns when the last pong was received, zero time if none yet.
+	LastPong() time.Time
+}
+
 func (conn *connection) uid() interface{} {
 	return conn.dv.UID()
 }
 
-// triggerOffline triggers offline state change only once
-func (conn *connection) triggerOffline() {
+// triggerOffline triggers offline state change only once, reason is one of DisconnectReasonXxx or bye reason
+func (conn *connection) triggerOffline(reason string) {
 	if atomic.CompareAndSwapInt32(&conn.offlineTriggered, 0, 1) {
+		conn.offlineReason = reason
 		conn.hub.stateChange(conn, false)
 	}
 }
@@ -91,13 +133,42 @@ func (conn *connection) readLoop() {
 		b, err := conn.adapter.Read()
 		if err != nil {
 			slog.Debug("read err", "err", err)
-			conn.triggerOffline()
+			conn.triggerOffline(DisconnectReasonClosed)
 			return
 		}
-		conn.hub.receive(conn.dv, b)
+		conn.received(b)
 	}
 }
 
+// received passes message read from peer to hub
+func (conn *connection) received(b []byte) {
+	conn.lastRead.Store(time.Now().UnixNano())
+	conn.bytesIn.Add(uint64(len(b)))
+	conn.hub.touchLastSeen(conn)
+	conn.hub.receive(conn.messageContext(true, nil), conn, b)
+}
+
+// messageContext derives handler context of a message on this connection.
+// sendCtx is the context of Hub.Send for outbound message, nil if it's sent by hub itself.
+// Outbound message gets the connection context as is if no send handler reads it, see HubConfig.sendContext.
+func (conn *connection) messageContext(inbound bool, sendCtx context.Context) context.Context {
+	if !inbound && !conn.hub.config.sendContext {
+		return conn.ctx
+	}
+	info := MessageInfo{Inbound: inbound, Time: time.Now()}
+	if inbound {
+		info.Seq = conn.inSeq.Add(1)
+	} else {
+		info.Seq = conn.outSeq.Add(1)
+	}
+
+	ctx := withMessage(conn.ctx, info)
+	if sendCtx != nil {
+		ctx = context.WithValue(ctx, ctxKeySend, sendCtx)
+	}
+	return ctx
+}
+
 func (conn *connection) close() {
 	// Use atomic compare-and-swap to ensure close is called only once
 	if !atomic.CompareAndSwapInt32(&conn.closed, 0, 1) {
@@ -106,7 +177,7 @@ func (conn *connection) close() {
 
 	// Now we have exclusive access to close the connection
 	if conn.cancelFunc != nil {
-		conn.cancelFunc() // cancel ping goroutine
+		conn.cancelFunc() // stop keepalive
 	}
 	_ = conn.adapter.Close()
 }
@@ -120,8 +191,93 @@ func (conn *connection) Write(b []byte) error {
 	}
 
 	if err := conn.adapter.Write(b); err != nil {
-		conn.triggerOffline()
+		conn.triggerOffline(DisconnectReasonWriteFailed)
+		return err
+	}
+	conn.lastWrite.Store(time.Now().UnixNano())
+	conn.msgsOut.Add(1)
+	conn.bytesOut.Add(uint64(len(b)))
+	return nil
+}
+
+// writeBatch writes messages in order, with one call if adapter is a BatchWriter.
+// It returns how many leading messages are known to be written.
+func (conn *connection) writeBatch(data [][]byte) (int, error) {
+	bw, ok := conn.adapter.(BatchWriter)
+	if !ok || len(data) == 1 {
+		for i, b := range data {
+			if err := conn.Write(b); err != nil {
+				return i, err
+			}
+		}
+		return len(data), nil
+	}
+
+	conn.wLock.Lock()
+	defer conn.wLock.Unlock()
+
+	if conn.isClosed() {
+		return 0, errors.New("can't write to closed connection")
+	}
+
+	if err := bw.WriteBatch(data); err != nil {
+		conn.triggerOffline(DisconnectReasonWriteFailed)
+		return 0, err
+	}
+	conn.lastWrite.Store(time.Now().UnixNano())
+	conn.msgsOut.Add(uint64(len(data)))
+	for _, b := range data {
+		conn.bytesOut.Add(uint64(len(b)))
+	}
+	return len(data), nil
+}
+
+// controlPing sends protocol-level ping through adapter
+func (conn *connection) controlPing(pinger ControlPinger) error {
+	conn.wLock.Lock()
+	defer conn.wLock.Unlock()
+
+	if conn.isClosed() {
+		return errors.New("can't ping closed connection")
+	}
+
+	if err := pinger.ControlPing(); err != nil {
+		conn.triggerOffline(DisconnectReasonWriteFailed)
 		return err
 	}
+	conn.lastWrite.Store(time.Now().UnixNano())
 	return nil
 }
+
+// LastRead returns when the last message was read from peer, it's the connect time if nothing has been read
+func (conn *connection) LastRead() time.Time {
+	return time.Unix(0, conn.lastRead.Load())
+}
+
+// LastWrite returns when the last successful write to peer happened, zero time if nothing has been written
+func (conn *connection) LastWrite() time.Time {
+	if n := conn.lastWrite.Load(); n > 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
+// LastInbound returns when peer was last heard from, either a message or a pong
+func (conn *connection) LastInbound() time.Time {
+	last := conn.LastRead()
+	if tracker, ok := conn.adapter.(PongTracker); ok {
+		if pong := tracker.LastPong(); pong.After(last) {
+			last = pong
+		}
+	}
+	return last
+}
+
+// LastActivity returns the latest time of reading from and writing to peer
+func (conn *connection) LastActivity() time.Time {
+	last := conn.LastInbound()
+	if w := conn.LastWrite(); w.After(last) {
+		last = w
+	}
+	return last
+}
@@ -0,0 +1,206 @@
+/**
+ * introspection of connections
+ */
+
+package tok
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+)
+
+// Transport names of connections, see ConnInfo.Transport
+const (
+	TransportTCP     = "tcp"     // built-in tcp listener
+	TransportX       = "x"       // websocket of golang.org/x/net/websocket
+	TransportGorilla = "gorilla" // websocket of github.com/gorilla/websocket
+	TransportCoder   = "coder"   // websocket of github.com/coder/websocket
+	TransportCustom  = "custom"  // custom adapter, see Hub.RegisterConnection
+)
+
+// transporter is implemented by built-in adapters to report their transport name
+type transporter interface {
+	transport() string
+}
+
+// transportOf returns transport name of adapter
+func transportOf(adapter ConAdapter) string {
+	if t, ok := adapter.(transporter); ok {
+		return t.transport()
+	}
+	return TransportCustom
+}
+
+// ConnInfo is a snapshot of connection, see Hub.Connections and Hub.Devices
+type ConnInfo struct {
+	UID          interface{} // user id
+	DeviceID     string      // device id, could be empty
+	RemoteAddr   string      // remote address of peer, empty if unknown
+	Transport    string      // one of TransportXxx
+	ConnectedAt  time.Time   // when the connection was registered
+	LastRead     time.Time   // when message or pong was last read from peer, ConnectedAt if nothing has been read
+	LastWrite    time.Time   // when the last successful write to peer happened, zero if nothing has been written
+	LastActivity time.Time   // latest time of reading from and writing to peer
+	BytesIn      uint64      // bytes of messages read from peer
+	BytesOut     uint64      // bytes of messages written to peer
+	MessagesIn   uint64      // messages read from peer
+	MessagesOut  uint64      // messages written to peer, including pings generated by PingGenerator
+}
+
+// ConnFilter selects connections for Hub.Connections
+type ConnFilter func(info *ConnInfo) bool
+
+// connsFrame queries connections of a shard
+type connsFrame struct {
+	uid     interface{}        // user id, ignored if all is true
+	all     bool               // all connections of shard
+	chConns chan []*connection // channel to return connections
+}
+
+// conns returns connections of f, it's called in shard loop
+func (p *hubShard) conns(f *connsFrame) []*connection {
+	if !f.all {
+		return append([]*connection(nil), p.cons[f.uid]...)
+	}
+	var result []*connection
+	for _, l := range p.cons {
+		result = append(result, l...)
+	}
+	return result
+}
+
+// onlineCount is count of online uids and connections
+type onlineCount struct {
+	uids  int
+	conns int
+}
+
+// count counts online uids and connections of shard, it's called in shard loop
+func (p *hubShard) count() onlineCount {
+	c := onlineCount{uids: len(p.cons)}
+	for _, l := range p.cons {
+		c.conns += len(l)
+	}
+	return c
+}
+
+// info returns snapshot of connection
+func (conn *connection) info() ConnInfo {
+	info := ConnInfo{
+		UID:          conn.uid(),
+		DeviceID:     conn.dv.ID(),
+		Transport:    conn.transport,
+		ConnectedAt:  conn.connectedAt,
+		LastRead:     conn.LastInbound(),
+		LastWrite:    conn.LastWrite(),
+		LastActivity: conn.LastActivity(),
+		BytesIn:      conn.bytesIn.Load(),
+		BytesOut:     conn.bytesOut.Load(),
+		MessagesIn:   conn.inSeq.Load(),
+		MessagesOut:  conn.msgsOut.Load(),
+	}
+	if addr := conn.dv.RemoteAddr(); addr != nil {
+		info.RemoteAddr = addr.String()
+	}
+	return info
+}
+
+// Connections returns snapshots of online connections selected by filter, nil filter selects all.
+// It returns nil if ctx is done before all shards answered.
+func (p *Hub) Connections(ctx context.Context, filter ConnFilter) []ConnInfo {
+	if ctx.Err() != nil {
+		return nil
+	}
+	chs := make([]chan []*connection, 0, len(p.shards))
+	for _, shard := range p.shards {
+		f := &connsFrame{all: true, chConns: make(chan []*connection, 1)}
+		select {
+		case shard.chConns <- f:
+		case <-ctx.Done():
+			return nil
+		}
+		chs = append(chs, f.chConns)
+	}
+
+	result := make([]ConnInfo, 0)
+	for _, ch := range chs {
+		select {
+		case l := <-ch:
+			for _, conn := range l {
+				info := conn.info()
+				if filter == nil || filter(&info) {
+					result = append(result, info)
+				}
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return result
+}
+
+// OnlineCount returns count of online uids and their connections, without taking snapshots.
+// It returns zeros if ctx is done before all shards answered.
+func (p *Hub) OnlineCount(ctx context.Context) (uids, conns int) {
+	if ctx.Err() != nil {
+		return 0, 0
+	}
+	chs := make([]chan onlineCount, 0, len(p.shards))
+	for _, shard := range p.shards {
+		ch := make(chan onlineCount, 1)
+		select {
+		case shard.chCount <- ch:
+		case <-ctx.Done():
+			return 0, 0
+		}
+		chs = append(chs, ch)
+	}
+
+	for _, ch := range chs {
+		select {
+		case c := <-ch:
+			uids += c.uids
+			conns += c.conns
+		case <-ctx.Done():
+			return 0, 0
+		}
+	}
+	return uids, conns
+}
+
+// Devices returns snapshots of online connections of uid.
+// It returns nil if ctx is done before the answer.
+func (p *Hub) Devices(ctx context.Context, uid interface{}) []ConnInfo {
+	if ctx.Err() != nil {
+		return nil
+	}
+	f := &connsFrame{uid: uid, chConns: make(chan []*connection, 1)}
+	select {
+	case p.shard(uid).chConns <- f:
+	case <-ctx.Done():
+		return nil
+	}
+
+	select {
+	case l := <-f.chConns:
+		result := make([]ConnInfo, 0, len(l))
+		for _, conn := range l {
+			result = append(result, conn.info())
+		}
+		return result
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// requestAddr returns remote address of http request, nil if it's not ip:port
+func requestAddr(r *http.Request) net.Addr {
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return net.TCPAddrFromAddrPort(ap)
+}
@@ -0,0 +1,146 @@
+package tok_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gorilla/websocket"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	"go.uber.org/mock/gomock"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/mocks"
+)
+
+var _ = Describe("ConnInfo", func() {
+	var mockActor *mocks.MockActor
+
+	BeforeEach(func() {
+		mockActor = mocks.NewMockActor(ctl)
+	})
+
+	create := func(opts ...tok.HubConfigOption) *tok.Hub {
+		opts = append([]tok.HubConfigOption{
+			tok.WithHubConfigPingProducer(mocks.NewMockPingGenerator(ctl)),
+			tok.WithHubConfigQueue(nil),
+		}, opts...)
+		hub, _ := tok.CreateWsHandler(nil, tok.WithWsHandlerHubConfig(tok.NewHubConfig(mockActor, opts...)))
+		return hub
+	}
+
+	It("should list connections and devices", func() {
+		hub := create(tok.WithHubConfigSso(false), tok.WithHubConfigShards(4))
+		for _, dv := range []*tok.Device{
+			tok.CreateDevice("u1", "phone"),
+			tok.CreateDevice("u1", "tablet"),
+			tok.CreateDevice("u2", "phone"),
+		} {
+			adapter := &benchAdapter{chClosed: make(chan struct{})}
+			DeferCleanup(adapter.Close)
+			go hub.RegisterConnection(ctx, dv, adapter)
+		}
+		Eventually(func() []tok.ConnInfo { return hub.Connections(ctx, nil) }).Should(HaveLen(3))
+		uids, conns := hub.OnlineCount(ctx)
+		Ω(uids).To(Equal(2))
+		Ω(conns).To(Equal(3))
+
+		phones := hub.Connections(ctx, func(info *tok.ConnInfo) bool { return info.DeviceID == "phone" })
+		Ω(phones).To(ConsistOf(
+			MatchFields(IgnoreExtras, Fields{"UID": Equal("u1")}),
+			MatchFields(IgnoreExtras, Fields{"UID": Equal("u2")}),
+		))
+
+		devices := hub.Devices(ctx, "u1")
+		Ω(devices).To(HaveLen(2))
+		for _, info := range devices {
+			Ω(info).To(MatchFields(IgnoreExtras, Fields{
+				"UID":          Equal("u1"),
+				"DeviceID":     BeElementOf("phone", "tablet"),
+				"RemoteAddr":   BeEmpty(),
+				"Transport":    Equal(tok.TransportCustom),
+				"ConnectedAt":  Not(BeZero()),
+				"LastRead":     BeTemporally("==", info.ConnectedAt),
+				"LastWrite":    BeZero(),
+				"LastActivity": BeTemporally("==", info.ConnectedAt),
+			}))
+		}
+		Ω(hub.Devices(ctx, "nobody")).To(BeEmpty())
+	})
+
+	It("should count messages in and out", func() {
+		hub := create()
+		mockAdapter := mocks.NewMockConAdapter(ctl)
+		chRead := make(chan []byte)
+		chClosed := make(chan struct{})
+		mockAdapter.EXPECT().Read().DoAndReturn(func() ([]byte, error) {
+			select {
+			case b := <-chRead:
+				return b, nil
+			case <-chClosed:
+				return nil, io.EOF
+			}
+		}).AnyTimes()
+		mockAdapter.EXPECT().Write(gomock.Any()).Times(2)
+		mockAdapter.EXPECT().Close().DoAndReturn(func() error {
+			close(chClosed)
+			return nil
+		})
+		mockActor.EXPECT().OnReceive(gomock.Any(), []byte("hello"))
+
+		go hub.RegisterConnection(ctx, tok.CreateDevice("u1", "phone"), mockAdapter)
+		Eventually(func() bool { return hub.CheckOnline(ctx, "u1") }).Should(BeTrue())
+
+		chRead <- []byte("hello")
+		Ω(hub.Send(ctx, "u1", []byte("abc"), 0)).To(Succeed())
+		Ω(hub.Send(ctx, "u1", []byte("de"), 0)).To(Succeed())
+
+		Eventually(func() []tok.ConnInfo { return hub.Devices(ctx, "u1") }).Should(ConsistOf(MatchFields(IgnoreExtras, Fields{
+			"BytesIn":     BeEquivalentTo(5),
+			"MessagesIn":  BeEquivalentTo(1),
+			"BytesOut":    BeEquivalentTo(5),
+			"MessagesOut": BeEquivalentTo(2),
+		})))
+		info := hub.Devices(ctx, "u1")[0]
+		Ω(info.LastRead).To(BeTemporally(">", info.ConnectedAt))
+		Ω(info.LastWrite).To(BeTemporally(">", info.ConnectedAt))
+		last := info.LastRead
+		if info.LastWrite.After(last) {
+			last = info.LastWrite
+		}
+		Ω(info.LastActivity).To(BeTemporally("==", last))
+
+		hub.Kick(ctx, "u1")
+		Eventually(chClosed).Should(BeClosed())
+	})
+
+	DescribeTable("should report transport and remote address of websocket",
+		func(engine tok.WsEngine, transport string) {
+			auth := func(r *http.Request) (*tok.Device, error) {
+				return tok.CreateDevice("u1", ""), nil
+			}
+			hub, hdl := tok.CreateWsHandler(auth,
+				tok.WithWsHandlerEngine(engine),
+				tok.WithWsHandlerHubConfig(tok.NewHubConfig(mockActor,
+					tok.WithHubConfigPingProducer(mocks.NewMockPingGenerator(ctl)),
+					tok.WithHubConfigQueue(nil))))
+			server := httptest.NewServer(hdl)
+			DeferCleanup(server.Close)
+
+			// x/net/websocket requires Origin header
+			ws, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], http.Header{"Origin": {server.URL}})
+			Ω(err).To(Succeed())
+			DeferCleanup(ws.Close)
+
+			Eventually(func() []tok.ConnInfo { return hub.Devices(ctx, "u1") }).Should(ConsistOf(MatchFields(IgnoreExtras, Fields{
+				"Transport":  Equal(transport),
+				"RemoteAddr": Equal(ws.LocalAddr().String()),
+			})))
+		},
+		Entry("x", tok.WsEngineX, tok.TransportX),
+		Entry("gorilla", tok.WsEngineGorilla, tok.TransportGorilla),
+		Entry("coder", tok.WsEngineCoder, tok.TransportCoder),
+	)
+})
@@ -0,0 +1,131 @@
+/**
+ * handler contexts, and adapters of handlers without context
+ */
+
+package tok
+
+import (
+	"context"
+	"time"
+)
+
+type ctxKey int
+
+const (
+	ctxKeyDevice ctxKey = iota
+	ctxKeyMessage
+	ctxKeySend
+	ctxKeyRequest
+)
+
+// MessageInfo carries message-level values in handler context
+type MessageInfo struct {
+	Inbound bool      // true for message received from device, false for message sent to device
+	Seq     uint64    // sequence number of message in its direction on the connection, starts from 1
+	Time    time.Time // when message was read from connection, or handed to connection for sending
+}
+
+// DeviceFromContext returns device of the connection which ctx is derived from, nil if none
+func DeviceFromContext(ctx context.Context) *Device {
+	dv, _ := ctx.Value(ctxKeyDevice).(*Device)
+	return dv
+}
+
+// MessageInfoFromContext returns message-level values of handler context
+func MessageInfoFromContext(ctx context.Context) (MessageInfo, bool) {
+	info, ok := ctx.Value(ctxKeyMessage).(MessageInfo)
+	return info, ok
+}
+
+// SendContext returns context passed to Hub.Send for outgoing message, e.g. to propagate trace of sender.
+// It's nil for messages sent by hub itself, e.g. ping and bye.
+func SendContext(ctx context.Context) context.Context {
+	sendCtx, _ := ctx.Value(ctxKeySend).(context.Context)
+	return sendCtx
+}
+
+// withMessage derives handler context of message from connection context
+func withMessage(connCtx context.Context, info MessageInfo) context.Context {
+	return context.WithValue(connCtx, ctxKeyMessage, info)
+}
+
+// sendContextAware reports whether any send handler reads handler context, handlers adapted from ones without context don't
+func sendContextAware(before ContextBeforeSendHandler, after ContextAfterSendHandler) bool {
+	if _, adapted := before.(beforeSendAdapter); before != nil && !adapted {
+		return true
+	}
+	if _, adapted := after.(afterSendAdapter); after != nil && !adapted {
+		return true
+	}
+	return false
+}
+
+// AdaptActor adapts Actor to ContextActor, ctx is ignored. It returns nil for nil
+func AdaptActor(actor Actor) ContextActor {
+	if actor == nil {
+		return nil
+	}
+	return actorAdapter{actor}
+}
+
+type actorAdapter struct{ actor Actor }
+
+func (p actorAdapter) OnReceive(_ context.Context, dv *Device, data []byte) {
+	p.actor.OnReceive(dv, data)
+}
+
+// AdaptBeforeReceiveHandler adapts BeforeReceiveHandler to ContextBeforeReceiveHandler, ctx is ignored. It returns nil for nil
+func AdaptBeforeReceiveHandler(hdl BeforeReceiveHandler) ContextBeforeReceiveHandler {
+	if hdl == nil {
+		return nil
+	}
+	return beforeReceiveAdapter{hdl}
+}
+
+type beforeReceiveAdapter struct{ hdl BeforeReceiveHandler }
+
+func (p beforeReceiveAdapter) BeforeReceive(_ context.Context, dv *Device, data []byte) ([]byte, error) {
+	return p.hdl.BeforeReceive(dv, data)
+}
+
+// AdaptBeforeSendHandler adapts BeforeSendHandler to ContextBeforeSendHandler, ctx is ignored. It returns nil for nil
+func AdaptBeforeSendHandler(hdl BeforeSendHandler) ContextBeforeSendHandler {
+	if hdl == nil {
+		return nil
+	}
+	return beforeSendAdapter{hdl}
+}
+
+type beforeSendAdapter struct{ hdl BeforeSendHandler }
+
+func (p beforeSendAdapter) BeforeSend(_ context.Context, dv *Device, data []byte) ([]byte, error) {
+	return p.hdl.BeforeSend(dv, data)
+}
+
+// AdaptAfterSendHandler adapts AfterSendHandler to ContextAfterSendHandler, ctx is ignored. It returns nil for nil
+func AdaptAfterSendHandler(hdl AfterSendHandler) ContextAfterSendHandler {
+	if hdl == nil {
+		return nil
+	}
+	return afterSendAdapter{hdl}
+}
+
+type afterSendAdapter struct{ hdl AfterSendHandler }
+
+func (p afterSendAdapter) AfterSend(_ context.Context, dv *Device, data []byte) {
+	p.hdl.AfterSend(dv, data)
+}
+
+// AdaptCloseHandler adapts CloseHandler to ContextCloseHandler, ctx is ignored. It returns nil for nil
+func AdaptCloseHandler(hdl CloseHandler) ContextCloseHandler {
+	if hdl == nil {
+		return nil
+	}
+	return closeAdapter{hdl}
+}
+
+type closeAdapter struct{ hdl CloseHandler }
+
+func (p closeAdapter) OnClose(_ context.Context, dv *Device) {
+	p.hdl.OnClose(dv)
+}
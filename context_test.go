@@ -0,0 +1,156 @@
+package tok_test
+
+import (
+	"context"
+	"io"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/mocks"
+)
+
+type traceKey struct{}
+
+var _ = Describe("Context", func() {
+	var (
+		mockAdapter *mocks.MockConAdapter
+		mockActor   *mocks.MockContextActor
+		mockBefore  *mocks.MockContextBeforeSendHandler
+		mockAfter   *mocks.MockContextAfterSendHandler
+		mockClose   *mocks.MockContextCloseHandler
+		hub         *tok.Hub
+		device      *tok.Device
+		chRead      chan []byte
+		chClosed    chan struct{}
+		connCtx     context.Context
+	)
+
+	BeforeEach(func() {
+		mockAdapter = mocks.NewMockConAdapter(ctl)
+		mockActor = mocks.NewMockContextActor(ctl)
+		mockBefore = mocks.NewMockContextBeforeSendHandler(ctl)
+		mockAfter = mocks.NewMockContextAfterSendHandler(ctl)
+		mockClose = mocks.NewMockContextCloseHandler(ctl)
+
+		config := tok.NewHubConfigContext(mockActor,
+			tok.WithHubConfigPingProducer(mocks.NewMockPingGenerator(ctl)),
+			tok.WithHubConfigQueue(nil),
+			tok.WithHubConfigContextBeforeSend(mockBefore),
+			tok.WithHubConfigContextAfterSend(mockAfter),
+			tok.WithHubConfigContextCloseHandler(mockClose))
+		hub, _ = tok.CreateWsHandler(nil, tok.WithWsHandlerHubConfig(config))
+		device = tok.CreateDevice("ctx-user", "ctx-session")
+
+		read := make(chan []byte)
+		closed := make(chan struct{})
+		mockAdapter.EXPECT().Read().DoAndReturn(func() ([]byte, error) {
+			select {
+			case b := <-read:
+				return b, nil
+			case <-closed:
+				return nil, io.EOF
+			}
+		}).AnyTimes()
+		mockAdapter.EXPECT().Close().DoAndReturn(func() error {
+			close(closed)
+			return nil
+		})
+		mockAdapter.EXPECT().Write(gomock.Any()).Return(nil).AnyTimes()
+		chRead, chClosed = read, closed
+
+		connCtx = context.WithValue(ctx, traceKey{}, "conn-trace")
+		go hub.RegisterConnection(connCtx, device, mockAdapter)
+		Eventually(func() bool { return hub.CheckOnline(ctx, "ctx-user") }).Should(BeTrue())
+	})
+
+	AfterEach(func() {
+		mockClose.EXPECT().OnClose(gomock.Any(), device).AnyTimes()
+		hub.Kick(ctx, "ctx-user")
+		Eventually(chClosed).Should(BeClosed())
+	})
+
+	It("should derive receive context from connection context", func() {
+		chCtx := make(chan context.Context, 2)
+		mockActor.EXPECT().OnReceive(gomock.Any(), device, gomock.Any()).Do(func(ctx context.Context, _ *tok.Device, _ []byte) {
+			chCtx <- ctx
+		}).Times(2)
+
+		chRead <- []byte("m1")
+		chRead <- []byte("m2")
+
+		var seqs []uint64
+		for i := 0; i < 2; i++ {
+			var c context.Context
+			Eventually(chCtx).Should(Receive(&c))
+			Ω(c.Value(traceKey{})).To(Equal("conn-trace"))
+			Ω(tok.DeviceFromContext(c)).To(Equal(device))
+			info, ok := tok.MessageInfoFromContext(c)
+			Ω(ok).To(BeTrue())
+			Ω(info.Inbound).To(BeTrue())
+			Ω(info.Time.IsZero()).To(BeFalse())
+			seqs = append(seqs, info.Seq)
+		}
+		Ω(seqs).To(ConsistOf(uint64(1), uint64(2)))
+	})
+
+	It("should carry sender context to send handlers", func() {
+		chCtx := make(chan context.Context, 1)
+		mockBefore.EXPECT().BeforeSend(gomock.Any(), device, []byte("hi")).DoAndReturn(
+			func(ctx context.Context, _ *tok.Device, data []byte) ([]byte, error) {
+				Ω(ctx.Value(traceKey{})).To(Equal("conn-trace"))
+				Ω(tok.SendContext(ctx).Value(traceKey{})).To(Equal("send-trace"))
+				info, ok := tok.MessageInfoFromContext(ctx)
+				Ω(ok).To(BeTrue())
+				Ω(info.Inbound).To(BeFalse())
+				Ω(info.Seq).To(BeEquivalentTo(1))
+				return data, nil
+			})
+		mockAfter.EXPECT().AfterSend(gomock.Any(), device, []byte("hi")).Do(func(ctx context.Context, _ *tok.Device, _ []byte) {
+			chCtx <- ctx
+		})
+
+		sendCtx := context.WithValue(ctx, traceKey{}, "send-trace")
+		Ω(hub.Send(sendCtx, "ctx-user", []byte("hi"), 0)).To(Succeed())
+
+		var c context.Context
+		Eventually(chCtx).Should(Receive(&c))
+		Ω(tok.SendContext(c)).To(Equal(sendCtx))
+	})
+
+	It("should keep connection values in close context", func() {
+		chCtx := make(chan context.Context, 1)
+		mockClose.EXPECT().OnClose(gomock.Any(), device).Do(func(ctx context.Context, _ *tok.Device) {
+			chCtx <- ctx
+		})
+
+		hub.Kick(ctx, "ctx-user")
+		var c context.Context
+		Eventually(chCtx).Should(Receive(&c))
+		Ω(c.Err()).To(Succeed())
+		Ω(c.Value(traceKey{})).To(Equal("conn-trace"))
+		Ω(tok.DeviceFromContext(c)).To(Equal(device))
+	})
+
+	It("should build send context only for context-aware send handlers", func() {
+		plain := tok.NewHubConfigContext(mockActor,
+			tok.WithHubConfigBeforeSend(mocks.NewMockBeforeSendHandler(ctl)),
+			tok.WithHubConfigAfterSend(mocks.NewMockAfterSendHandler(ctl)))
+		Ω(plain.SendContext()).To(BeFalse())
+		Ω(tok.NewHubConfigContext(mockActor).SendContext()).To(BeFalse())
+
+		Ω(tok.NewHubConfigContext(mockActor, tok.WithHubConfigContextBeforeSend(mockBefore)).SendContext()).To(BeTrue())
+		Ω(tok.NewHubConfigContext(mockActor, tok.WithHubConfigContextAfterSend(mockAfter)).SendContext()).To(BeTrue())
+	})
+
+	It("should adapt handlers without context", func() {
+		Ω(tok.AdaptActor(nil)).To(BeNil())
+		Ω(tok.AdaptCloseHandler(nil)).To(BeNil())
+
+		actor := mocks.NewMockActor(ctl)
+		actor.EXPECT().OnReceive(device, []byte("m"))
+		tok.AdaptActor(actor).OnReceive(ctx, device, []byte("m"))
+	})
+})
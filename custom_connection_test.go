@@ -1,7 +1,11 @@
 package tok_test
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -187,4 +191,267 @@ var _ = Describe("Custom Connection", func() {
 			time.Sleep(50 * time.Millisecond)
 		})
 	})
+
+	Describe("Idle timeout", func() {
+		BeforeEach(func() {
+			mockPing := mocks.NewMockPingGenerator(ctl)
+			config := tok.NewHubConfig(mockActor,
+				tok.WithHubConfigPingProducer(mockPing),
+				tok.WithHubConfigIdleTimeout(100*time.Millisecond))
+			hub, _ = tok.CreateWsHandler(nil, tok.WithWsHandlerHubConfig(config))
+		})
+
+		// expectRead makes Read return msg every interval until adapter is closed
+		expectRead := func(interval time.Duration, msg []byte) <-chan struct{} {
+			chClosed := make(chan struct{})
+			mockAdapter.EXPECT().Close().DoAndReturn(func() error {
+				close(chClosed)
+				return nil
+			}).Times(1)
+			mockAdapter.EXPECT().Read().DoAndReturn(func() ([]byte, error) {
+				select {
+				case <-chClosed:
+					return nil, io.EOF
+				case <-time.After(interval):
+					return msg, nil
+				}
+			}).AnyTimes()
+			return chClosed
+		}
+
+		It("should close silent connection", func() {
+			chClosed := expectRead(time.Hour, nil)
+
+			go hub.RegisterConnection(ctx, device, mockAdapter)
+
+			Eventually(func() bool { return hub.CheckOnline(ctx, "custom-user") }).Should(BeTrue())
+			Eventually(chClosed).Should(BeClosed())
+			Ω(hub.CheckOnline(ctx, "custom-user")).To(BeFalse())
+		})
+
+		It("should keep active connection", func() {
+			msgData := []byte("heartbeat")
+			chClosed := expectRead(30*time.Millisecond, msgData)
+			mockActor.EXPECT().OnReceive(gomock.Any(), msgData).AnyTimes()
+
+			go hub.RegisterConnection(ctx, device, mockAdapter)
+
+			Eventually(func() bool { return hub.CheckOnline(ctx, "custom-user") }).Should(BeTrue())
+			Consistently(func() bool { return hub.CheckOnline(ctx, "custom-user") }, 300*time.Millisecond).Should(BeTrue())
+
+			hub.Kick(ctx, "custom-user")
+			Eventually(chClosed).Should(BeClosed())
+		})
+	})
+
+	Describe("Write queue", func() {
+		var (
+			mockQueue *mocks.MockQueue
+			chClosed  chan struct{}
+			chRelease chan struct{}
+			chWritten chan []byte
+		)
+
+		// register creates hub with write queue of size 1, and registers a connection whose Write blocks until released
+		register := func(policy tok.SlowConsumerPolicy) {
+			mockQueue = mocks.NewMockQueue(ctl)
+			mockQueue.EXPECT().Deq(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+			config := tok.NewHubConfig(mockActor,
+				tok.WithHubConfigPingProducer(mocks.NewMockPingGenerator(ctl)),
+				tok.WithHubConfigQueue(mockQueue),
+				tok.WithHubConfigWriteQueue(1, policy))
+			hub, _ = tok.CreateWsHandler(nil, tok.WithWsHandlerHubConfig(config))
+
+			closed := make(chan struct{})
+			release := make(chan struct{})
+			writes := make(chan []byte, 10)
+			chWriting := make(chan struct{}, 10)
+			mockAdapter.EXPECT().Read().DoAndReturn(func() ([]byte, error) {
+				<-closed
+				return nil, io.EOF
+			})
+			mockAdapter.EXPECT().Close().DoAndReturn(func() error {
+				close(closed)
+				return nil
+			})
+			mockAdapter.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) error {
+				chWriting <- struct{}{}
+				<-release
+				writes <- b
+				return nil
+			}).AnyTimes()
+			chWritten, chClosed, chRelease = writes, closed, release
+
+			go hub.RegisterConnection(ctx, device, mockAdapter)
+			Eventually(func() bool { return hub.CheckOnline(ctx, "custom-user") }).Should(BeTrue())
+
+			// m1 blocks writer goroutine, m2 fills the queue
+			Ω(hub.Send(ctx, "custom-user", []byte("m1"), 0)).To(Succeed())
+			Eventually(chWriting).Should(Receive())
+			Ω(hub.Send(ctx, "custom-user", []byte("m2"), 60)).To(Succeed())
+		}
+
+		It("should drop newest message", func() {
+			register(tok.SlowConsumerDropNewest)
+
+			Ω(hub.Send(ctx, "custom-user", []byte("m3"), 0)).To(MatchError(tok.ErrSlowConsumer))
+
+			close(chRelease)
+			Eventually(chWritten).Should(Receive(Equal([]byte("m1"))))
+			Eventually(chWritten).Should(Receive(Equal([]byte("m2"))))
+			Consistently(chWritten, 50*time.Millisecond).ShouldNot(Receive())
+			hub.Kick(ctx, "custom-user")
+			Eventually(chClosed).Should(BeClosed())
+		})
+
+		It("should drop oldest message", func() {
+			register(tok.SlowConsumerDropOldest)
+
+			Ω(hub.Send(ctx, "custom-user", []byte("m3"), 0)).To(Succeed())
+
+			close(chRelease)
+			Eventually(chWritten).Should(Receive(Equal([]byte("m1"))))
+			Eventually(chWritten).Should(Receive(Equal([]byte("m3"))))
+			Consistently(chWritten, 50*time.Millisecond).ShouldNot(Receive())
+			hub.Kick(ctx, "custom-user")
+			Eventually(chClosed).Should(BeClosed())
+		})
+
+		It("should disconnect slow consumer and cache messages", func() {
+			register(tok.SlowConsumerDisconnect)
+
+			gomock.InOrder(
+				mockQueue.EXPECT().Enq(gomock.Any(), "custom-user", []byte("m2"), uint32(60)),
+				mockQueue.EXPECT().Enq(gomock.Any(), "custom-user", []byte("m3"), uint32(60)),
+			)
+			Ω(hub.Send(ctx, "custom-user", []byte("m3"), 60)).To(Succeed())
+
+			Eventually(chClosed).Should(BeClosed())
+			Ω(hub.CheckOnline(ctx, "custom-user")).To(BeFalse())
+			close(chRelease)
+		})
+
+		It("should cache messages queued while writer exits", func() {
+			cached := make(chan string, 1000)
+			mockQueue = mocks.NewMockQueue(ctl)
+			mockQueue.EXPECT().Deq(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+			mockQueue.EXPECT().Enq(gomock.Any(), "custom-user", gomock.Any(), uint32(60)).DoAndReturn(
+				func(_ context.Context, _ interface{}, b []byte, _ uint32) error {
+					cached <- string(b)
+					return nil
+				}).AnyTimes()
+			config := tok.NewHubConfig(mockActor,
+				tok.WithHubConfigPingProducer(mocks.NewMockPingGenerator(ctl)),
+				tok.WithHubConfigQueue(mockQueue),
+				tok.WithHubConfigWriteQueue(100, tok.SlowConsumerDropNewest))
+			hub, _ = tok.CreateWsHandler(nil, tok.WithWsHandlerHubConfig(config))
+
+			closed := make(chan struct{})
+			mockAdapter.EXPECT().Read().DoAndReturn(func() ([]byte, error) {
+				<-closed
+				return nil, io.EOF
+			})
+			mockAdapter.EXPECT().Close().DoAndReturn(func() error {
+				close(closed)
+				return nil
+			})
+			// writer goroutine exits on the 11th write
+			var writes atomic.Int32
+			written := make(chan string, 1000)
+			mockAdapter.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) error {
+				if writes.Add(1) > 10 {
+					return io.ErrClosedPipe
+				}
+				written <- string(b)
+				return nil
+			}).AnyTimes()
+
+			go hub.RegisterConnection(ctx, device, mockAdapter)
+			Eventually(func() bool { return hub.CheckOnline(ctx, "custom-user") }).Should(BeTrue())
+
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					for j := 0; j < 50; j++ {
+						Ω(hub.Send(ctx, "custom-user", []byte(fmt.Sprintf("m%d-%d", i, j)), 60)).To(Succeed())
+					}
+				}()
+			}
+			wg.Wait()
+			Eventually(closed).Should(BeClosed())
+
+			// every message is written or cached exactly once
+			count := func() int { return len(written) + len(cached) }
+			Eventually(count).Should(Equal(200))
+			Consistently(count, 50*time.Millisecond).Should(Equal(200))
+			seen := make(map[string]bool)
+			for len(written) > 0 {
+				seen[<-written] = true
+			}
+			for len(cached) > 0 {
+				seen[<-cached] = true
+			}
+			Ω(seen).To(HaveLen(200))
+		})
+	})
+	Describe("Context", func() {
+		var (
+			chClosed  chan struct{}
+			chRelease chan struct{}
+		)
+
+		BeforeEach(func() {
+			closed := make(chan struct{})
+			release := make(chan struct{})
+			mockAdapter.EXPECT().Read().DoAndReturn(func() ([]byte, error) {
+				<-closed
+				return nil, io.EOF
+			})
+			mockAdapter.EXPECT().Close().DoAndReturn(func() error {
+				close(closed)
+				return nil
+			})
+			// Write blocks until released
+			mockAdapter.EXPECT().Write(gomock.Any()).DoAndReturn(func([]byte) error {
+				<-release
+				return nil
+			}).AnyTimes()
+			chClosed, chRelease = closed, release
+
+			go hub.RegisterConnection(ctx, device, mockAdapter)
+			Eventually(func() bool { return hub.CheckOnline(ctx, "custom-user") }).Should(BeTrue())
+		})
+
+		AfterEach(func() {
+			close(chRelease)
+			hub.Kick(ctx, "custom-user")
+			Eventually(chClosed).Should(BeClosed())
+		})
+
+		It("should stop waiting for blocked send", func() {
+			tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			Ω(hub.Send(tctx, "custom-user", []byte("m1"), 0)).To(MatchError(context.DeadlineExceeded))
+			Ω(time.Since(start)).To(BeNumerically("<", time.Second))
+
+			// hub is still responsive
+			Ω(hub.CheckOnline(ctx, "custom-user")).To(BeTrue())
+			Ω(hub.Online(ctx)).To(ConsistOf("custom-user"))
+		})
+
+		It("should give up queries if ctx is done", func() {
+			cctx, cancel := context.WithCancel(ctx)
+			cancel()
+
+			Ω(hub.CheckOnline(cctx, "custom-user")).To(BeFalse())
+			Ω(hub.Online(cctx)).To(BeNil())
+			hub.Kick(cctx, "custom-user")
+			Consistently(func() bool { return hub.CheckOnline(ctx, "custom-user") }, 50*time.Millisecond).Should(BeTrue())
+		})
+	})
 })
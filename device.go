@@ -1,7 +1,9 @@
 package tok
 
 import (
+	"net"
 	"sync"
+	"sync/atomic"
 )
 
 // CreateDevice uid is user id, id is uuid of this device(could be empty)
@@ -11,9 +13,10 @@ func CreateDevice(uid interface{}, id string) *Device {
 
 // Device device struct
 type Device struct {
-	uid  interface{}
-	id   string
-	meta sync.Map
+	uid        interface{}
+	id         string
+	meta       sync.Map
+	remoteAddr atomic.Pointer[net.Addr] // remote address of the connection, set by tok after auth
 }
 
 // UID return user id
@@ -26,6 +29,24 @@ func (p *Device) ID() string {
 	return p.id
 }
 
+// RemoteAddr return remote address of device connection(could be nil).
+// For tcp listener with PROXY protocol enabled, it's the real client address carried by the PROXY header.
+// For websocket, it's the remote address of http request, which might be a reverse proxy.
+// It's set once auth function returned, see TCPAddrAuthFunc for the address during tcp auth.
+func (p *Device) RemoteAddr() net.Addr {
+	if addr := p.remoteAddr.Load(); addr != nil {
+		return *addr
+	}
+	return nil
+}
+
+// setRemoteAddr records remote address of device connection, nil is ignored
+func (p *Device) setRemoteAddr(addr net.Addr) {
+	if addr != nil {
+		p.remoteAddr.Store(&addr)
+	}
+}
+
 // GetMeta return device meta
 func (p *Device) GetMeta(key string) string {
 	if v, ok := p.meta.Load(key); ok {
@@ -39,3 +60,13 @@ func (p *Device) GetMeta(key string) string {
 func (p *Device) PutMeta(key string, val string) {
 	p.meta.Store(key, val)
 }
+
+// Meta return copy of all device meta
+func (p *Device) Meta() map[string]string {
+	m := make(map[string]string)
+	p.meta.Range(func(k, v interface{}) bool {
+		m[k.(string)] = v.(string)
+		return true
+	})
+	return m
+}
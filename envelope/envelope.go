@@ -0,0 +1,32 @@
+// Package envelope is an optional message layer over tok.
+// Inbound frames are decoded into Envelope {type, id, payload} by pluggable Codec,
+// and dispatched to handlers registered by message type through Router, which is a tok.ActorWithResult.
+// Outbound messages are encoded by the same codec, see Send.
+package envelope
+
+import (
+	"errors"
+)
+
+// ErrUnknownType occurs while no handler is registered for message type, and there is no fallback handler
+var ErrUnknownType = errors.New("envelope: unknown message type")
+
+// Envelope is a decoded frame
+type Envelope struct {
+	Type    string // message type, used for routing
+	ID      string // optional message id, e.g. for correlation or dedup
+	Payload []byte // payload encoded by codec, see Codec.Unmarshal
+}
+
+// Codec encodes envelopes and their payloads.
+// JSONCodec is built in, protobuf and msgpack codecs are in modules protocodec and msgpackcodec, so that their dependencies stay optional.
+type Codec interface {
+	// Marshal encodes v as payload
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decodes payload into v, which is a pointer
+	Unmarshal(payload []byte, v interface{}) error
+	// Encode frames envelope
+	Encode(env *Envelope) ([]byte, error)
+	// Decode parses frame into envelope
+	Decode(data []byte) (*Envelope, error)
+}
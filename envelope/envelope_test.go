@@ -0,0 +1,125 @@
+package envelope_test
+
+import (
+	"context"
+	"io"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/envelope"
+	"github.com/quexer/tok/mocks"
+)
+
+type chat struct {
+	Text string `json:"text"`
+}
+
+var _ = Describe("Envelope", func() {
+	var device *tok.Device
+
+	BeforeEach(func() {
+		device = tok.CreateDevice("u1", "")
+	})
+
+	It("codec should round trip envelope", func() {
+		codec := envelope.JSONCodec{}
+		b, err := codec.Encode(&envelope.Envelope{Type: "chat", ID: "42", Payload: []byte(`"x"`)})
+		Ω(err).To(Succeed())
+		env, err := codec.Decode(b)
+		Ω(err).To(Succeed())
+		Ω(env).To(Equal(&envelope.Envelope{Type: "chat", ID: "42", Payload: []byte(`"x"`)}))
+
+		_, err = codec.Decode([]byte("garbage"))
+		Ω(err).To(HaveOccurred())
+	})
+
+	It("should keep json payload raw", func() {
+		env, err := envelope.JSONCodec{}.Decode([]byte(`{"type":"chat","payload":{"text":"hi"}}`))
+		Ω(err).To(Succeed())
+		Ω(env.Type).To(Equal("chat"))
+		Ω(env.ID).To(BeEmpty())
+		Ω(env.Payload).To(MatchJSON(`{"text":"hi"}`))
+	})
+
+	It("router should dispatch typed message", func() {
+		codec := envelope.JSONCodec{}
+		r := envelope.NewRouter(codec)
+		chMsg := make(chan chat, 1)
+		envelope.Handle(r, "chat", func(_ context.Context, dv *tok.Device, env *envelope.Envelope, msg chat) error {
+			Ω(dv).To(Equal(device))
+			Ω(env.ID).To(Equal("7"))
+			chMsg <- msg
+			return nil
+		})
+
+		b, err := envelope.Encode(codec, "chat", "7", chat{Text: "hi"})
+		Ω(err).To(Succeed())
+		Ω(r.OnReceive(ctx, device, b)).To(Succeed())
+		Ω(chMsg).To(Receive(Equal(chat{Text: "hi"})))
+	})
+
+	It("should report unknown type and bad payload", func() {
+		r := envelope.NewRouter(envelope.JSONCodec{})
+		envelope.Handle(r, "chat", func(context.Context, *tok.Device, *envelope.Envelope, chat) error {
+			Fail("should not be called")
+			return nil
+		})
+
+		err := r.OnReceive(ctx, device, []byte(`{"type":"unknown"}`))
+		Ω(err).To(MatchError(envelope.ErrUnknownType))
+
+		err = r.OnReceive(ctx, device, []byte(`{"type":"chat","payload":[1]}`))
+		Ω(err).To(HaveOccurred())
+
+		Ω(r.OnReceive(ctx, device, []byte(`not json`))).NotTo(Succeed())
+	})
+
+	It("should pass unknown type to fallback", func() {
+		r := envelope.NewRouter(envelope.JSONCodec{})
+		chType := make(chan string, 1)
+		r.Fallback(func(_ context.Context, _ *tok.Device, env *envelope.Envelope) error {
+			chType <- env.Type
+			return nil
+		})
+
+		Ω(r.OnReceive(ctx, device, []byte(`{"type":"other"}`))).To(Succeed())
+		Ω(chType).To(Receive(Equal("other")))
+	})
+
+	It("should send typed message through hub", func() {
+		r := envelope.NewRouter(envelope.JSONCodec{})
+		config := tok.NewHubConfigWithResult(r,
+			tok.WithHubConfigPingProducer(mocks.NewMockPingGenerator(ctl)),
+			tok.WithHubConfigQueue(nil))
+		hub, _ := tok.CreateWsHandler(nil, tok.WithWsHandlerHubConfig(config))
+
+		chWritten := make(chan []byte, 1)
+		chClosed := make(chan struct{})
+		adapter := mocks.NewMockConAdapter(ctl)
+		adapter.EXPECT().Read().DoAndReturn(func() ([]byte, error) {
+			<-chClosed
+			return nil, io.EOF
+		}).AnyTimes()
+		adapter.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) error {
+			chWritten <- b
+			return nil
+		})
+		adapter.EXPECT().Close().DoAndReturn(func() error {
+			close(chClosed)
+			return nil
+		})
+		go hub.RegisterConnection(ctx, device, adapter)
+		Eventually(func() bool { return hub.CheckOnline(ctx, "u1") }).Should(BeTrue())
+
+		Ω(envelope.Send(ctx, hub, r.Codec(), "u1", "chat", chat{Text: "hi"}, 0)).To(Succeed())
+		var b []byte
+		Eventually(chWritten).Should(Receive(&b))
+		Ω(b).To(MatchJSON(`{"type":"chat","payload":{"text":"hi"}}`))
+
+		hub.Kick(ctx, "u1")
+		Eventually(chClosed).Should(BeClosed())
+	})
+})
@@ -0,0 +1,46 @@
+package envelope
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// JSONCodec is Codec of JSON, frame is an object as
+//
+//	{"type": "chat", "id": "1", "payload": {...}}
+//
+// payload is kept as raw JSON in Envelope.Payload.
+type JSONCodec struct{}
+
+type jsonEnvelope struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Marshal implements Codec
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal implements Codec
+func (JSONCodec) Unmarshal(payload []byte, v interface{}) error {
+	return json.Unmarshal(payload, v)
+}
+
+// Encode implements Codec
+func (JSONCodec) Encode(env *Envelope) ([]byte, error) {
+	return json.Marshal(jsonEnvelope{Type: env.Type, ID: env.ID, Payload: env.Payload})
+}
+
+// Decode implements Codec
+func (JSONCodec) Decode(data []byte) (*Envelope, error) {
+	var je jsonEnvelope
+	if err := json.Unmarshal(data, &je); err != nil {
+		return nil, err
+	}
+	if je.Type == "" {
+		return nil, errors.New("json envelope: type is missing")
+	}
+	return &Envelope{Type: je.Type, ID: je.ID, Payload: je.Payload}, nil
+}
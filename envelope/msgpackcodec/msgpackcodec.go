@@ -0,0 +1,50 @@
+// Package msgpackcodec is envelope.Codec of MessagePack.
+// Frame is an array of [type, id, payload], payload is MessagePack-encoded value carried as bin.
+package msgpackcodec
+
+import (
+	"errors"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/quexer/tok/envelope"
+)
+
+// Codec is envelope.Codec of MessagePack
+type Codec struct{}
+
+var _ envelope.Codec = Codec{}
+
+type frame struct {
+	_msgpack struct{} `msgpack:",as_array"`
+	Type     string
+	ID       string
+	Payload  []byte
+}
+
+// Marshal implements envelope.Codec
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+// Unmarshal implements envelope.Codec
+func (Codec) Unmarshal(payload []byte, v interface{}) error {
+	return msgpack.Unmarshal(payload, v)
+}
+
+// Encode implements envelope.Codec
+func (Codec) Encode(env *envelope.Envelope) ([]byte, error) {
+	return msgpack.Marshal(&frame{Type: env.Type, ID: env.ID, Payload: env.Payload})
+}
+
+// Decode implements envelope.Codec
+func (Codec) Decode(data []byte) (*envelope.Envelope, error) {
+	var f frame
+	if err := msgpack.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	if f.Type == "" {
+		return nil, errors.New("msgpackcodec: type is missing")
+	}
+	return &envelope.Envelope{Type: f.Type, ID: f.ID, Payload: f.Payload}, nil
+}
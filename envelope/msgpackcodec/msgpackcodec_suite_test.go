@@ -0,0 +1,19 @@
+package msgpackcodec_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestMsgpackcodec(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Msgpackcodec Suite")
+}
+
+var ctx context.Context
+var _ = BeforeEach(func() {
+	ctx = context.Background()
+})
@@ -0,0 +1,77 @@
+package msgpackcodec_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/envelope"
+	"github.com/quexer/tok/envelope/msgpackcodec"
+)
+
+type chat struct {
+	Text string `msgpack:"text"`
+}
+
+var _ = Describe("Codec", func() {
+	codec := msgpackcodec.Codec{}
+
+	It("should round trip envelope", func() {
+		b, err := codec.Encode(&envelope.Envelope{Type: "chat", ID: "42", Payload: []byte("x")})
+		Ω(err).To(Succeed())
+		env, err := codec.Decode(b)
+		Ω(err).To(Succeed())
+		Ω(env).To(Equal(&envelope.Envelope{Type: "chat", ID: "42", Payload: []byte("x")}))
+	})
+
+	It("should encode frame as array", func() {
+		b, err := codec.Encode(&envelope.Envelope{Type: "chat", Payload: []byte("x")})
+		Ω(err).To(Succeed())
+		var l []interface{}
+		Ω(msgpack.Unmarshal(b, &l)).To(Succeed())
+		Ω(l).To(Equal([]interface{}{"chat", "", []byte("x")}))
+	})
+
+	It("should fail to decode bad frame", func() {
+		_, err := codec.Decode([]byte("garbage"))
+		Ω(err).To(HaveOccurred())
+
+		b, err := codec.Encode(&envelope.Envelope{Type: "chat", ID: "42"})
+		Ω(err).To(Succeed())
+		_, err = codec.Decode(b[:len(b)-1])
+		Ω(err).To(HaveOccurred())
+
+		b, err = msgpack.Marshal([]interface{}{"", "42", []byte("x")})
+		Ω(err).To(Succeed())
+		_, err = codec.Decode(b)
+		Ω(err).To(MatchError(ContainSubstring("type is missing")))
+	})
+
+	It("should round trip payload", func() {
+		b, err := codec.Marshal(chat{Text: "hi"})
+		Ω(err).To(Succeed())
+		var msg chat
+		Ω(codec.Unmarshal(b, &msg)).To(Succeed())
+		Ω(msg).To(Equal(chat{Text: "hi"}))
+
+		Ω(codec.Unmarshal([]byte{0xc1}, &msg)).NotTo(Succeed())
+	})
+
+	It("should dispatch typed message through router", func() {
+		r := envelope.NewRouter(codec)
+		chMsg := make(chan chat, 1)
+		envelope.Handle(r, "chat", func(_ context.Context, _ *tok.Device, env *envelope.Envelope, msg chat) error {
+			Ω(env.ID).To(Equal("7"))
+			chMsg <- msg
+			return nil
+		})
+
+		b, err := envelope.Encode(codec, "chat", "7", chat{Text: "hi"})
+		Ω(err).To(Succeed())
+		Ω(r.OnReceive(ctx, tok.CreateDevice("u1", ""), b)).To(Succeed())
+		Ω(chMsg).To(Receive(Equal(chat{Text: "hi"})))
+	})
+})
@@ -0,0 +1,117 @@
+// Package protocodec is envelope.Codec of protobuf.
+// Frame is the protobuf encoding of
+//
+//	message Envelope {
+//	  string type = 1;
+//	  string id = 2;
+//	  bytes payload = 3;
+//	}
+//
+// and payloads are protobuf messages.
+package protocodec
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/quexer/tok/envelope"
+)
+
+const (
+	fieldType    protowire.Number = 1
+	fieldID      protowire.Number = 2
+	fieldPayload protowire.Number = 3
+)
+
+// Codec is envelope.Codec of protobuf
+type Codec struct{}
+
+var _ envelope.Codec = Codec{}
+
+// Marshal implements envelope.Codec, v must be proto.Message
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protocodec: %T is not proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+// Unmarshal implements envelope.Codec, v must be proto.Message, or pointer to proto.Message pointer which is allocated if nil
+func (Codec) Unmarshal(payload []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		// e.g. **pb.Chat of envelope.Handle[*pb.Chat]
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Pointer {
+			return fmt.Errorf("protocodec: %T is not proto.Message", v)
+		}
+		if rv.Elem().IsNil() {
+			rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+		}
+		if m, ok = rv.Elem().Interface().(proto.Message); !ok {
+			return fmt.Errorf("protocodec: %T is not proto.Message", v)
+		}
+	}
+	return proto.Unmarshal(payload, m)
+}
+
+// Encode implements envelope.Codec
+func (Codec) Encode(env *envelope.Envelope) ([]byte, error) {
+	b := make([]byte, 0, len(env.Type)+len(env.ID)+len(env.Payload)+16)
+	b = protowire.AppendTag(b, fieldType, protowire.BytesType)
+	b = protowire.AppendString(b, env.Type)
+	if env.ID != "" {
+		b = protowire.AppendTag(b, fieldID, protowire.BytesType)
+		b = protowire.AppendString(b, env.ID)
+	}
+	if len(env.Payload) > 0 {
+		b = protowire.AppendTag(b, fieldPayload, protowire.BytesType)
+		b = protowire.AppendBytes(b, env.Payload)
+	}
+	return b, nil
+}
+
+// Decode implements envelope.Codec
+func (Codec) Decode(data []byte) (*envelope.Envelope, error) {
+	env := &envelope.Envelope{}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if typ != protowire.BytesType {
+			// unknown field
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+
+		v, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+		switch num {
+		case fieldType:
+			env.Type = string(v)
+		case fieldID:
+			env.ID = string(v)
+		case fieldPayload:
+			env.Payload = v
+		}
+	}
+	if env.Type == "" {
+		return nil, errors.New("protocodec: type is missing")
+	}
+	return env, nil
+}
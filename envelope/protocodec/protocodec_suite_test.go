@@ -0,0 +1,19 @@
+package protocodec_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestProtocodec(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Protocodec Suite")
+}
+
+var ctx context.Context
+var _ = BeforeEach(func() {
+	ctx = context.Background()
+})
@@ -0,0 +1,94 @@
+package protocodec_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/envelope"
+	"github.com/quexer/tok/envelope/protocodec"
+)
+
+var _ = Describe("Codec", func() {
+	codec := protocodec.Codec{}
+
+	It("should round trip envelope", func() {
+		b, err := codec.Encode(&envelope.Envelope{Type: "chat", ID: "42", Payload: []byte("x")})
+		Ω(err).To(Succeed())
+		env, err := codec.Decode(b)
+		Ω(err).To(Succeed())
+		Ω(env).To(Equal(&envelope.Envelope{Type: "chat", ID: "42", Payload: []byte("x")}))
+
+		b, err = codec.Encode(&envelope.Envelope{Type: "chat"})
+		Ω(err).To(Succeed())
+		env, err = codec.Decode(b)
+		Ω(err).To(Succeed())
+		Ω(env).To(Equal(&envelope.Envelope{Type: "chat"}))
+	})
+
+	It("should skip unknown fields", func() {
+		b, err := codec.Encode(&envelope.Envelope{Type: "chat", ID: "42"})
+		Ω(err).To(Succeed())
+		b = protowire.AppendTag(b, 9, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+		env, err := codec.Decode(b)
+		Ω(err).To(Succeed())
+		Ω(env).To(Equal(&envelope.Envelope{Type: "chat", ID: "42"}))
+	})
+
+	It("should fail to decode bad frame", func() {
+		_, err := codec.Decode([]byte("garbage"))
+		Ω(err).To(HaveOccurred())
+
+		b, err := codec.Encode(&envelope.Envelope{Type: "chat", ID: "42"})
+		Ω(err).To(Succeed())
+		_, err = codec.Decode(b[:len(b)-1])
+		Ω(err).To(HaveOccurred())
+
+		b = protowire.AppendTag(nil, 2, protowire.BytesType)
+		b = protowire.AppendString(b, "42")
+		_, err = codec.Decode(b)
+		Ω(err).To(MatchError(ContainSubstring("type is missing")))
+	})
+
+	It("should round trip payload", func() {
+		b, err := codec.Marshal(wrapperspb.String("tok"))
+		Ω(err).To(Succeed())
+
+		msg := &wrapperspb.StringValue{}
+		Ω(codec.Unmarshal(b, msg)).To(Succeed())
+		Ω(msg.GetValue()).To(Equal("tok"))
+
+		// pointer to nil message pointer is allocated
+		var p *wrapperspb.StringValue
+		Ω(codec.Unmarshal(b, &p)).To(Succeed())
+		Ω(p.GetValue()).To(Equal("tok"))
+
+		Ω(codec.Unmarshal([]byte{0x0a, 0x05}, msg)).NotTo(Succeed())
+	})
+
+	It("should reject non proto message", func() {
+		_, err := codec.Marshal("tok")
+		Ω(err).To(HaveOccurred())
+		var s string
+		Ω(codec.Unmarshal(nil, &s)).NotTo(Succeed())
+	})
+
+	It("should dispatch protobuf message through router", func() {
+		r := envelope.NewRouter(codec)
+		chMsg := make(chan string, 1)
+		envelope.Handle(r, "name", func(_ context.Context, _ *tok.Device, _ *envelope.Envelope, msg *wrapperspb.StringValue) error {
+			chMsg <- msg.GetValue()
+			return nil
+		})
+
+		b, err := envelope.Encode(codec, "name", "", wrapperspb.String("tok"))
+		Ω(err).To(Succeed())
+		Ω(r.OnReceive(ctx, tok.CreateDevice("u1", ""), b)).To(Succeed())
+		Ω(chMsg).To(Receive(Equal("tok")))
+	})
+})
@@ -0,0 +1,71 @@
+package envelope
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/quexer/tok"
+)
+
+// HandlerFunc handles envelope received from dv, error goes to tok.ErrorPolicy
+type HandlerFunc func(ctx context.Context, dv *tok.Device, env *Envelope) error
+
+// Router decodes inbound frames, and dispatches them to handlers registered by message type.
+// It implements tok.ActorWithResult, see tok.NewHubConfigWithResult.
+// Handlers should be registered before hub serves connections.
+type Router struct {
+	codec    Codec
+	handlers map[string]HandlerFunc
+	fallback HandlerFunc // optional handler of unknown types
+}
+
+// NewRouter create router decoding frames with codec
+func NewRouter(codec Codec) *Router {
+	return &Router{
+		codec:    codec,
+		handlers: make(map[string]HandlerFunc),
+	}
+}
+
+// Codec return codec of router, e.g. for Send
+func (r *Router) Codec() Codec {
+	return r.codec
+}
+
+// HandleFunc registers handler of message type typ, it replaces the old one if any
+func (r *Router) HandleFunc(typ string, h HandlerFunc) {
+	r.handlers[typ] = h
+}
+
+// Fallback registers handler of types without handler. Without fallback, ErrUnknownType is returned for them.
+func (r *Router) Fallback(h HandlerFunc) {
+	r.fallback = h
+}
+
+// OnReceive implements tok.ActorWithResult
+func (r *Router) OnReceive(ctx context.Context, dv *tok.Device, data []byte) error {
+	env, err := r.codec.Decode(data)
+	if err != nil {
+		return err
+	}
+
+	h, ok := r.handlers[env.Type]
+	if !ok {
+		h = r.fallback
+	}
+	if h == nil {
+		return fmt.Errorf("%w: %q", ErrUnknownType, env.Type)
+	}
+	return h(ctx, dv, env)
+}
+
+// Handle registers typed handler of message type typ, payload is decoded into T by codec of router
+func Handle[T any](r *Router, typ string, h func(ctx context.Context, dv *tok.Device, env *Envelope, msg T) error) {
+	r.HandleFunc(typ, func(ctx context.Context, dv *tok.Device, env *Envelope) error {
+		var msg T
+		if err := r.codec.Unmarshal(env.Payload, &msg); err != nil {
+			return fmt.Errorf("envelope: decode payload of %q: %w", typ, err)
+		}
+		return h(ctx, dv, env, msg)
+	})
+}
@@ -0,0 +1,25 @@
+package envelope
+
+import (
+	"context"
+
+	"github.com/quexer/tok"
+)
+
+// Encode encodes msg as payload of envelope with type typ and id, then frames the envelope
+func Encode[T any](codec Codec, typ, id string, msg T) ([]byte, error) {
+	payload, err := codec.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Encode(&Envelope{Type: typ, ID: id, Payload: payload})
+}
+
+// Send encodes msg into envelope of type typ, then sends it to uid by hub.Send, see tok.Hub.Send for ttl
+func Send[T any](ctx context.Context, hub *tok.Hub, codec Codec, to interface{}, typ string, msg T, ttl uint32) error {
+	b, err := Encode(codec, typ, "", msg)
+	if err != nil {
+		return err
+	}
+	return hub.Send(ctx, to, b, ttl)
+}
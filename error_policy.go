@@ -0,0 +1,104 @@
+/**
+ * error-driven connection policy
+ */
+
+package tok
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Reasons of closing connection, passed to ByeGenerator
+const (
+	ByeReasonSSO     = "sso"     // kicked by a newer connection of the same uid
+	ByeReasonError   = "error"   // closed by ErrorPolicy, if it gives no reason
+	ByeReasonStrikes = "strikes" // too many receive errors, see WithHubConfigErrorPolicy
+)
+
+// ErrorAction is what to do with a receive error
+type ErrorAction int
+
+const (
+	// ErrorActionIgnore logs the error only, it's not counted as strike
+	ErrorActionIgnore ErrorAction = iota
+	// ErrorActionReply sends ErrorDecision.Reply to the connection through BeforeSend, it's counted as strike
+	ErrorActionReply
+	// ErrorActionClose closes the connection at once, after bye message with ErrorDecision.Reason
+	ErrorActionClose
+)
+
+// ErrorDecision is the result of ErrorPolicy
+type ErrorDecision struct {
+	Action ErrorAction
+	Reply  []byte // reply for ErrorActionReply
+	Reason string // bye reason for ErrorActionClose, default ByeReasonError
+}
+
+// ErrorPolicyFunc adapts function to ErrorPolicy
+type ErrorPolicyFunc func(ctx context.Context, dv *Device, err error) ErrorDecision
+
+// OnError implements ErrorPolicy
+func (f ErrorPolicyFunc) OnError(ctx context.Context, dv *Device, err error) ErrorDecision {
+	return f(ctx, dv, err)
+}
+
+// AdaptContextActor adapts ContextActor to ActorWithResult, which never fails. It returns nil for nil
+func AdaptContextActor(actor ContextActor) ActorWithResult {
+	if actor == nil {
+		return nil
+	}
+	return contextActorAdapter{actor}
+}
+
+type contextActorAdapter struct{ actor ContextActor }
+
+func (p contextActorAdapter) OnReceive(ctx context.Context, dv *Device, data []byte) error {
+	p.actor.OnReceive(ctx, dv, data)
+	return nil
+}
+
+// onReceiveError applies error policy to connection which message failed
+func (p *Hub) onReceiveError(ctx context.Context, conn *connection, err error) {
+	policy := p.config.errorPolicy
+	if policy == nil {
+		slog.Error("[tok] receive failed", "err", err, "uid", conn.uid())
+		return
+	}
+
+	decision := p.onError(ctx, conn, err)
+	switch decision.Action {
+	case ErrorActionIgnore:
+		slog.Debug("[tok] receive failed, ignore", "err", err, "uid", conn.uid())
+		return
+	case ErrorActionClose:
+		slog.Info("[tok] receive failed, close", "err", err, "uid", conn.uid())
+		reason := decision.Reason
+		if reason == "" {
+			reason = ByeReasonError
+		}
+		p.byeThenOffline(conn, reason)
+		return
+	case ErrorActionReply:
+		if err := p.writeTo(conn.messageContext(false, nil), conn, decision.Reply, 0); err != nil {
+			slog.Warn("[tok] write error reply failed", "err", err, "uid", conn.uid())
+		}
+	}
+
+	if limit := p.config.maxStrikes; limit > 0 && conn.strikes.Add(1) >= int32(limit) {
+		slog.Info("[tok] too many receive errors, close", "err", err, "uid", conn.uid())
+		p.byeThenOffline(conn, ByeReasonStrikes)
+	}
+}
+
+// onError calls ErrorPolicy, panic of policy is taken as ErrorActionIgnore
+func (p *Hub) onError(ctx context.Context, conn *connection, err error) (decision ErrorDecision) {
+	defer p.recoverHook("OnError", conn, nil)
+	return p.config.errorPolicy.OnError(ctx, conn.dv, err)
+}
+
+// byeThenOffline sends bye to connection, then takes it offline
+func (p *Hub) byeThenOffline(conn *connection, reason string) {
+	p.bye(conn.dv, reason, conn)
+	conn.triggerOffline(reason)
+}
@@ -0,0 +1,150 @@
+package tok_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/mocks"
+)
+
+var errBadMessage = errors.New("bad message")
+
+var _ = Describe("ErrorPolicy", func() {
+	var (
+		mockAdapter *mocks.MockConAdapter
+		mockActor   *mocks.MockActorWithResult
+		mockBye     *mocks.MockByeGenerator
+		hub         *tok.Hub
+		device      *tok.Device
+		chRead      chan []byte
+		chWritten   chan []byte
+		chClosed    chan struct{}
+	)
+
+	BeforeEach(func() {
+		mockAdapter = mocks.NewMockConAdapter(ctl)
+		mockActor = mocks.NewMockActorWithResult(ctl)
+		mockBye = mocks.NewMockByeGenerator(ctl)
+		device = tok.CreateDevice("bad-user", "bad-session")
+
+		read := make(chan []byte)
+		writes := make(chan []byte, 10)
+		closed := make(chan struct{})
+		mockAdapter.EXPECT().Read().DoAndReturn(func() ([]byte, error) {
+			select {
+			case b := <-read:
+				return b, nil
+			case <-closed:
+				return nil, io.EOF
+			}
+		}).AnyTimes()
+		mockAdapter.EXPECT().Close().DoAndReturn(func() error {
+			close(closed)
+			return nil
+		})
+		mockAdapter.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) error {
+			writes <- b
+			return nil
+		}).AnyTimes()
+		chRead, chWritten, chClosed = read, writes, closed
+	})
+
+	AfterEach(func() {
+		hub.Kick(ctx, "bad-user")
+		Eventually(chClosed).Should(BeClosed())
+	})
+
+	// register creates hub with opts and registers the connection
+	register := func(opts ...tok.HubConfigOption) {
+		opts = append(opts,
+			tok.WithHubConfigPingProducer(mocks.NewMockPingGenerator(ctl)),
+			tok.WithHubConfigQueue(nil),
+			tok.WithHubConfigByeGenerator(mockBye))
+		config := tok.NewHubConfigWithResult(mockActor, opts...)
+		hub, _ = tok.CreateWsHandler(nil, tok.WithWsHandlerHubConfig(config))
+
+		go hub.RegisterConnection(ctx, device, mockAdapter)
+		Eventually(func() bool { return hub.CheckOnline(ctx, "bad-user") }).Should(BeTrue())
+	}
+
+	It("should only log errors without policy", func() {
+		chDone := make(chan struct{}, 3)
+		mockActor.EXPECT().OnReceive(gomock.Any(), device, []byte("garbage")).DoAndReturn(
+			func(context.Context, *tok.Device, []byte) error {
+				chDone <- struct{}{}
+				return errBadMessage
+			}).Times(3)
+		register()
+
+		for i := 0; i < 3; i++ {
+			chRead <- []byte("garbage")
+			Eventually(chDone).Should(Receive())
+		}
+		Consistently(chClosed, 50*time.Millisecond).ShouldNot(BeClosed())
+	})
+
+	It("should reply errors through BeforeSend, and close after max strikes", func() {
+		mockActor.EXPECT().OnReceive(gomock.Any(), device, []byte("garbage")).Return(errBadMessage).Times(2)
+		mockBefore := mocks.NewMockBeforeSendHandler(ctl)
+		mockBefore.EXPECT().BeforeSend(device, gomock.Any()).DoAndReturn(func(_ *tok.Device, b []byte) ([]byte, error) {
+			return append([]byte("enc:"), b...), nil
+		}).AnyTimes()
+		mockBye.EXPECT().Bye(device, tok.ByeReasonStrikes, device).Return([]byte("bye"))
+
+		policy := tok.ErrorPolicyFunc(func(_ context.Context, dv *tok.Device, err error) tok.ErrorDecision {
+			Ω(dv).To(Equal(device))
+			Ω(err).To(MatchError(errBadMessage))
+			return tok.ErrorDecision{Action: tok.ErrorActionReply, Reply: []byte("invalid")}
+		})
+		register(tok.WithHubConfigErrorPolicy(policy, 2), tok.WithHubConfigBeforeSend(mockBefore))
+
+		chRead <- []byte("garbage")
+		Eventually(chWritten).Should(Receive(Equal([]byte("enc:invalid"))))
+		Consistently(chClosed, 50*time.Millisecond).ShouldNot(BeClosed())
+
+		chRead <- []byte("garbage")
+		Eventually(chWritten).Should(Receive(Equal([]byte("enc:invalid"))))
+		Eventually(chWritten).Should(Receive(Equal([]byte("enc:bye"))))
+		Eventually(chClosed).Should(BeClosed())
+		Ω(hub.CheckOnline(ctx, "bad-user")).To(BeFalse())
+	})
+
+	It("should close connection with bye reason", func() {
+		mockActor.EXPECT().OnReceive(gomock.Any(), device, []byte("garbage")).Return(errBadMessage)
+		mockBye.EXPECT().Bye(device, "protocol", device).Return([]byte("bye"))
+
+		policy := tok.ErrorPolicyFunc(func(context.Context, *tok.Device, error) tok.ErrorDecision {
+			return tok.ErrorDecision{Action: tok.ErrorActionClose, Reason: "protocol"}
+		})
+		register(tok.WithHubConfigErrorPolicy(policy, 0))
+
+		chRead <- []byte("garbage")
+		Eventually(chWritten).Should(Receive(Equal([]byte("bye"))))
+		Eventually(chClosed).Should(BeClosed())
+	})
+
+	It("should pass BeforeReceive error to policy, ignored errors are not strikes", func() {
+		mockBeforeReceive := mocks.NewMockBeforeReceiveHandler(ctl)
+		mockBeforeReceive.EXPECT().BeforeReceive(device, []byte("garbage")).Return(nil, errBadMessage).Times(3)
+
+		chErr := make(chan error, 3)
+		policy := tok.ErrorPolicyFunc(func(_ context.Context, _ *tok.Device, err error) tok.ErrorDecision {
+			chErr <- err
+			return tok.ErrorDecision{Action: tok.ErrorActionIgnore}
+		})
+		register(tok.WithHubConfigErrorPolicy(policy, 1), tok.WithHubConfigBeforeReceive(mockBeforeReceive))
+
+		for i := 0; i < 3; i++ {
+			chRead <- []byte("garbage")
+			Eventually(chErr).Should(Receive(MatchError(errBadMessage)))
+		}
+		Consistently(chClosed, 50*time.Millisecond).ShouldNot(BeClosed())
+	})
+})
@@ -0,0 +1,236 @@
+/**
+ * hub-level event stream of connection and presence changes
+ */
+
+package tok
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reasons of device disconnection, see EventDisconnected.
+// Besides them, reason is ByeReasonSSO for connection kicked by SSO, or bye reason of ErrorPolicy.
+const (
+	DisconnectReasonClosed       = "closed"        // read failed, e.g. closed by peer
+	DisconnectReasonWriteFailed  = "write_failed"  // write failed
+	DisconnectReasonKicked       = "kicked"        // kicked by Hub.Kick
+	DisconnectReasonIdle         = "idle"          // silent for longer than idle timeout
+	DisconnectReasonPongMissed   = "pong_missed"   // missed too many pongs
+	DisconnectReasonSlowConsumer = "slow_consumer" // write queue is full, see SlowConsumerDisconnect
+	DisconnectReasonPanic        = "panic"         // hook panicked, see WithHubConfigPanicHandler
+)
+
+// EventType is type of hub event
+type EventType int
+
+const (
+	// EventConnected device connected
+	EventConnected EventType = iota + 1
+	// EventDisconnected device disconnected, see Event.Reason
+	EventDisconnected
+	// EventOnline uid got its first connection
+	EventOnline
+	// EventOffline uid lost its last connection
+	EventOffline
+	// EventKicked device kicked by a newer connection of the same uid in SSO mode, see Event.Kicker.
+	// It's followed by EventDisconnected with ByeReasonSSO.
+	EventKicked
+)
+
+// String returns name of event type
+func (t EventType) String() string {
+	switch t {
+	case EventConnected:
+		return "connected"
+	case EventDisconnected:
+		return "disconnected"
+	case EventOnline:
+		return "online"
+	case EventOffline:
+		return "offline"
+	case EventKicked:
+		return "kicked"
+	}
+	return "unknown"
+}
+
+// Event is connection or presence change of hub. Events of the same uid are in order.
+type Event struct {
+	Type   EventType
+	UID    interface{} // user id
+	Device *Device     // device of the connection, for uid-level events it's the device causing the change
+	Reason string      // reason of EventDisconnected, and EventOffline caused by it
+	Kicker *Device     // new device of EventKicked
+	Time   time.Time   // when it happened
+}
+
+// OverflowPolicy decides what to do with new event while buffer of subscriber is full
+type OverflowPolicy int
+
+const (
+	// OverflowDropNewest drops the new event
+	OverflowDropNewest OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest buffered event to make room for the new one
+	OverflowDropOldest
+	// OverflowUnsubscribe closes the event channel, subscriber should subscribe again and resync
+	OverflowUnsubscribe
+)
+
+// defaultEventBuffer is the default buffer size of subscriber
+const defaultEventBuffer = 1024
+
+// SubscribeOption option of Hub.Subscribe
+type SubscribeOption func(*subscriber)
+
+// WithSubscribeBuffer set buffer size of event channel and overflow policy, default is 1024 and OverflowDropNewest
+func WithSubscribeBuffer(size int, policy OverflowPolicy) SubscribeOption {
+	return func(s *subscriber) {
+		s.size = max(size, 1)
+		s.policy = policy
+	}
+}
+
+type subscriber struct {
+	mu      sync.Mutex
+	ch      chan Event
+	size    int
+	policy  OverflowPolicy
+	closed  bool
+	dropped int // events dropped, logged at powers of 2
+}
+
+// publish sends event without blocking
+func (s *subscriber) publish(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.ch <- e:
+		return
+	default:
+	}
+
+	switch s.policy {
+	case OverflowDropOldest:
+		// publishers hold s.mu, so there is room after one receive
+		select {
+		case <-s.ch:
+		default:
+		}
+		s.ch <- e
+		s.dropped++
+	case OverflowUnsubscribe:
+		slog.Warn("[tok] event subscriber is too slow, unsubscribe it")
+		s.closed = true
+		close(s.ch)
+		return
+	default:
+		s.dropped++
+	}
+	if s.dropped&(s.dropped-1) == 0 {
+		// log at 1, 2, 4, 8...
+		slog.Warn("[tok] event buffer full, drop event", "dropped", s.dropped)
+	}
+}
+
+func (s *subscriber) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
+
+// eventBus fans events out to subscribers
+type eventBus struct {
+	mu   sync.Mutex
+	subs atomic.Pointer[[]*subscriber] // copy on write, read by publishers without lock
+}
+
+func (b *eventBus) add(s *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var l []*subscriber
+	if old := b.subs.Load(); old != nil {
+		l = append(l, *old...)
+	}
+	l = append(l, s)
+	b.subs.Store(&l)
+}
+
+func (b *eventBus) remove(s *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	old := b.subs.Load()
+	if old == nil {
+		return
+	}
+	l := make([]*subscriber, 0, len(*old))
+	for _, v := range *old {
+		if v != s {
+			l = append(l, v)
+		}
+	}
+	b.subs.Store(&l)
+}
+
+// active returns whether there is any subscriber, so that events are built only if necessary
+func (b *eventBus) active() bool {
+	l := b.subs.Load()
+	return l != nil && len(*l) > 0
+}
+
+func (b *eventBus) publish(e Event) {
+	l := b.subs.Load()
+	if l == nil {
+		return
+	}
+	for _, s := range *l {
+		s.publish(e)
+	}
+}
+
+// Subscribe returns channel of hub events, until ctx is done, then the channel is closed.
+// Events are buffered, see WithSubscribeBuffer for buffer size and overflow policy, hub is never blocked by subscriber.
+func (p *Hub) Subscribe(ctx context.Context, opts ...SubscribeOption) <-chan Event {
+	s := &subscriber{size: defaultEventBuffer, policy: OverflowDropNewest}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.ch = make(chan Event, s.size)
+
+	if ctx.Err() != nil {
+		close(s.ch)
+		return s.ch
+	}
+
+	p.events.add(s)
+	context.AfterFunc(ctx, func() {
+		p.events.remove(s)
+		s.close()
+	})
+	return s.ch
+}
+
+// emit publishes event of conn, it's called in shard loop
+func (p *Hub) emit(typ EventType, conn *connection, reason string, kicker *Device) {
+	if !p.events.active() {
+		return
+	}
+	p.events.publish(Event{
+		Type:   typ,
+		UID:    conn.uid(),
+		Device: conn.dv,
+		Reason: reason,
+		Kicker: kicker,
+		Time:   time.Now(),
+	})
+}
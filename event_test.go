@@ -0,0 +1,127 @@
+package tok_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/mocks"
+)
+
+var _ = Describe("Event", func() {
+	var (
+		hub    *tok.Hub
+		events <-chan tok.Event
+		cancel context.CancelFunc
+	)
+
+	// create creates hub with opts, and subscribes its events
+	create := func(opts ...tok.HubConfigOption) {
+		opts = append([]tok.HubConfigOption{
+			tok.WithHubConfigPingProducer(mocks.NewMockPingGenerator(ctl)),
+			tok.WithHubConfigQueue(nil),
+		}, opts...)
+		config := tok.NewHubConfig(mocks.NewMockActor(ctl), opts...)
+		hub, _ = tok.CreateWsHandler(nil, tok.WithWsHandlerHubConfig(config))
+
+		var c context.Context
+		c, cancel = context.WithCancel(ctx)
+		events = hub.Subscribe(c)
+	}
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	connect := func(dv *tok.Device) *benchAdapter {
+		adapter := &benchAdapter{chClosed: make(chan struct{})}
+		go hub.RegisterConnection(ctx, dv, adapter)
+		return adapter
+	}
+
+	event := func(typ tok.EventType, dv *tok.Device, reason string) OmegaMatcher {
+		return MatchFields(IgnoreExtras, Fields{
+			"Type":   Equal(typ),
+			"UID":    Equal(dv.UID()),
+			"Device": BeIdenticalTo(dv),
+			"Reason": Equal(reason),
+			"Time":   Not(BeZero()),
+		})
+	}
+
+	It("should report device and uid transitions", func() {
+		create(tok.WithHubConfigSso(false))
+		phone := tok.CreateDevice("u1", "phone")
+		tablet := tok.CreateDevice("u1", "tablet")
+
+		phoneAdapter := connect(phone)
+		Eventually(events).Should(Receive(event(tok.EventConnected, phone, "")))
+		Eventually(events).Should(Receive(event(tok.EventOnline, phone, "")))
+
+		tabletAdapter := connect(tablet)
+		Eventually(events).Should(Receive(event(tok.EventConnected, tablet, "")))
+
+		_ = phoneAdapter.Close()
+		Eventually(events).Should(Receive(event(tok.EventDisconnected, phone, tok.DisconnectReasonClosed)))
+		Consistently(events, "50ms").ShouldNot(Receive())
+
+		_ = tabletAdapter.Close()
+		Eventually(events).Should(Receive(event(tok.EventDisconnected, tablet, tok.DisconnectReasonClosed)))
+		Eventually(events).Should(Receive(event(tok.EventOffline, tablet, tok.DisconnectReasonClosed)))
+	})
+
+	It("should report kicked devices", func() {
+		create()
+		phone := tok.CreateDevice("u1", "phone")
+		tablet := tok.CreateDevice("u1", "tablet")
+
+		connect(phone)
+		Eventually(events).Should(Receive(event(tok.EventConnected, phone, "")))
+		Eventually(events).Should(Receive(event(tok.EventOnline, phone, "")))
+
+		connect(tablet)
+		var e tok.Event
+		Eventually(events).Should(Receive(&e))
+		Ω(e).To(event(tok.EventKicked, phone, tok.ByeReasonSSO))
+		Ω(e.Kicker).To(BeIdenticalTo(tablet))
+		Eventually(events).Should(Receive(event(tok.EventDisconnected, phone, tok.ByeReasonSSO)))
+		Eventually(events).Should(Receive(event(tok.EventConnected, tablet, "")))
+
+		hub.Kick(ctx, "u1")
+		Eventually(events).Should(Receive(event(tok.EventDisconnected, tablet, tok.DisconnectReasonKicked)))
+		Eventually(events).Should(Receive(event(tok.EventOffline, tablet, tok.DisconnectReasonKicked)))
+		Consistently(events, "50ms").ShouldNot(Receive())
+	})
+
+	It("should apply overflow policy", func() {
+		create()
+		newest := hub.Subscribe(ctx, tok.WithSubscribeBuffer(1, tok.OverflowDropNewest))
+		oldest := hub.Subscribe(ctx, tok.WithSubscribeBuffer(1, tok.OverflowDropOldest))
+		unsub := hub.Subscribe(ctx, tok.WithSubscribeBuffer(1, tok.OverflowUnsubscribe))
+
+		dv := tok.CreateDevice("u1", "")
+		connect(dv)
+		Eventually(events).Should(Receive(event(tok.EventConnected, dv, "")))
+		Eventually(events).Should(Receive(event(tok.EventOnline, dv, "")))
+
+		Ω(newest).To(Receive(event(tok.EventConnected, dv, "")))
+		Ω(oldest).To(Receive(event(tok.EventOnline, dv, "")))
+		Ω(unsub).To(Receive(event(tok.EventConnected, dv, "")))
+		Ω(unsub).To(BeClosed())
+
+		hub.Kick(ctx, "u1")
+	})
+
+	It("should close channel while ctx is done", func() {
+		create()
+		cancel()
+		Eventually(events).Should(BeClosed())
+
+		c, cancel := context.WithCancel(ctx)
+		cancel()
+		Ω(hub.Subscribe(c)).To(BeClosed())
+	})
+})
@@ -0,0 +1,70 @@
+package tok
+
+import (
+	"net"
+	"time"
+)
+
+// NewTCPAdapter exposes tcp adapter to benchmarks
+func NewTCPAdapter(conn net.Conn, framer Framer) ConAdapter {
+	return &tcpAdapter{
+		conn:         conn,
+		raw:          conn,
+		framer:       framer,
+		maxFrameLen:  TCPMaxPackLen,
+		writeTimeout: time.Minute,
+	}
+}
+
+// WheelTimer exposes timer of timing wheel to tests
+type WheelTimer = wheelTimer
+
+// WheelSpan exposes ticks covered by all levels of timing wheel
+const WheelSpan = wheelSpan
+
+// TestWheel is timing wheel driven by Advance and ProcessTick instead of ticker
+type TestWheel struct {
+	w *timingWheel
+}
+
+// NewTestWheel create timing wheel without starting its goroutine
+func NewTestWheel() *TestWheel {
+	return &TestWheel{w: &timingWheel{tick: time.Millisecond, start: time.Now()}}
+}
+
+func (p *TestWheel) NewTimer(fn func()) *WheelTimer {
+	return p.w.newTimer(fn)
+}
+
+// Schedule (re)schedules timer to fire at tick expire
+func (p *TestWheel) Schedule(t *WheelTimer, expire uint64) {
+	p.w.schedule(t, expire)
+}
+
+func (p *TestWheel) Stop(t *WheelTimer) bool {
+	return p.w.stop(t)
+}
+
+// Advance processes ticks up to now
+func (p *TestWheel) Advance(now uint64) {
+	p.w.advance(now)
+}
+
+// ProcessTick processes next tick and returns number of expired timers without calling them
+func (p *TestWheel) ProcessTick() int {
+	p.w.mu.Lock()
+	defer p.w.mu.Unlock()
+	return len(p.w.processTick())
+}
+
+// Base returns next tick to process
+func (p *TestWheel) Base() uint64 {
+	p.w.mu.Lock()
+	defer p.w.mu.Unlock()
+	return p.w.base
+}
+
+// SendContext exposes whether outbound messages carry handler context
+func (hc *HubConfig) SendContext() bool {
+	return hc.sendContext
+}
@@ -0,0 +1,157 @@
+/**
+ * tcp framing codecs
+ */
+
+package tok
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ErrFrameTooLarge occurs while reading or writing a frame longer than the upper limit
+var ErrFrameTooLarge = errors.New("tok: frame too large")
+
+// Framer splits tcp byte stream into messages.
+// Implementations must be stateless, the same Framer is shared by all connections of a listener.
+type Framer interface {
+	// ReadFrame reads the next frame from r and returns its payload.
+	// Frames with payload longer than maxLen must be rejected with ErrFrameTooLarge.
+	ReadFrame(r *bufio.Reader, maxLen uint32) ([]byte, error)
+
+	// AppendHeader appends the frame header of payload to dst and returns the extended buffer.
+	AppendHeader(dst []byte, payload []byte) ([]byte, error)
+
+	// Trailer returns bytes following the payload, nil if there is none.
+	Trailer() []byte
+}
+
+var (
+	// Uint32Framer prefixes each message with 4-byte big-endian length. It's the default framer of tcp listener.
+	Uint32Framer Framer = uint32Framer{}
+	// Uint16Framer prefixes each message with 2-byte big-endian length, payload is limited to 64K.
+	Uint16Framer Framer = uint16Framer{}
+	// VarintFramer prefixes each message with unsigned varint length, as encoding/binary.PutUvarint.
+	VarintFramer Framer = varintFramer{}
+	// LineFramer terminates each message with '\n', e.g. newline-delimited JSON. A trailing '\r' is stripped on read.
+	// Payload containing '\n' can't be written.
+	LineFramer Framer = lineFramer{}
+)
+
+func frameTooLarge(n uint64, maxLen uint64) error {
+	return fmt.Errorf("%w: %d > %d", ErrFrameTooLarge, n, maxLen)
+}
+
+// readPayload reads n bytes payload after header
+func readPayload(r *bufio.Reader, n uint64, maxLen uint32) ([]byte, error) {
+	if n > uint64(maxLen) {
+		return nil, frameTooLarge(n, uint64(maxLen))
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+type uint32Framer struct{}
+
+func (uint32Framer) ReadFrame(r *bufio.Reader, maxLen uint32) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	return readPayload(r, uint64(binary.BigEndian.Uint32(hdr[:])), maxLen)
+}
+
+func (uint32Framer) AppendHeader(dst []byte, payload []byte) ([]byte, error) {
+	if uint64(len(payload)) > math.MaxUint32 {
+		return nil, frameTooLarge(uint64(len(payload)), math.MaxUint32)
+	}
+	return binary.BigEndian.AppendUint32(dst, uint32(len(payload))), nil
+}
+
+func (uint32Framer) Trailer() []byte {
+	return nil
+}
+
+type uint16Framer struct{}
+
+func (uint16Framer) ReadFrame(r *bufio.Reader, maxLen uint32) ([]byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	return readPayload(r, uint64(binary.BigEndian.Uint16(hdr[:])), maxLen)
+}
+
+func (uint16Framer) AppendHeader(dst []byte, payload []byte) ([]byte, error) {
+	if len(payload) > math.MaxUint16 {
+		return nil, frameTooLarge(uint64(len(payload)), math.MaxUint16)
+	}
+	return binary.BigEndian.AppendUint16(dst, uint16(len(payload))), nil
+}
+
+func (uint16Framer) Trailer() []byte {
+	return nil
+}
+
+type varintFramer struct{}
+
+func (varintFramer) ReadFrame(r *bufio.Reader, maxLen uint32) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	return readPayload(r, n, maxLen)
+}
+
+func (varintFramer) AppendHeader(dst []byte, payload []byte) ([]byte, error) {
+	return binary.AppendUvarint(dst, uint64(len(payload))), nil
+}
+
+func (varintFramer) Trailer() []byte {
+	return nil
+}
+
+type lineFramer struct{}
+
+var lineTrailer = []byte{'\n'}
+
+func (lineFramer) ReadFrame(r *bufio.Reader, maxLen uint32) ([]byte, error) {
+	var line []byte
+	for {
+		b, err := r.ReadSlice('\n')
+		// the limit doesn't count line terminator
+		if n := uint64(len(line) + len(b)); n > uint64(maxLen)+2 {
+			return nil, frameTooLarge(n, uint64(maxLen))
+		}
+		line = append(line, b...)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, err
+		}
+	}
+
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte{'\r'})
+	if uint64(len(line)) > uint64(maxLen) {
+		return nil, frameTooLarge(uint64(len(line)), uint64(maxLen))
+	}
+	return line, nil
+}
+
+func (lineFramer) AppendHeader(dst []byte, payload []byte) ([]byte, error) {
+	if bytes.IndexByte(payload, '\n') >= 0 {
+		return nil, errors.New("line framer: payload contains line terminator")
+	}
+	return dst, nil
+}
+
+func (lineFramer) Trailer() []byte {
+	return lineTrailer
+}
@@ -0,0 +1,70 @@
+package tok_test
+
+import (
+	"bufio"
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/quexer/tok"
+)
+
+var _ = Describe("Framer", func() {
+	encode := func(f tok.Framer, payloads ...string) []byte {
+		var buf []byte
+		for _, p := range payloads {
+			var err error
+			buf, err = f.AppendHeader(buf, []byte(p))
+			Ω(err).To(Succeed())
+			buf = append(buf, p...)
+			buf = append(buf, f.Trailer()...)
+		}
+		return buf
+	}
+
+	DescribeTable("round trip",
+		func(f tok.Framer) {
+			payloads := []string{"hello", "", string(bytes.Repeat([]byte("x"), 5000))}
+			r := bufio.NewReader(bytes.NewReader(encode(f, payloads...)))
+			for _, p := range payloads {
+				b, err := f.ReadFrame(r, 8192)
+				Ω(err).To(Succeed())
+				Ω(string(b)).To(Equal(p))
+			}
+		},
+		Entry("uint32", tok.Uint32Framer),
+		Entry("uint16", tok.Uint16Framer),
+		Entry("varint", tok.VarintFramer),
+		Entry("line", tok.LineFramer),
+	)
+
+	DescribeTable("reject frame longer than max length",
+		func(f tok.Framer) {
+			r := bufio.NewReader(bytes.NewReader(encode(f, "hello world")))
+			_, err := f.ReadFrame(r, 5)
+			Ω(err).To(MatchError(tok.ErrFrameTooLarge))
+		},
+		Entry("uint32", tok.Uint32Framer),
+		Entry("uint16", tok.Uint16Framer),
+		Entry("varint", tok.VarintFramer),
+		Entry("line", tok.LineFramer),
+	)
+
+	It("line framer should strip CRLF", func() {
+		r := bufio.NewReader(bytes.NewReader([]byte("{\"a\":1}\r\n")))
+		b, err := tok.LineFramer.ReadFrame(r, 1024)
+		Ω(err).To(Succeed())
+		Ω(string(b)).To(Equal(`{"a":1}`))
+	})
+
+	It("line framer should refuse payload with line terminator", func() {
+		_, err := tok.LineFramer.AppendHeader(nil, []byte("a\nb"))
+		Ω(err).To(HaveOccurred())
+	})
+
+	It("uint16 framer should refuse payload longer than 64K", func() {
+		_, err := tok.Uint16Framer.AppendHeader(nil, make([]byte, 70000))
+		Ω(err).To(MatchError(tok.ErrFrameTooLarge))
+	})
+})
@@ -0,0 +1,26 @@
+package grpcgw_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+)
+
+func TestGrpcgw(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Grpcgw Suite")
+}
+
+var ctx context.Context
+var ctl *gomock.Controller
+var _ = BeforeEach(func() {
+	ctx = context.Background()
+	ctl = gomock.NewController(GinkgoT())
+})
+
+var _ = AfterEach(func() {
+	ctl.Finish()
+})
@@ -0,0 +1,170 @@
+package grpcgw_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/grpcgw"
+	"github.com/quexer/tok/internal/testutil"
+)
+
+// statusCode returns grpc code of err
+func statusCode(err error) codes.Code {
+	return status.Code(err)
+}
+
+var _ = Describe("Control", func() {
+	var (
+		hub    *tok.Hub
+		relay  *grpcgw.Relay
+		client grpcgw.ControlClient
+	)
+
+	BeforeEach(func() {
+		relay = grpcgw.NewRelay(nil)
+		hub = testutil.NewHubWithActor(ctl, relay)
+
+		lis := bufconn.Listen(1 << 20)
+		s := grpc.NewServer()
+		grpcgw.RegisterControlServer(s, grpcgw.NewServer(hub, relay, grpcgw.WithUIDParser(func(s string) (interface{}, error) {
+			if s == "bad" {
+				return nil, strconv.ErrSyntax
+			}
+			return s, nil
+		})))
+		go s.Serve(lis)
+		DeferCleanup(s.Stop)
+
+		cc, err := grpc.NewClient("passthrough:///bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return lis.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Ω(err).To(Succeed())
+		DeferCleanup(cc.Close)
+		client = grpcgw.NewControlClient(cc)
+	})
+
+	It("should send and map errors to codes", func() {
+		adapter := testutil.Connect(ctx, hub, tok.CreateDevice("u1", ""))
+
+		_, err := client.Send(ctx, &grpcgw.SendRequest{Uid: "u1", Payload: []byte("hi")})
+		Ω(err).To(Succeed())
+		Eventually(adapter.ChWritten).Should(Receive(Equal([]byte("hi"))))
+
+		_, err = client.Send(ctx, &grpcgw.SendRequest{Uid: "u2", Payload: []byte("hi")})
+		Ω(statusCode(err)).To(Equal(codes.NotFound))
+		_, err = client.Send(ctx, &grpcgw.SendRequest{Uid: "u2", Payload: []byte("hi"), Ttl: 60})
+		Ω(statusCode(err)).To(Equal(codes.Unavailable))
+		_, err = client.Send(ctx, &grpcgw.SendRequest{Payload: []byte("hi")})
+		Ω(statusCode(err)).To(Equal(codes.InvalidArgument))
+		_, err = client.Send(ctx, &grpcgw.SendRequest{Uid: "bad", Payload: []byte("hi")})
+		Ω(statusCode(err)).To(Equal(codes.InvalidArgument))
+	})
+
+	It("should check online and list online uids", func() {
+		testutil.Connect(ctx, hub, tok.CreateDevice("u1", ""))
+		testutil.Connect(ctx, hub, tok.CreateDevice("u2", ""))
+
+		resp, err := client.CheckOnline(ctx, &grpcgw.CheckOnlineRequest{Uid: "u1"})
+		Ω(err).To(Succeed())
+		Ω(resp.GetOnline()).To(BeTrue())
+		resp, err = client.CheckOnline(ctx, &grpcgw.CheckOnlineRequest{Uid: "u3"})
+		Ω(err).To(Succeed())
+		Ω(resp.GetOnline()).To(BeFalse())
+
+		online, err := client.Online(ctx, &grpcgw.OnlineRequest{})
+		Ω(err).To(Succeed())
+		Ω(online.GetUids()).To(ConsistOf("u1", "u2"))
+	})
+
+	It("should kick uid or device", func() {
+		a1 := testutil.Connect(ctx, hub, tok.CreateDevice("u1", "d1"))
+		a2 := testutil.Connect(ctx, hub, tok.CreateDevice("u1", "d2"))
+
+		_, err := client.Kick(ctx, &grpcgw.KickRequest{Uid: "u1", Device: "d3"})
+		Ω(statusCode(err)).To(Equal(codes.NotFound))
+
+		_, err = client.Kick(ctx, &grpcgw.KickRequest{Uid: "u1", Device: "d1"})
+		Ω(err).To(Succeed())
+		Eventually(a1.ChClosed).Should(BeClosed())
+		Consistently(a2.ChClosed).ShouldNot(BeClosed())
+
+		_, err = client.Kick(ctx, &grpcgw.KickRequest{Uid: "u1"})
+		Ω(err).To(Succeed())
+		Eventually(a2.ChClosed).Should(BeClosed())
+	})
+
+	It("should relay messages and events", func() {
+		streamCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stream, err := client.Relay(streamCtx, &grpcgw.RelayRequest{Messages: true, Events: true})
+		Ω(err).To(Succeed())
+		// header is sent once subscribed
+		_, err = stream.Header()
+		Ω(err).To(Succeed())
+
+		adapter := testutil.Connect(ctx, hub, tok.CreateDevice("u1", "d1"))
+		adapter.ChRead <- []byte("up")
+
+		var events []*grpcgw.Event
+		var msg *grpcgw.InboundMessage
+		for msg == nil {
+			item, err := stream.Recv()
+			Ω(err).To(Succeed())
+			if e := item.GetEvent(); e != nil {
+				events = append(events, e)
+				continue
+			}
+			msg = item.GetMessage()
+		}
+		Ω(msg.GetUid()).To(Equal("u1"))
+		Ω(msg.GetDevice()).To(Equal("d1"))
+		Ω(msg.GetPayload()).To(Equal([]byte("up")))
+		Ω(msg.GetTimeUnixNano()).NotTo(BeZero())
+		Ω(events).To(ContainElement(And(HaveField("Type", "connected"), HaveField("Uid", "u1"), HaveField("Device", "d1"))))
+
+		cancel()
+		Eventually(func() codes.Code {
+			_, err := stream.Recv()
+			return statusCode(err)
+		}).Should(Equal(codes.Canceled))
+	})
+
+	It("should relay events only", func() {
+		stream, err := client.Relay(ctx, &grpcgw.RelayRequest{Events: true})
+		Ω(err).To(Succeed())
+		_, err = stream.Header()
+		Ω(err).To(Succeed())
+
+		adapter := testutil.Connect(ctx, hub, tok.CreateDevice("u1", ""))
+		adapter.ChRead <- []byte("up")
+		adapter.Close()
+
+		var types []string
+		for len(types) < 4 {
+			item, err := stream.Recv()
+			Ω(err).To(Succeed())
+			Ω(item.GetMessage()).To(BeNil())
+			types = append(types, item.GetEvent().GetType())
+		}
+		Ω(types).To(Equal([]string{"connected", "online", "disconnected", "offline"}))
+	})
+
+	It("should reject bad relay request", func() {
+		stream, err := client.Relay(ctx, &grpcgw.RelayRequest{})
+		Ω(err).To(Succeed())
+		_, err = stream.Recv()
+		Ω(statusCode(err)).To(Equal(codes.InvalidArgument))
+	})
+})
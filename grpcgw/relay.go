@@ -0,0 +1,75 @@
+package grpcgw
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/quexer/tok"
+)
+
+// relayBuffer is buffer size of each Relay stream, messages beyond it are dropped
+const relayBuffer = 1024
+
+// Relay is hub actor relaying received messages to Relay streams of Control service.
+// Every stream gets every message. Messages are dropped while there is no stream.
+type Relay struct {
+	next tok.ActorWithResult
+	mu   sync.RWMutex
+	subs map[*relaySub]struct{}
+}
+
+type relaySub struct {
+	ch      chan *RelayItem
+	dropped int // guarded by Relay.mu
+}
+
+// NewRelay create relay, next is optional actor called after relaying, and its result is returned to hub
+func NewRelay(next tok.ActorWithResult) *Relay {
+	return &Relay{next: next, subs: make(map[*relaySub]struct{})}
+}
+
+// OnReceive implements tok.ActorWithResult
+func (p *Relay) OnReceive(ctx context.Context, dv *tok.Device, data []byte) error {
+	p.publish(&RelayItem{Item: &RelayItem_Message{Message: &InboundMessage{
+		Uid:          uidString(dv.UID()),
+		Device:       dv.ID(),
+		Payload:      data,
+		TimeUnixNano: time.Now().UnixNano(),
+	}}})
+	if p.next != nil {
+		return p.next.OnReceive(ctx, dv, data)
+	}
+	return nil
+}
+
+func (p *Relay) publish(item *RelayItem) {
+	// write lock, as drop counters are updated
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for s := range p.subs {
+		select {
+		case s.ch <- item:
+		default:
+			s.dropped++
+			if s.dropped&(s.dropped-1) == 0 {
+				// log at 1, 2, 4, 8...
+				slog.Warn("[tok] relay stream is too slow, drop message", "dropped", s.dropped)
+			}
+		}
+	}
+}
+
+// subscribe returns channel of relayed messages, until cancel is called
+func (p *Relay) subscribe() (<-chan *RelayItem, func()) {
+	s := &relaySub{ch: make(chan *RelayItem, relayBuffer)}
+	p.mu.Lock()
+	p.subs[s] = struct{}{}
+	p.mu.Unlock()
+	return s.ch, func() {
+		p.mu.Lock()
+		delete(p.subs, s)
+		p.mu.Unlock()
+	}
+}
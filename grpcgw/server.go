@@ -0,0 +1,209 @@
+// Package grpcgw is gRPC control plane of tok hub, so that tok can run as a standalone gateway.
+// Control service wraps Hub.Send, Kick, CheckOnline and Online, and streams messages received by hub
+// and hub events to backend consumers, see tok.proto.
+//
+// Messages and service stubs are generated from tok.proto:
+//
+//	relay := grpcgw.NewRelay(nil)
+//	hub, hdl := tok.CreateWsHandler(auth, tok.WithWsHandlerHubConfig(tok.NewHubConfigWithResult(relay, opts...)))
+//	s := grpc.NewServer()
+//	grpcgw.RegisterControlServer(s, grpcgw.NewServer(hub, relay))
+package grpcgw
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative tok.proto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/quexer/tok"
+)
+
+// Option option of Server
+type Option func(*Server)
+
+// WithUIDParser set parser of uid in requests, default keeps uid as string.
+// uids in responses and relayed items are formatted by fmt "%v".
+func WithUIDParser(parse func(s string) (interface{}, error)) Option {
+	return func(s *Server) {
+		s.parseUID = parse
+	}
+}
+
+// Server implements ControlServer over hub
+type Server struct {
+	UnimplementedControlServer
+
+	hub      *tok.Hub
+	relay    *Relay
+	parseUID func(s string) (interface{}, error)
+}
+
+var _ ControlServer = (*Server)(nil)
+
+// NewServer create Control server of hub, relay should be the actor of hub for relaying messages, nil means events only
+func NewServer(hub *tok.Hub, relay *Relay, opts ...Option) *Server {
+	s := &Server{
+		hub:   hub,
+		relay: relay,
+		parseUID: func(s string) (interface{}, error) {
+			return s, nil
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Send implements ControlServer
+func (s *Server) Send(ctx context.Context, req *SendRequest) (*SendResponse, error) {
+	uid, err := s.uid(req.GetUid())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.hub.Send(ctx, uid, req.GetPayload(), req.GetTtl()); err != nil {
+		return nil, statusError(err)
+	}
+	return &SendResponse{}, nil
+}
+
+// Kick implements ControlServer
+func (s *Server) Kick(ctx context.Context, req *KickRequest) (*KickResponse, error) {
+	uid, err := s.uid(req.GetUid())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetDevice() != "" {
+		if err := s.hub.KickDevice(ctx, uid, req.GetDevice()); err != nil {
+			return nil, statusError(err)
+		}
+		return &KickResponse{}, nil
+	}
+	s.hub.Kick(ctx, uid)
+	if err := ctx.Err(); err != nil {
+		return nil, statusError(err)
+	}
+	return &KickResponse{}, nil
+}
+
+// CheckOnline implements ControlServer
+func (s *Server) CheckOnline(ctx context.Context, req *CheckOnlineRequest) (*CheckOnlineResponse, error) {
+	uid, err := s.uid(req.GetUid())
+	if err != nil {
+		return nil, err
+	}
+	online := s.hub.CheckOnline(ctx, uid)
+	if err := ctx.Err(); err != nil {
+		return nil, statusError(err)
+	}
+	return &CheckOnlineResponse{Online: online}, nil
+}
+
+// Online implements ControlServer
+func (s *Server) Online(ctx context.Context, _ *OnlineRequest) (*OnlineResponse, error) {
+	uids := s.hub.Online(ctx)
+	if err := ctx.Err(); err != nil {
+		return nil, statusError(err)
+	}
+	resp := &OnlineResponse{Uids: make([]string, 0, len(uids))}
+	for _, uid := range uids {
+		resp.Uids = append(resp.Uids, uidString(uid))
+	}
+	return resp, nil
+}
+
+// Relay implements ControlServer. Header is sent once subscribed, so that client knows nothing is missed after it.
+func (s *Server) Relay(req *RelayRequest, stream grpc.ServerStreamingServer[RelayItem]) error {
+	ctx := stream.Context()
+	if !req.GetMessages() && !req.GetEvents() {
+		return status.Error(codes.InvalidArgument, "nothing to relay")
+	}
+
+	var messages <-chan *RelayItem
+	if req.GetMessages() {
+		if s.relay == nil {
+			return status.Error(codes.FailedPrecondition, "relay is not set")
+		}
+		ch, cancel := s.relay.subscribe()
+		defer cancel()
+		messages = ch
+	}
+	var events <-chan tok.Event
+	if req.GetEvents() {
+		events = s.hub.Subscribe(ctx)
+	}
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	for {
+		var item *RelayItem
+		select {
+		case item = <-messages:
+		case e, ok := <-events:
+			if !ok {
+				// closed as ctx is done
+				return statusError(ctx.Err())
+			}
+			item = &RelayItem{Item: &RelayItem_Event{Event: eventOf(e)}}
+		case <-ctx.Done():
+			return statusError(ctx.Err())
+		}
+		if err := stream.Send(item); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) uid(str string) (interface{}, error) {
+	if str == "" {
+		return nil, status.Error(codes.InvalidArgument, "uid is required")
+	}
+	uid, err := s.parseUID(str)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "bad uid %q: %v", str, err)
+	}
+	return uid, nil
+}
+
+func eventOf(e tok.Event) *Event {
+	ev := &Event{
+		Type:         e.Type.String(),
+		Uid:          uidString(e.UID),
+		Reason:       e.Reason,
+		TimeUnixNano: e.Time.UnixNano(),
+	}
+	if e.Device != nil {
+		ev.Device = e.Device.ID()
+	}
+	if e.Kicker != nil {
+		ev.Kicker = e.Kicker.ID()
+	}
+	return ev
+}
+
+func uidString(uid interface{}) string {
+	return fmt.Sprint(uid)
+}
+
+// statusError maps error of hub to grpc status
+func statusError(err error) error {
+	switch {
+	case errors.Is(err, tok.ErrCacheFailed):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, tok.ErrOffline):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, tok.ErrSlowConsumer):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return status.FromContextError(err).Err()
+	}
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,785 @@
+// Control service of tok hub, see package grpcgw.
+// Go code is generated by protoc-gen-go and protoc-gen-go-grpc, see go:generate in server.go.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        v5.29.3
+// source: tok.proto
+
+package grpcgw
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SendRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Uid           string                 `protobuf:"bytes,1,opt,name=uid,proto3" json:"uid,omitempty"`
+	Payload       []byte                 `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	Ttl           uint32                 `protobuf:"varint,3,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendRequest) Reset() {
+	*x = SendRequest{}
+	mi := &file_tok_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendRequest) ProtoMessage() {}
+
+func (x *SendRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tok_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendRequest.ProtoReflect.Descriptor instead.
+func (*SendRequest) Descriptor() ([]byte, []int) {
+	return file_tok_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SendRequest) GetUid() string {
+	if x != nil {
+		return x.Uid
+	}
+	return ""
+}
+
+func (x *SendRequest) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *SendRequest) GetTtl() uint32 {
+	if x != nil {
+		return x.Ttl
+	}
+	return 0
+}
+
+type SendResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendResponse) Reset() {
+	*x = SendResponse{}
+	mi := &file_tok_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendResponse) ProtoMessage() {}
+
+func (x *SendResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tok_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendResponse.ProtoReflect.Descriptor instead.
+func (*SendResponse) Descriptor() ([]byte, []int) {
+	return file_tok_proto_rawDescGZIP(), []int{1}
+}
+
+type KickRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Uid           string                 `protobuf:"bytes,1,opt,name=uid,proto3" json:"uid,omitempty"`
+	Device        string                 `protobuf:"bytes,2,opt,name=device,proto3" json:"device,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KickRequest) Reset() {
+	*x = KickRequest{}
+	mi := &file_tok_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KickRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KickRequest) ProtoMessage() {}
+
+func (x *KickRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tok_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KickRequest.ProtoReflect.Descriptor instead.
+func (*KickRequest) Descriptor() ([]byte, []int) {
+	return file_tok_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *KickRequest) GetUid() string {
+	if x != nil {
+		return x.Uid
+	}
+	return ""
+}
+
+func (x *KickRequest) GetDevice() string {
+	if x != nil {
+		return x.Device
+	}
+	return ""
+}
+
+type KickResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KickResponse) Reset() {
+	*x = KickResponse{}
+	mi := &file_tok_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KickResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KickResponse) ProtoMessage() {}
+
+func (x *KickResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tok_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KickResponse.ProtoReflect.Descriptor instead.
+func (*KickResponse) Descriptor() ([]byte, []int) {
+	return file_tok_proto_rawDescGZIP(), []int{3}
+}
+
+type CheckOnlineRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Uid           string                 `protobuf:"bytes,1,opt,name=uid,proto3" json:"uid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckOnlineRequest) Reset() {
+	*x = CheckOnlineRequest{}
+	mi := &file_tok_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckOnlineRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckOnlineRequest) ProtoMessage() {}
+
+func (x *CheckOnlineRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tok_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckOnlineRequest.ProtoReflect.Descriptor instead.
+func (*CheckOnlineRequest) Descriptor() ([]byte, []int) {
+	return file_tok_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CheckOnlineRequest) GetUid() string {
+	if x != nil {
+		return x.Uid
+	}
+	return ""
+}
+
+type CheckOnlineResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Online        bool                   `protobuf:"varint,1,opt,name=online,proto3" json:"online,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckOnlineResponse) Reset() {
+	*x = CheckOnlineResponse{}
+	mi := &file_tok_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckOnlineResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckOnlineResponse) ProtoMessage() {}
+
+func (x *CheckOnlineResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tok_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckOnlineResponse.ProtoReflect.Descriptor instead.
+func (*CheckOnlineResponse) Descriptor() ([]byte, []int) {
+	return file_tok_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *CheckOnlineResponse) GetOnline() bool {
+	if x != nil {
+		return x.Online
+	}
+	return false
+}
+
+type OnlineRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OnlineRequest) Reset() {
+	*x = OnlineRequest{}
+	mi := &file_tok_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OnlineRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OnlineRequest) ProtoMessage() {}
+
+func (x *OnlineRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tok_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OnlineRequest.ProtoReflect.Descriptor instead.
+func (*OnlineRequest) Descriptor() ([]byte, []int) {
+	return file_tok_proto_rawDescGZIP(), []int{6}
+}
+
+type OnlineResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Uids          []string               `protobuf:"bytes,1,rep,name=uids,proto3" json:"uids,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OnlineResponse) Reset() {
+	*x = OnlineResponse{}
+	mi := &file_tok_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OnlineResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OnlineResponse) ProtoMessage() {}
+
+func (x *OnlineResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tok_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OnlineResponse.ProtoReflect.Descriptor instead.
+func (*OnlineResponse) Descriptor() ([]byte, []int) {
+	return file_tok_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *OnlineResponse) GetUids() []string {
+	if x != nil {
+		return x.Uids
+	}
+	return nil
+}
+
+type RelayRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Messages      bool                   `protobuf:"varint,1,opt,name=messages,proto3" json:"messages,omitempty"` // relay messages received by hub
+	Events        bool                   `protobuf:"varint,2,opt,name=events,proto3" json:"events,omitempty"`     // relay hub events
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RelayRequest) Reset() {
+	*x = RelayRequest{}
+	mi := &file_tok_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RelayRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RelayRequest) ProtoMessage() {}
+
+func (x *RelayRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tok_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RelayRequest.ProtoReflect.Descriptor instead.
+func (*RelayRequest) Descriptor() ([]byte, []int) {
+	return file_tok_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *RelayRequest) GetMessages() bool {
+	if x != nil {
+		return x.Messages
+	}
+	return false
+}
+
+func (x *RelayRequest) GetEvents() bool {
+	if x != nil {
+		return x.Events
+	}
+	return false
+}
+
+type RelayItem struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Item:
+	//
+	//	*RelayItem_Message
+	//	*RelayItem_Event
+	Item          isRelayItem_Item `protobuf_oneof:"item"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RelayItem) Reset() {
+	*x = RelayItem{}
+	mi := &file_tok_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RelayItem) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RelayItem) ProtoMessage() {}
+
+func (x *RelayItem) ProtoReflect() protoreflect.Message {
+	mi := &file_tok_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RelayItem.ProtoReflect.Descriptor instead.
+func (*RelayItem) Descriptor() ([]byte, []int) {
+	return file_tok_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *RelayItem) GetItem() isRelayItem_Item {
+	if x != nil {
+		return x.Item
+	}
+	return nil
+}
+
+func (x *RelayItem) GetMessage() *InboundMessage {
+	if x != nil {
+		if x, ok := x.Item.(*RelayItem_Message); ok {
+			return x.Message
+		}
+	}
+	return nil
+}
+
+func (x *RelayItem) GetEvent() *Event {
+	if x != nil {
+		if x, ok := x.Item.(*RelayItem_Event); ok {
+			return x.Event
+		}
+	}
+	return nil
+}
+
+type isRelayItem_Item interface {
+	isRelayItem_Item()
+}
+
+type RelayItem_Message struct {
+	Message *InboundMessage `protobuf:"bytes,1,opt,name=message,proto3,oneof"`
+}
+
+type RelayItem_Event struct {
+	Event *Event `protobuf:"bytes,2,opt,name=event,proto3,oneof"`
+}
+
+func (*RelayItem_Message) isRelayItem_Item() {}
+
+func (*RelayItem_Event) isRelayItem_Item() {}
+
+type InboundMessage struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Uid           string                 `protobuf:"bytes,1,opt,name=uid,proto3" json:"uid,omitempty"`
+	Device        string                 `protobuf:"bytes,2,opt,name=device,proto3" json:"device,omitempty"`
+	Payload       []byte                 `protobuf:"bytes,3,opt,name=payload,proto3" json:"payload,omitempty"`
+	TimeUnixNano  int64                  `protobuf:"varint,4,opt,name=time_unix_nano,json=timeUnixNano,proto3" json:"time_unix_nano,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InboundMessage) Reset() {
+	*x = InboundMessage{}
+	mi := &file_tok_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InboundMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InboundMessage) ProtoMessage() {}
+
+func (x *InboundMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_tok_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InboundMessage.ProtoReflect.Descriptor instead.
+func (*InboundMessage) Descriptor() ([]byte, []int) {
+	return file_tok_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *InboundMessage) GetUid() string {
+	if x != nil {
+		return x.Uid
+	}
+	return ""
+}
+
+func (x *InboundMessage) GetDevice() string {
+	if x != nil {
+		return x.Device
+	}
+	return ""
+}
+
+func (x *InboundMessage) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *InboundMessage) GetTimeUnixNano() int64 {
+	if x != nil {
+		return x.TimeUnixNano
+	}
+	return 0
+}
+
+type Event struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"` // connected, disconnected, online, offline or kicked
+	Uid           string                 `protobuf:"bytes,2,opt,name=uid,proto3" json:"uid,omitempty"`
+	Device        string                 `protobuf:"bytes,3,opt,name=device,proto3" json:"device,omitempty"`
+	Reason        string                 `protobuf:"bytes,4,opt,name=reason,proto3" json:"reason,omitempty"`
+	Kicker        string                 `protobuf:"bytes,5,opt,name=kicker,proto3" json:"kicker,omitempty"` // device id of kicker of kicked event
+	TimeUnixNano  int64                  `protobuf:"varint,6,opt,name=time_unix_nano,json=timeUnixNano,proto3" json:"time_unix_nano,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_tok_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_tok_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_tok_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *Event) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Event) GetUid() string {
+	if x != nil {
+		return x.Uid
+	}
+	return ""
+}
+
+func (x *Event) GetDevice() string {
+	if x != nil {
+		return x.Device
+	}
+	return ""
+}
+
+func (x *Event) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *Event) GetKicker() string {
+	if x != nil {
+		return x.Kicker
+	}
+	return ""
+}
+
+func (x *Event) GetTimeUnixNano() int64 {
+	if x != nil {
+		return x.TimeUnixNano
+	}
+	return 0
+}
+
+var File_tok_proto protoreflect.FileDescriptor
+
+const file_tok_proto_rawDesc = "" +
+	"\n" +
+	"\ttok.proto\x12\x06tok.v1\"K\n" +
+	"\vSendRequest\x12\x10\n" +
+	"\x03uid\x18\x01 \x01(\tR\x03uid\x12\x18\n" +
+	"\apayload\x18\x02 \x01(\fR\apayload\x12\x10\n" +
+	"\x03ttl\x18\x03 \x01(\rR\x03ttl\"\x0e\n" +
+	"\fSendResponse\"7\n" +
+	"\vKickRequest\x12\x10\n" +
+	"\x03uid\x18\x01 \x01(\tR\x03uid\x12\x16\n" +
+	"\x06device\x18\x02 \x01(\tR\x06device\"\x0e\n" +
+	"\fKickResponse\"&\n" +
+	"\x12CheckOnlineRequest\x12\x10\n" +
+	"\x03uid\x18\x01 \x01(\tR\x03uid\"-\n" +
+	"\x13CheckOnlineResponse\x12\x16\n" +
+	"\x06online\x18\x01 \x01(\bR\x06online\"\x0f\n" +
+	"\rOnlineRequest\"$\n" +
+	"\x0eOnlineResponse\x12\x12\n" +
+	"\x04uids\x18\x01 \x03(\tR\x04uids\"B\n" +
+	"\fRelayRequest\x12\x1a\n" +
+	"\bmessages\x18\x01 \x01(\bR\bmessages\x12\x16\n" +
+	"\x06events\x18\x02 \x01(\bR\x06events\"n\n" +
+	"\tRelayItem\x122\n" +
+	"\amessage\x18\x01 \x01(\v2\x16.tok.v1.InboundMessageH\x00R\amessage\x12%\n" +
+	"\x05event\x18\x02 \x01(\v2\r.tok.v1.EventH\x00R\x05eventB\x06\n" +
+	"\x04item\"z\n" +
+	"\x0eInboundMessage\x12\x10\n" +
+	"\x03uid\x18\x01 \x01(\tR\x03uid\x12\x16\n" +
+	"\x06device\x18\x02 \x01(\tR\x06device\x12\x18\n" +
+	"\apayload\x18\x03 \x01(\fR\apayload\x12$\n" +
+	"\x0etime_unix_nano\x18\x04 \x01(\x03R\ftimeUnixNano\"\x9b\x01\n" +
+	"\x05Event\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x10\n" +
+	"\x03uid\x18\x02 \x01(\tR\x03uid\x12\x16\n" +
+	"\x06device\x18\x03 \x01(\tR\x06device\x12\x16\n" +
+	"\x06reason\x18\x04 \x01(\tR\x06reason\x12\x16\n" +
+	"\x06kicker\x18\x05 \x01(\tR\x06kicker\x12$\n" +
+	"\x0etime_unix_nano\x18\x06 \x01(\x03R\ftimeUnixNano2\xa4\x02\n" +
+	"\aControl\x121\n" +
+	"\x04Send\x12\x13.tok.v1.SendRequest\x1a\x14.tok.v1.SendResponse\x121\n" +
+	"\x04Kick\x12\x13.tok.v1.KickRequest\x1a\x14.tok.v1.KickResponse\x12F\n" +
+	"\vCheckOnline\x12\x1a.tok.v1.CheckOnlineRequest\x1a\x1b.tok.v1.CheckOnlineResponse\x127\n" +
+	"\x06Online\x12\x15.tok.v1.OnlineRequest\x1a\x16.tok.v1.OnlineResponse\x122\n" +
+	"\x05Relay\x12\x14.tok.v1.RelayRequest\x1a\x11.tok.v1.RelayItem0\x01B\x1eZ\x1cgithub.com/quexer/tok/grpcgwb\x06proto3"
+
+var (
+	file_tok_proto_rawDescOnce sync.Once
+	file_tok_proto_rawDescData []byte
+)
+
+func file_tok_proto_rawDescGZIP() []byte {
+	file_tok_proto_rawDescOnce.Do(func() {
+		file_tok_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_tok_proto_rawDesc), len(file_tok_proto_rawDesc)))
+	})
+	return file_tok_proto_rawDescData
+}
+
+var file_tok_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_tok_proto_goTypes = []any{
+	(*SendRequest)(nil),         // 0: tok.v1.SendRequest
+	(*SendResponse)(nil),        // 1: tok.v1.SendResponse
+	(*KickRequest)(nil),         // 2: tok.v1.KickRequest
+	(*KickResponse)(nil),        // 3: tok.v1.KickResponse
+	(*CheckOnlineRequest)(nil),  // 4: tok.v1.CheckOnlineRequest
+	(*CheckOnlineResponse)(nil), // 5: tok.v1.CheckOnlineResponse
+	(*OnlineRequest)(nil),       // 6: tok.v1.OnlineRequest
+	(*OnlineResponse)(nil),      // 7: tok.v1.OnlineResponse
+	(*RelayRequest)(nil),        // 8: tok.v1.RelayRequest
+	(*RelayItem)(nil),           // 9: tok.v1.RelayItem
+	(*InboundMessage)(nil),      // 10: tok.v1.InboundMessage
+	(*Event)(nil),               // 11: tok.v1.Event
+}
+var file_tok_proto_depIdxs = []int32{
+	10, // 0: tok.v1.RelayItem.message:type_name -> tok.v1.InboundMessage
+	11, // 1: tok.v1.RelayItem.event:type_name -> tok.v1.Event
+	0,  // 2: tok.v1.Control.Send:input_type -> tok.v1.SendRequest
+	2,  // 3: tok.v1.Control.Kick:input_type -> tok.v1.KickRequest
+	4,  // 4: tok.v1.Control.CheckOnline:input_type -> tok.v1.CheckOnlineRequest
+	6,  // 5: tok.v1.Control.Online:input_type -> tok.v1.OnlineRequest
+	8,  // 6: tok.v1.Control.Relay:input_type -> tok.v1.RelayRequest
+	1,  // 7: tok.v1.Control.Send:output_type -> tok.v1.SendResponse
+	3,  // 8: tok.v1.Control.Kick:output_type -> tok.v1.KickResponse
+	5,  // 9: tok.v1.Control.CheckOnline:output_type -> tok.v1.CheckOnlineResponse
+	7,  // 10: tok.v1.Control.Online:output_type -> tok.v1.OnlineResponse
+	9,  // 11: tok.v1.Control.Relay:output_type -> tok.v1.RelayItem
+	7,  // [7:12] is the sub-list for method output_type
+	2,  // [2:7] is the sub-list for method input_type
+	2,  // [2:2] is the sub-list for extension type_name
+	2,  // [2:2] is the sub-list for extension extendee
+	0,  // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_tok_proto_init() }
+func file_tok_proto_init() {
+	if File_tok_proto != nil {
+		return
+	}
+	file_tok_proto_msgTypes[9].OneofWrappers = []any{
+		(*RelayItem_Message)(nil),
+		(*RelayItem_Event)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_tok_proto_rawDesc), len(file_tok_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   12,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_tok_proto_goTypes,
+		DependencyIndexes: file_tok_proto_depIdxs,
+		MessageInfos:      file_tok_proto_msgTypes,
+	}.Build()
+	File_tok_proto = out.File
+	file_tok_proto_goTypes = nil
+	file_tok_proto_depIdxs = nil
+}
@@ -5,8 +5,11 @@ import (
 	"errors"
 	"expvar"
 	"fmt"
+	"hash/maphash"
 	"log"
 	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,125 +21,121 @@ var (
 	expDeq    = expvar.NewInt("tokDeq")
 )
 
+const (
+	keepaliveWorkers = 64    // number of goroutines sending server pings
+	keepaliveBacklog = 16384 // keepalive jobs queued for workers, jobs beyond it run in new goroutines
+)
+
 type checkFrame struct {
 	uid    interface{} // user id
 	chBool chan bool   // channel to return online status
 }
 
 type downFrame struct {
-	uid   interface{} // user id
-	ttl   uint32      // ttl in seconds
-	data  []byte      // data to send
-	chErr chan error  // channel to read send result from
+	ctx   context.Context // context of sender, for caching
+	uid   interface{}     // user id
+	ttl   uint32          // ttl in seconds
+	data  []byte          // data to send
+	chErr chan error      // channel to read send result from, buffered so that result is never blocked by gone sender
 }
 
 type upFrame struct {
-	dv   *Device // user device
-	data []byte  // data
+	ctx  context.Context // handler context of message
+	conn *connection     // connection of sender
+	data []byte          // data
 }
 
 // Hub core of tok, dispatch message between connections
 type Hub struct {
-	cons          map[interface{}][]*connection // connection list
-	chUp          chan *upFrame
-	chDown        chan *downFrame
-	chConState    chan *conState
-	chReadSignal  chan interface{}
-	chKick        chan interface{}
-	chQueryOnline chan chan []interface{}
-	chCheck       chan *checkFrame
-	config        *HubConfig // config for hub
+	shards      []*hubShard   // event loops, connections are sharded by uid hash
+	seed        maphash.Seed  // seed of uid hash
+	wheel       *timingWheel  // drives keepalive of all connections
+	chKeepalive chan func()   // keepalive jobs fired by wheel
+	config      *HubConfig    // config for hub
+	requests    sync.Map      // pending requests by correlation id, see Request
+	requestSeq  atomic.Uint64 // last correlation id
+	events      eventBus      // subscribers of hub events, see Subscribe
 }
 
 func createHub(config *HubConfig) *Hub {
 	if config.readTimeout > 0 {
 		slog.Info("[tok] read timeout is enabled, make sure it's greater than your client ping interval. otherwise you'll get read timeout err")
 	} else {
-		// quit if both read timeout and ping are disabled
-		if config.pingProducer == nil {
-			log.Fatalln("[tok] fatal: both read timeout and server ping have been disabled, server socket resource leak might happen")
+		// quit if read timeout, ping and idle timeout are all disabled.
+		// control ping doesn't count, as adapters without ControlPinger, e.g. tcp, fall back to PingGenerator
+		if config.pingProducer == nil && config.idleTimeout <= 0 {
+			log.Fatalln("[tok] fatal: read timeout, server ping and idle timeout have been disabled, server socket resource leak might happen")
 		}
 	}
 
 	hub := &Hub{
-		cons:          make(map[interface{}][]*connection),
-		chUp:          make(chan *upFrame),
-		chDown:        make(chan *downFrame),
-		chConState:    make(chan *conState),
-		chReadSignal:  make(chan interface{}),
-		chKick:        make(chan interface{}),
-		chQueryOnline: make(chan chan []interface{}),
-		chCheck:       make(chan *checkFrame),
-		config:        config,
-	}
-	go hub.run()
+		seed:        maphash.MakeSeed(),
+		wheel:       newTimingWheel(wheelTick(config)),
+		chKeepalive: make(chan func(), keepaliveBacklog),
+		config:      config,
+	}
+	for i := 0; i < max(config.shards, 1); i++ {
+		shard := newHubShard(hub)
+		hub.shards = append(hub.shards, shard)
+		go shard.run()
+	}
+	for i := 0; i < keepaliveWorkers; i++ {
+		go hub.keepaliveWorker()
+	}
 	return hub
 }
 
-func (p *Hub) run() {
-	for {
+// shard returns the shard owning uid
+func (p *Hub) shard(uid interface{}) *hubShard {
+	if len(p.shards) == 1 {
+		return p.shards[0]
+	}
+	return p.shards[maphash.Comparable(p.seed, uid)%uint64(len(p.shards))]
+}
 
-		select {
-		case state := <-p.chConState:
-			slog.Debug("connection state change", "online", state.online, "con", &state.con)
+// wheelTick returns timing wheel precision, a tenth of the shortest keepalive interval, capped at 100ms
+func wheelTick(config *HubConfig) time.Duration {
+	tick := 100 * time.Millisecond
+	for _, d := range []time.Duration{config.serverPingInterval, config.idleTimeout} {
+		if d > 0 && d/10 < tick {
+			tick = d / 10
+		}
+	}
+	return max(tick, time.Millisecond)
+}
 
-			if state.online {
-				p.goOnline(state.con)
-			} else {
-				p.goOffline(state.con)
-			}
-			count := int64(len(p.cons))
-			expOnline.Set(count)
-		case f := <-p.chUp:
-			slog.Debug("up data")
-			expUp.Add(1)
-			go func() {
-				// default is f.data
-				data := f.data
-				// Use the optional BeforeReceive handler if provided
-				if hdl := p.config.hdlBeforeReceive; hdl != nil {
-					if b, err := hdl.BeforeReceive(f.dv, f.data); err != nil {
-						slog.Error("before receive failed", "err", err)
-						return
-					} else {
-						data = b
-					}
-				}
-				p.config.actor.OnReceive(f.dv, data)
-			}()
-		case ff := <-p.chDown:
-			if l := p.cons[ff.uid]; len(l) > 0 {
-				// online
-				go p.down(ff, l)
-			} else {
-				// offline
-				if ff.ttl == 0 {
-					ff.chErr <- ErrOffline
-					close(ff.chErr)
-				} else {
-					go p.cache(context.Background(), ff)
-				}
-			}
-		case cf := <-p.chCheck:
-			_, ok := p.cons[cf.uid]
-			cf.chBool <- ok
-			close(cf.chBool)
-		case uid := <-p.chReadSignal:
-			// only pop msg for online user
-			if len(p.cons[uid]) > 0 {
-				go p.popMsg(context.Background(), uid)
-			}
-		case uid := <-p.chKick:
-			p.innerKick(uid)
-		case chOnline := <-p.chQueryOnline:
-			result := make([]interface{}, 0, len(p.cons))
-			for uid := range p.cons {
-				result = append(result, uid)
-			}
-			chOnline <- result
-			close(chOnline)
+// dispatch passes upstream frame to actor, errors go to error policy
+func (p *Hub) dispatch(f *upFrame) {
+	ctx := f.ctx
+	data, err := p.beforeReceive(ctx, f.conn, f.data)
+	if err == nil && p.config.requestCodec != nil {
+		var ok bool
+		if ctx, data, ok, err = p.correlate(ctx, f.conn, data); err == nil && !ok {
+			return
 		}
 	}
+	if err == nil {
+		err = p.onReceive(ctx, f.conn, data)
+	}
+	// panics are reported to PanicHandler already
+	if err != nil && !errors.Is(err, ErrHookPanic) {
+		p.onReceiveError(ctx, f.conn, err)
+	}
+}
+
+// beforeReceive preprocess incoming data with the optional BeforeReceive handler
+func (p *Hub) beforeReceive(ctx context.Context, conn *connection, data []byte) (b []byte, err error) {
+	hdl := p.config.hdlBeforeReceive
+	if hdl == nil {
+		return data, nil
+	}
+	defer p.recoverHook("BeforeReceive", conn, &err)
+	return hdl.BeforeReceive(ctx, conn.dv, data)
+}
+
+func (p *Hub) onReceive(ctx context.Context, conn *connection, data []byte) (err error) {
+	defer p.recoverHook("OnReceive", conn, &err)
+	return p.config.actor.OnReceive(ctx, conn.dv, data)
 }
 
 func (p *Hub) popMsg(ctx context.Context, uid interface{}) {
@@ -144,7 +143,7 @@ func (p *Hub) popMsg(ctx context.Context, uid interface{}) {
 		return
 	}
 	for {
-		b, err := p.config.q.Deq(ctx, uid)
+		b, err := p.deq(ctx, uid)
 		if err != nil {
 			slog.Warn("deq failed", "err", err)
 			return
@@ -155,7 +154,7 @@ func (p *Hub) popMsg(ctx context.Context, uid interface{}) {
 		}
 		expDeq.Add(1)
 		if err := p.Send(ctx, uid, b, 0); err != nil {
-			if err := p.config.q.Enq(ctx, uid, b); err != nil {
+			if err := p.enq(ctx, uid, b); err != nil {
 				slog.Warn("re-cache failed", "err", err, "uid", uid)
 			}
 			return
@@ -167,11 +166,25 @@ func (p *Hub) popMsg(ctx context.Context, uid interface{}) {
 // ttl is expiry seconds. 0 means only send to online user
 // If ttl = 0 and user is offline, ErrOffline will be returned.
 // If ttl > 0 and user is offline or online but send fail, message will be cached for ttl seconds.
+// If ctx is done before the result is known, ctx.Err() is returned, the message might have been sent or cached anyway.
 func (p *Hub) Send(ctx context.Context, to interface{}, b []byte, ttl uint32) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ff := &downFrame{ctx: ctx, uid: to, data: b, ttl: ttl, chErr: make(chan error, 1)}
+	select {
+	case p.shard(to).chDown <- ff:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 
-	ff := &downFrame{uid: to, data: b, ttl: ttl, chErr: make(chan error)}
-	p.chDown <- ff
-	err := <-ff.chErr
+	var err error
+	select {
+	case err = <-ff.chErr:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 
 	// if cache failed, return err directly
 	if errors.Is(err, ErrCacheFailed) {
@@ -181,30 +194,71 @@ func (p *Hub) Send(ctx context.Context, to interface{}, b []byte, ttl uint32) er
 	if ttl > 0 && err != nil {
 		// Create a new downFrame for caching to avoid channel reuse issues
 		cacheFF := &downFrame{
+			ctx:   ctx,
 			uid:   ff.uid,
 			data:  ff.data,
 			ttl:   ff.ttl,
-			chErr: make(chan error),
+			chErr: make(chan error, 1),
 		}
-		// Use the passed context instead of Background()
 		go p.cache(ctx, cacheFF)
-		return <-cacheFF.chErr
+		select {
+		case err = <-cacheFF.chErr:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 	return err
 }
 
-// CheckOnline return whether user online or not
+// CheckOnline return whether user online or not.
+// It returns false if ctx is done before the answer.
 func (p *Hub) CheckOnline(ctx context.Context, uid interface{}) bool {
-	cf := &checkFrame{uid: uid, chBool: make(chan bool)}
-	p.chCheck <- cf
-	return <-cf.chBool
+	if ctx.Err() != nil {
+		return false
+	}
+	cf := &checkFrame{uid: uid, chBool: make(chan bool, 1)}
+	select {
+	case p.shard(uid).chCheck <- cf:
+	case <-ctx.Done():
+		return false
+	}
+
+	select {
+	case ok := <-cf.chBool:
+		return ok
+	case <-ctx.Done():
+		return false
+	}
 }
 
-// Online query online user list
+// Online query online user list.
+// It returns nil if ctx is done before all shards answered.
 func (p *Hub) Online(ctx context.Context) []interface{} {
-	ch := make(chan []interface{})
-	p.chQueryOnline <- ch
-	return <-ch
+	if ctx.Err() != nil {
+		return nil
+	}
+	chs := make([]chan []interface{}, 0, len(p.shards))
+	for _, shard := range p.shards {
+		ch := make(chan []interface{}, 1)
+		select {
+		case shard.chQueryOnline <- ch:
+		case <-ctx.Done():
+			return nil
+		}
+		chs = append(chs, ch)
+	}
+
+	result := make([]interface{}, 0)
+	for _, ch := range chs {
+		select {
+		case l := <-ch:
+			result = append(result, l...)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return result
 }
 
 func (p *Hub) cache(ctx context.Context, ff *downFrame) {
@@ -215,73 +269,80 @@ func (p *Hub) cache(ctx context.Context, ff *downFrame) {
 		return
 	}
 
-	if err := p.config.q.Enq(ctx, ff.uid, ff.data, ff.ttl); err != nil {
+	if err := p.enq(ctx, ff.uid, ff.data, ff.ttl); err != nil {
 		ff.chErr <- fmt.Errorf("%w: %w", ErrCacheFailed, err)
 	}
 }
 
+// enq calls Enq of queue, which must be configured. Panic is reported to PanicHandler
+func (p *Hub) enq(ctx context.Context, uid interface{}, data []byte, ttl ...uint32) (err error) {
+	defer p.recoverHook("Enq", nil, &err)
+	return p.config.q.Enq(ctx, uid, data, ttl...)
+}
+
+// deq calls Deq of queue, which must be configured. Panic is reported to PanicHandler
+func (p *Hub) deq(ctx context.Context, uid interface{}) (b []byte, err error) {
+	defer p.recoverHook("Deq", nil, &err)
+	return p.config.q.Deq(ctx, uid)
+}
+
 func (p *Hub) down(f *downFrame, conns []*connection) {
 	defer close(f.chErr)
 	expDown.Add(1)
 
 	var lastErr error
 	for _, con := range conns {
-		data, err := p.beforeSend(con.dv, f.data)
-		if err != nil {
+		if err := p.writeTo(con.messageContext(false, f.ctx), con, f.data, f.ttl); err != nil {
 			lastErr = err
-			continue
-		}
-		if err := con.Write(data); err != nil {
-			lastErr = err
-			continue
-		}
-
-		if hdl := p.config.hdlAfterSend; hdl != nil {
-			go hdl.AfterSend(con.dv, f.data)
 		}
 	}
 	f.chErr <- lastErr
 }
 
-func (p *Hub) goOffline(conn *connection) {
-	l := p.cons[conn.uid()]
-	rest := connExclude(l, conn)
-
-	// this connection has gotten offline, ignore
-	if len(l) == len(rest) {
-		return
+// writeTo sends message to connection through BeforeSend and AfterSend handlers, with write queue if it's enabled.
+// ctx is the message context.
+func (p *Hub) writeTo(ctx context.Context, con *connection, b []byte, ttl uint32) error {
+	data, err := p.beforeSend(ctx, con, b)
+	if err != nil {
+		return err
 	}
-
-	if len(rest) == 0 {
-		delete(p.cons, conn.uid())
-	} else {
-		p.cons[conn.uid()] = rest
+	if con.outq != nil {
+		// AfterSend is called by writer goroutine
+		return con.enqueue(&outFrame{ctx: ctx, data: data, orig: b, ttl: ttl})
+	}
+	if err := con.Write(data); err != nil {
+		return err
 	}
 
-	go p.close(conn)
+	if p.config.hdlAfterSend != nil {
+		go p.afterSend(ctx, con, b)
+	}
+	return nil
 }
 
-func (p *Hub) innerKick(uid interface{}) {
-	for _, conn := range p.cons[uid] {
-		go p.close(conn)
-	}
-	delete(p.cons, uid)
+// afterSend calls AfterSend handler, which must be configured
+func (p *Hub) afterSend(ctx context.Context, conn *connection, data []byte) {
+	defer p.recoverHook("AfterSend", conn, nil)
+	p.config.hdlAfterSend.AfterSend(ctx, conn.dv, data)
 }
 
-func (p *Hub) byeThenClose(kicker *Device, conn *connection) {
+func (p *Hub) byeThenClose(kicker *Device, reason string, conn *connection) {
 	defer p.close(conn)
+	p.bye(kicker, reason, conn)
+}
 
-	// Only generate bye message if ByeGenerator is configured
+// bye writes bye message to conn, if ByeGenerator is configured
+func (p *Hub) bye(kicker *Device, reason string, conn *connection) {
 	if p.config.byeGenerator == nil {
 		return
 	}
 
-	byeData := p.config.byeGenerator.Bye(kicker, "sso", conn.dv)
+	byeData := p.byeData(kicker, reason, conn)
 	if byeData == nil {
 		return
 	}
 
-	data, err := p.beforeSend(conn.dv, byeData)
+	data, err := p.beforeSend(conn.messageContext(false, nil), conn, byeData)
 	if err != nil {
 		slog.Warn("[tok] before send bye failed", "err", err)
 	}
@@ -290,62 +351,96 @@ func (p *Hub) byeThenClose(kicker *Device, conn *connection) {
 	}
 }
 
+// byeData generates bye message, it's nil if ByeGenerator panicked
+func (p *Hub) byeData(kicker *Device, reason string, conn *connection) []byte {
+	defer p.recoverHook("Bye", conn, nil)
+	return p.config.byeGenerator.Bye(kicker, reason, conn.dv)
+}
+
 func (p *Hub) close(conn *connection) {
 	conn.close()
 
 	// Call the optional close handler if configured
 	if hdl := p.config.closeHandler; hdl != nil {
-		hdl.OnClose(conn.dv)
+		defer p.recoverHook("OnClose", conn, nil)
+		hdl.OnClose(context.WithoutCancel(conn.ctx), conn.dv)
 	}
 }
 
-func (p *Hub) goOnline(conn *connection) {
-	defer func() {
-		go p.tryDeliver(context.Background(), conn.uid())
-	}()
+// tryDeliver try to deliver all messages, if uid is online
+func (p *Hub) tryDeliver(ctx context.Context, uid interface{}) {
+	p.shard(uid).chReadSignal <- uid
+}
 
-	l := p.cons[conn.uid()]
-	if l == nil {
-		p.cons[conn.uid()] = []*connection{conn}
+// Kick all connections of uid.
+// Nothing happens if ctx is done before hub accepted it.
+func (p *Hub) Kick(ctx context.Context, uid interface{}) {
+	if ctx.Err() != nil {
 		return
 	}
+	select {
+	case p.shard(uid).chKick <- uid:
+	case <-ctx.Done():
+	}
+}
 
-	if p.config.sso {
-		for _, c := range l {
-			if conn.ShareConn(c) {
-				continue // never close share connection
-			}
-			// notify before close connection
-			go p.byeThenClose(conn.dv, c)
-		}
-		p.cons[conn.uid()] = []*connection{conn}
-		return
+// KickDevice closes connection of device deviceID of uid, ErrOffline is returned if it's not online.
+func (p *Hub) KickDevice(ctx context.Context, uid interface{}, deviceID string) error {
+	conn, err := p.findConn(ctx, uid, deviceID)
+	if err != nil {
+		return err
 	}
+	conn.triggerOffline(DisconnectReasonKicked)
+	return nil
+}
 
-	// it's a new connection
-	if len(connExclude(l, conn)) == len(l) {
-		l = append(l, conn)
-		p.cons[conn.uid()] = l
+// SendDevice sends message to device deviceID of uid only, ErrOffline is returned if it's not online.
+// Unlike Send, message is never cached.
+func (p *Hub) SendDevice(ctx context.Context, uid interface{}, deviceID string, b []byte) error {
+	conn, err := p.findConn(ctx, uid, deviceID)
+	if err != nil {
+		return err
 	}
+	return p.writeTo(conn.messageContext(false, ctx), conn, b, 0)
 }
 
-// tryDeliver try to deliver all messages, if uid is online
-func (p *Hub) tryDeliver(ctx context.Context, uid interface{}) {
-	p.chReadSignal <- uid
+// QueueLen returns number of cached messages of uid, ErrQueueRequired if hub has no queue
+func (p *Hub) QueueLen(ctx context.Context, uid interface{}) (n int, err error) {
+	if p.config.q == nil {
+		return 0, ErrQueueRequired
+	}
+	defer p.recoverHook("Len", nil, &err)
+	return p.config.q.Len(ctx, uid)
 }
 
-// Kick all connections of uid
-func (p *Hub) Kick(ctx context.Context, uid interface{}) {
-	p.chKick <- uid
+// PurgeQueue drops cached messages of uid, and returns how many are dropped. ErrQueueRequired if hub has no queue
+func (p *Hub) PurgeQueue(ctx context.Context, uid interface{}) (int, error) {
+	if p.config.q == nil {
+		return 0, ErrQueueRequired
+	}
+	n := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		b, err := p.deq(ctx, uid)
+		if err != nil {
+			return n, err
+		}
+		if len(b) == 0 {
+			return n, nil
+		}
+		n++
+	}
 }
 
 func (p *Hub) stateChange(conn *connection, online bool) {
-	p.chConState <- &conState{conn, online}
+	p.shard(conn.uid()).chConState <- &conState{conn, online}
 }
 
 // receive data from user
-func (p *Hub) receive(dv *Device, b []byte) {
-	p.chUp <- &upFrame{dv: dv, data: b}
+func (p *Hub) receive(ctx context.Context, conn *connection, b []byte) {
+	p.shard(conn.uid()).chUp <- &upFrame{ctx: ctx, conn: conn, data: b}
 }
 
 // RegisterConnection registers a custom connection with the hub.
@@ -353,6 +448,7 @@ func (p *Hub) receive(dv *Device, b []byte) {
 // by implementing the ConAdapter interface.
 //
 // Parameters:
+//   - ctx: Parent of the connection context, handler contexts are derived from it, see DeviceFromContext
 //   - dv: The authenticated device information
 //   - adapter: The connection adapter implementing the ConAdapter interface
 //
@@ -365,58 +461,48 @@ func (p *Hub) receive(dv *Device, b []byte) {
 //	device := tok.CreateDevice("user123", "session456")
 //	hub.RegisterConnection(device, adapter)
 func (p *Hub) RegisterConnection(ctx context.Context, dv *Device, adapter ConAdapter) {
+	conn := p.register(ctx, dv, adapter)
+
+	// block on read
+	conn.readLoop()
+}
+
+// register brings connection online without reading from it, reader must call connection.received for inbound messages
+func (p *Hub) register(ctx context.Context, dv *Device, adapter ConAdapter) *connection {
 	// create context for this connection
-	connCtx, cancel := context.WithCancel(ctx)
+	connCtx, cancel := context.WithCancel(context.WithValue(ctx, ctxKeyDevice, dv))
 
 	conn := &connection{
-		dv:         dv,
-		adapter:    adapter,
-		hub:        p,
-		cancelFunc: cancel,
+		dv:          dv,
+		adapter:     adapter,
+		hub:         p,
+		ctx:         connCtx,
+		cancelFunc:  cancel,
+		connectedAt: time.Now(),
+		transport:   transportOf(adapter),
+	}
+	conn.lastRead.Store(conn.connectedAt.UnixNano())
+	if p.config.writeQueueSize > 0 {
+		conn.outq = make(chan *outFrame, p.config.writeQueueSize)
+		go conn.writeLoop(connCtx)
 	}
 
 	// change conn state to online
 	p.stateChange(conn, true)
 
-	// start server ping loop if necessary
-	if p.config.pingProducer != nil {
-		ticker := time.NewTicker(p.config.serverPingInterval)
-		go func() {
-			defer ticker.Stop()
-			for {
-				select {
-				case <-connCtx.Done():
-					return
-				case <-ticker.C:
-					// Use the optional BeforeSend function if provided
-					// Get fresh ping data for each iteration to ensure the current state of the connection
-					pingData := p.config.pingProducer.Ping()
-					data, err := p.beforeSend(dv, pingData)
-					if err != nil {
-						slog.Warn("[tok] before send ping failed", "err", err)
-						continue
-					}
-					if err := conn.Write(data); err != nil {
-						slog.Warn("[tok] write ping failed", "err", err)
-						// write failed, connection might be closed, exit ping loop
-						return
-					}
-				}
-			}
-		}()
-	}
-
-	// block on read
-	conn.readLoop()
+	// start server ping and idle check if necessary
+	p.startKeepalive(connCtx, conn)
+	return conn
 }
 
 // beforeSend preprocess outgoing data before sending it.
-func (p *Hub) beforeSend(dv *Device, data []byte) ([]byte, error) {
+func (p *Hub) beforeSend(ctx context.Context, conn *connection, data []byte) (b []byte, err error) {
 	hdl := p.config.hdlBeforeSend
 	if hdl == nil {
 		return data, nil
 	}
-	return hdl.BeforeSend(dv, data)
+	defer p.recoverHook("BeforeSend", conn, &err)
+	return hdl.BeforeSend(ctx, conn.dv, data)
 }
 
 func connExclude(l []*connection, ex *connection) []*connection {
@@ -2,24 +2,39 @@ package tok
 
 import (
 	"log"
+	"runtime"
 	"time"
 )
 
 // HubConfig config struct for creating new Hub
 type HubConfig struct {
-	actor              Actor                // actor implement dispatch logic
-	pingProducer       PingGenerator        // optional server-side ping generator for auto-ping feature
-	byeGenerator       ByeGenerator         // optional bye generator for connection close notifications
-	hdlBeforeReceive   BeforeReceiveHandler // optional preprocessing handler for incoming data
-	hdlBeforeSend      BeforeSendHandler    // optional preprocessing handler for outgoing data
-	hdlAfterSend       AfterSendHandler     // optional AfterSend handler
-	closeHandler       CloseHandler         // optional CloseHandler for connection close events
-	q                  Queue                // Message Queue, default is memory-based queue. if nil, message to offline user will not be cached
-	sso                bool                 // Default true, if it's true, new connection  with same uid will kick off old ones
-	serverPingInterval time.Duration        // Server ping interval, default 30 seconds
-	authTimeout        time.Duration        // Auth timeout duration, default 5s
-	writeTimeout       time.Duration        // Write timeout duration, default 1m
-	readTimeout        time.Duration        // Read timeout duration, default 0s, means no read timeout
+	actor              ActorWithResult             // actor implement dispatch logic
+	pingProducer       PingGenerator               // optional server-side ping generator for auto-ping feature
+	byeGenerator       ByeGenerator                // optional bye generator for connection close notifications
+	hdlBeforeReceive   ContextBeforeReceiveHandler // optional preprocessing handler for incoming data
+	hdlBeforeSend      ContextBeforeSendHandler    // optional preprocessing handler for outgoing data
+	hdlAfterSend       ContextAfterSendHandler     // optional AfterSend handler
+	closeHandler       ContextCloseHandler         // optional CloseHandler for connection close events
+	q                  Queue                       // Message Queue, default is memory-based queue. if nil, message to offline user will not be cached
+	sso                bool                        // Default true, if it's true, new connection  with same uid will kick off old ones
+	serverPingInterval time.Duration               // Server ping interval, default 30 seconds
+	authTimeout        time.Duration               // Auth timeout duration, default 5s
+	writeTimeout       time.Duration               // Write timeout duration, default 1m
+	readTimeout        time.Duration               // Read timeout duration, default 0s, means no read timeout
+	controlPing        bool                        // Use protocol-level ping for adapters implementing ControlPinger, default false
+	maxMissedPongs     int                         // Close connection after missing this many pongs in a row, 0 means never
+	idleTimeout        time.Duration               // Close connection silent for longer than this, default 0s, means no idle check
+	writeQueueSize     int                         // Size of per-connection write queue, default 0, means write synchronously
+	slowConsumerPolicy SlowConsumerPolicy          // What to do while write queue is full, default SlowConsumerDisconnect
+	shards             int                         // Number of hub event loops, connections are sharded by uid hash, default 1
+	errorPolicy        ErrorPolicy                 // What to do with receive errors, default nil, means errors are logged only
+	maxStrikes         int                         // Close connection after this many receive errors not ignored by errorPolicy, 0 means never
+	panicHandler       PanicHandler                // optional handler of panics recovered from user hooks, default nil, means panics are logged only
+	closeOnPanic       bool                        // Close connection whose hook panicked, default false
+	requestCodec       RequestCodec                // optional framing of correlated messages, needed by Hub.Request and Reply
+	lastSeen           LastSeenStore               // optional store of last seen time, needed by Hub.LastSeen
+	lastSeenInterval   time.Duration               // Record last seen on inbound message at most once per interval per connection, 0 means never
+	sendContext        bool                        // Outbound message carries handler context, true if context-aware BeforeSend or AfterSend handler is set
 }
 
 // NewHubConfig create new HubConfig
@@ -27,6 +42,22 @@ func NewHubConfig(actor Actor, opts ...HubConfigOption) *HubConfig {
 	if actor == nil {
 		log.Fatal("fatal: actor is needed")
 	}
+	return NewHubConfigContext(AdaptActor(actor), opts...)
+}
+
+// NewHubConfigContext create new HubConfig with context-aware actor
+func NewHubConfigContext(actor ContextActor, opts ...HubConfigOption) *HubConfig {
+	if actor == nil {
+		log.Fatal("fatal: actor is needed")
+	}
+	return NewHubConfigWithResult(AdaptContextActor(actor), opts...)
+}
+
+// NewHubConfigWithResult create new HubConfig with actor reporting errors, see WithHubConfigErrorPolicy
+func NewHubConfigWithResult(actor ActorWithResult, opts ...HubConfigOption) *HubConfig {
+	if actor == nil {
+		log.Fatal("fatal: actor is needed")
+	}
 
 	hc := &HubConfig{
 		actor:              actor,
@@ -36,11 +67,13 @@ func NewHubConfig(actor Actor, opts ...HubConfigOption) *HubConfig {
 		authTimeout:        5 * time.Second,  // default
 		writeTimeout:       time.Minute,      // default
 		readTimeout:        0,
+		shards:             1, // default
 	}
 
 	for _, opt := range opts {
 		opt(hc)
 	}
+	hc.sendContext = sendContextAware(hc.hdlBeforeSend, hc.hdlAfterSend)
 
 	return hc
 }
@@ -89,8 +122,83 @@ func WithHubConfigReadTimeout(timeout time.Duration) HubConfigOption {
 	}
 }
 
+// WithHubConfigIdleTimeout set idle timeout for hub config, default is 0 seconds, means no idle check.
+// A connection is closed if nothing (message or pong) has been received from peer for longer than timeout.
+// Unlike read timeout, it's checked in background rather than on each read, and survives protocol-level pongs.
+// It's enough to close dead peers without read timeout and server ping.
+func WithHubConfigIdleTimeout(timeout time.Duration) HubConfigOption {
+	return func(hc *HubConfig) {
+		hc.idleTimeout = timeout
+	}
+}
+
+// WithHubConfigWriteQueue enable asynchronous write with a bounded queue of size per connection, drained by a writer goroutine.
+// So a slow connection never blocks sending to others, policy decides what happens while the queue is full.
+// Default size is 0, means writing synchronously in Send.
+func WithHubConfigWriteQueue(size int, policy SlowConsumerPolicy) HubConfigOption {
+	return func(hc *HubConfig) {
+		hc.writeQueueSize = size
+		hc.slowConsumerPolicy = policy
+	}
+}
+
+// WithHubConfigShards set number of hub event loops, default is 1.
+// Connections are sharded by uid hash, each shard has its own loop, so Send, CheckOnline, Kick and state changes
+// of different uids run in parallel. Messages of one uid stay in one shard. n < 1 means runtime.GOMAXPROCS(0).
+func WithHubConfigShards(n int) HubConfigOption {
+	return func(hc *HubConfig) {
+		if n < 1 {
+			n = runtime.GOMAXPROCS(0)
+		}
+		hc.shards = n
+	}
+}
+
+// WithHubConfigErrorPolicy set policy for errors returned by BeforeReceive handler and ActorWithResult.
+// Each error not ignored by policy is a strike, connection is closed with ByeReasonStrikes after maxStrikes strikes,
+// 0 means never close for strikes. Without policy, errors are logged only.
+func WithHubConfigErrorPolicy(policy ErrorPolicy, maxStrikes int) HubConfigOption {
+	return func(hc *HubConfig) {
+		hc.errorPolicy = policy
+		hc.maxStrikes = maxStrikes
+	}
+}
+
+// WithHubConfigPanicHandler set handler of panics recovered from user hooks, nil means panics are logged only.
+// If closeConn is true, connection whose hook panicked is closed.
+func WithHubConfigPanicHandler(hdl PanicHandler, closeConn bool) HubConfigOption {
+	return func(hc *HubConfig) {
+		hc.panicHandler = hdl
+		hc.closeOnPanic = closeConn
+	}
+}
+
+// WithHubConfigRequestCodec enables request/response over connections, see Hub.Request and Reply.
+// Incoming data is decoded by codec, replies go to pending requests, requests and plain messages go to actor.
+func WithHubConfigRequestCodec(codec RequestCodec) HubConfigOption {
+	return func(hc *HubConfig) {
+		hc.requestCodec = codec
+	}
+}
+
+// WithHubConfigLastSeen set store of last seen time, see Hub.LastSeen. It's recorded while uid goes offline.
+// If interval > 0, it's also recorded on inbound message, at most once per interval per connection, so chatty clients don't hammer the store.
+func WithHubConfigLastSeen(store LastSeenStore, interval time.Duration) HubConfigOption {
+	return func(hc *HubConfig) {
+		hc.lastSeen = store
+		hc.lastSeenInterval = interval
+	}
+}
+
 // WithHubConfigBeforeReceive set optional BeforeReceive handler for hub config.
 func WithHubConfigBeforeReceive(hdl BeforeReceiveHandler) HubConfigOption {
+	return func(hc *HubConfig) {
+		hc.hdlBeforeReceive = AdaptBeforeReceiveHandler(hdl)
+	}
+}
+
+// WithHubConfigContextBeforeReceive set optional context-aware BeforeReceive handler for hub config.
+func WithHubConfigContextBeforeReceive(hdl ContextBeforeReceiveHandler) HubConfigOption {
 	return func(hc *HubConfig) {
 		hc.hdlBeforeReceive = hdl
 	}
@@ -98,6 +206,13 @@ func WithHubConfigBeforeReceive(hdl BeforeReceiveHandler) HubConfigOption {
 
 // WithHubConfigBeforeSend set optional BeforeSend handler for hub config.
 func WithHubConfigBeforeSend(beforeSend BeforeSendHandler) HubConfigOption {
+	return func(hc *HubConfig) {
+		hc.hdlBeforeSend = AdaptBeforeSendHandler(beforeSend)
+	}
+}
+
+// WithHubConfigContextBeforeSend set optional context-aware BeforeSend handler for hub config.
+func WithHubConfigContextBeforeSend(beforeSend ContextBeforeSendHandler) HubConfigOption {
 	return func(hc *HubConfig) {
 		hc.hdlBeforeSend = beforeSend
 	}
@@ -105,6 +220,13 @@ func WithHubConfigBeforeSend(beforeSend BeforeSendHandler) HubConfigOption {
 
 // WithHubConfigAfterSend set optional AfterSend handler for hub config.
 func WithHubConfigAfterSend(afterSend AfterSendHandler) HubConfigOption {
+	return func(hc *HubConfig) {
+		hc.hdlAfterSend = AdaptAfterSendHandler(afterSend)
+	}
+}
+
+// WithHubConfigContextAfterSend set optional context-aware AfterSend handler for hub config.
+func WithHubConfigContextAfterSend(afterSend ContextAfterSendHandler) HubConfigOption {
 	return func(hc *HubConfig) {
 		hc.hdlAfterSend = afterSend
 	}
@@ -112,6 +234,13 @@ func WithHubConfigAfterSend(afterSend AfterSendHandler) HubConfigOption {
 
 // WithHubConfigCloseHandler set optional CloseHandler for hub config.
 func WithHubConfigCloseHandler(closeHandler CloseHandler) HubConfigOption {
+	return func(hc *HubConfig) {
+		hc.closeHandler = AdaptCloseHandler(closeHandler)
+	}
+}
+
+// WithHubConfigContextCloseHandler set optional context-aware CloseHandler for hub config.
+func WithHubConfigContextCloseHandler(closeHandler ContextCloseHandler) HubConfigOption {
 	return func(hc *HubConfig) {
 		hc.closeHandler = closeHandler
 	}
@@ -132,3 +261,16 @@ func WithHubConfigByeGenerator(byeGenerator ByeGenerator) HubConfigOption {
 		hc.byeGenerator = byeGenerator
 	}
 }
+
+// WithHubConfigControlPing enable protocol-level ping (e.g. websocket ping control frame) for server keepalive.
+// It applies to adapters implementing ControlPinger, which are the built-in websocket adapters, and takes the place of PingGenerator for them.
+// Other adapters, e.g. tcp, still send PingGenerator payload, so PingGenerator is required unless read timeout is set.
+// A connection missing maxMissedPongs pongs in a row is closed, 0 means never close for missing pongs.
+// Pongs are tracked only for adapters implementing PongTracker. golang.org/x/net/websocket discards pongs, so WsEngineX is ping-only:
+// it's never closed for missing pongs, use read timeout or idle timeout to close dead peers of it.
+func WithHubConfigControlPing(maxMissedPongs int) HubConfigOption {
+	return func(hc *HubConfig) {
+		hc.controlPing = true
+		hc.maxMissedPongs = maxMissedPongs
+	}
+}
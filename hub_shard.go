@@ -0,0 +1,195 @@
+/**
+ * hub shard, event loop owning connections of a part of uids
+ */
+
+package tok
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// hubShard owns connections of uids hashed to it. All its state is touched in its own loop only,
+// shards of a hub run in parallel.
+type hubShard struct {
+	hub           *Hub
+	cons          map[interface{}][]*connection // connection list
+	chUp          chan *upFrame
+	chDown        chan *downFrame
+	chConState    chan *conState
+	chReadSignal  chan interface{}
+	chKick        chan interface{}
+	chQueryOnline chan chan []interface{}
+	chCheck       chan *checkFrame
+	chFind        chan *findFrame
+	chConns       chan *connsFrame
+	chCount       chan chan onlineCount
+}
+
+func newHubShard(hub *Hub) *hubShard {
+	return &hubShard{
+		hub:           hub,
+		cons:          make(map[interface{}][]*connection),
+		chUp:          make(chan *upFrame),
+		chDown:        make(chan *downFrame),
+		chConState:    make(chan *conState),
+		chReadSignal:  make(chan interface{}),
+		chKick:        make(chan interface{}),
+		chQueryOnline: make(chan chan []interface{}),
+		chCheck:       make(chan *checkFrame),
+		chFind:        make(chan *findFrame),
+		chConns:       make(chan *connsFrame),
+		chCount:       make(chan chan onlineCount),
+	}
+}
+
+func (p *hubShard) run() {
+	for {
+
+		select {
+		case state := <-p.chConState:
+			slog.Debug("connection state change", "online", state.online, "con", &state.con)
+
+			before := len(p.cons)
+			if state.online {
+				p.goOnline(state.con)
+			} else {
+				p.goOffline(state.con)
+			}
+			expOnline.Add(int64(len(p.cons) - before))
+		case f := <-p.chUp:
+			slog.Debug("up data")
+			expUp.Add(1)
+			go p.hub.dispatch(f)
+		case ff := <-p.chDown:
+			if l := p.cons[ff.uid]; len(l) > 0 {
+				// online
+				go p.hub.down(ff, l)
+			} else {
+				// offline
+				if ff.ttl == 0 {
+					ff.chErr <- ErrOffline
+					close(ff.chErr)
+				} else {
+					go p.hub.cache(ff.ctx, ff)
+				}
+			}
+		case cf := <-p.chCheck:
+			_, ok := p.cons[cf.uid]
+			cf.chBool <- ok
+			close(cf.chBool)
+		case ff := <-p.chFind:
+			ff.chConn <- p.find(ff.uid, ff.id)
+			close(ff.chConn)
+		case cf := <-p.chConns:
+			cf.chConns <- p.conns(cf)
+			close(cf.chConns)
+		case chCount := <-p.chCount:
+			chCount <- p.count()
+			close(chCount)
+		case uid := <-p.chReadSignal:
+			// only pop msg for online user
+			if len(p.cons[uid]) > 0 {
+				go p.hub.popMsg(context.Background(), uid)
+			}
+		case uid := <-p.chKick:
+			before := len(p.cons)
+			p.innerKick(uid)
+			expOnline.Add(int64(len(p.cons) - before))
+		case chOnline := <-p.chQueryOnline:
+			result := make([]interface{}, 0, len(p.cons))
+			for uid := range p.cons {
+				result = append(result, uid)
+			}
+			chOnline <- result
+			close(chOnline)
+		}
+	}
+}
+
+func (p *hubShard) goOffline(conn *connection) {
+	l := p.cons[conn.uid()]
+	rest := connExclude(l, conn)
+
+	// this connection has gotten offline, ignore
+	if len(l) == len(rest) {
+		return
+	}
+
+	if len(rest) == 0 {
+		delete(p.cons, conn.uid())
+	} else {
+		p.cons[conn.uid()] = rest
+	}
+
+	go p.hub.close(conn)
+
+	p.hub.emit(EventDisconnected, conn, conn.offlineReason, nil)
+	if len(rest) == 0 {
+		p.hub.emit(EventOffline, conn, conn.offlineReason, nil)
+		go p.hub.saveLastSeen(conn, time.Now())
+	}
+}
+
+func (p *hubShard) innerKick(uid interface{}) {
+	l := p.cons[uid]
+	for _, conn := range l {
+		go p.hub.close(conn)
+	}
+	delete(p.cons, uid)
+
+	for _, conn := range l {
+		p.hub.emit(EventDisconnected, conn, DisconnectReasonKicked, nil)
+	}
+	if len(l) > 0 {
+		p.hub.emit(EventOffline, l[len(l)-1], DisconnectReasonKicked, nil)
+		go p.hub.saveLastSeen(l[len(l)-1], time.Now())
+	}
+}
+
+func (p *hubShard) goOnline(conn *connection) {
+	defer func() {
+		go p.hub.tryDeliver(context.Background(), conn.uid())
+	}()
+
+	l := p.cons[conn.uid()]
+	if l == nil {
+		p.cons[conn.uid()] = []*connection{conn}
+		p.hub.emit(EventConnected, conn, "", nil)
+		p.hub.emit(EventOnline, conn, "", nil)
+		return
+	}
+
+	if p.hub.config.sso {
+		for _, c := range l {
+			if conn.ShareConn(c) {
+				continue // never close share connection
+			}
+			// notify before close connection
+			go p.hub.byeThenClose(conn.dv, ByeReasonSSO, c)
+			p.hub.emit(EventKicked, c, ByeReasonSSO, conn.dv)
+			p.hub.emit(EventDisconnected, c, ByeReasonSSO, nil)
+		}
+		p.cons[conn.uid()] = []*connection{conn}
+		p.hub.emit(EventConnected, conn, "", nil)
+		return
+	}
+
+	// it's a new connection
+	if len(connExclude(l, conn)) == len(l) {
+		l = append(l, conn)
+		p.cons[conn.uid()] = l
+		p.hub.emit(EventConnected, conn, "", nil)
+	}
+}
+
+// find returns connection of device id of uid, nil if not found
+func (p *hubShard) find(uid interface{}, id string) *connection {
+	for _, conn := range p.cons[uid] {
+		if conn.dv.ID() == id {
+			return conn
+		}
+	}
+	return nil
+}
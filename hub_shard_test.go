@@ -0,0 +1,101 @@
+package tok_test
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/mocks"
+)
+
+var _ = Describe("Shards", func() {
+	const n = 100
+	var hub *tok.Hub
+
+	BeforeEach(func() {
+		config := tok.NewHubConfig(mocks.NewMockActor(ctl),
+			tok.WithHubConfigQueue(nil),
+			// first pings are spread across the interval, some of 100 connections might ping during test
+			tok.WithHubConfigPingProducer(benchPingGen{}),
+			tok.WithHubConfigShards(8))
+		hub, _ = tok.CreateWsHandler(nil, tok.WithWsHandlerHubConfig(config))
+
+		for i := 0; i < n; i++ {
+			adapter := &benchAdapter{chClosed: make(chan struct{})}
+			go hub.RegisterConnection(ctx, tok.CreateDevice(i, ""), adapter)
+		}
+		Eventually(func() []interface{} { return hub.Online(ctx) }).Should(HaveLen(n))
+	})
+
+	AfterEach(func() {
+		for i := 0; i < n; i++ {
+			hub.Kick(ctx, i)
+		}
+	})
+
+	It("should route uids to their shards", func() {
+		for i := 0; i < n; i++ {
+			Ω(hub.CheckOnline(ctx, i)).To(BeTrue())
+			Ω(hub.Send(ctx, i, []byte("hi"), 0)).To(Succeed())
+		}
+		Ω(hub.CheckOnline(ctx, n)).To(BeFalse())
+		Ω(hub.Send(ctx, n, []byte("hi"), 0)).To(MatchError(tok.ErrOffline))
+	})
+
+	It("should merge online list of all shards", func() {
+		for i := 0; i < n; i += 2 {
+			hub.Kick(ctx, i)
+		}
+		Eventually(func() []interface{} { return hub.Online(ctx) }).Should(HaveLen(n / 2))
+		Ω(hub.Online(ctx)).To(ContainElements(1, 3, n-1))
+		Ω(hub.Online(ctx)).NotTo(ContainElement(0))
+	})
+})
+
+// benchSend sends to 10k online uids from all cpus
+func benchSend(b *testing.B, shards int) {
+	const uids = 10_000
+	config := tok.NewHubConfig(benchActor{},
+		tok.WithHubConfigQueue(nil),
+		tok.WithHubConfigPingProducer(benchPingGen{}),
+		tok.WithHubConfigShards(shards))
+	hub, _ := tok.CreateWsHandler(nil, tok.WithWsHandlerHubConfig(config))
+
+	ctx := context.Background()
+	for n := 0; n < uids; n++ {
+		go hub.RegisterConnection(ctx, tok.CreateDevice(n, ""), &benchAdapter{chClosed: make(chan struct{})})
+	}
+	for len(hub.Online(ctx)) < uids {
+		runtime.Gosched()
+	}
+	defer func() {
+		for n := 0; n < uids; n++ {
+			hub.Kick(ctx, n)
+		}
+	}()
+
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := hub.Send(ctx, int(next.Add(1)%uids), []byte("hi"), 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkSend compares Send throughput of single event loop and loops sharded per cpu
+func BenchmarkSend(b *testing.B) {
+	for _, shards := range []int{1, runtime.GOMAXPROCS(0)} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			benchSend(b, shards)
+		})
+	}
+}
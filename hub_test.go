@@ -111,6 +111,27 @@ var _ = Describe("Hub", func() {
 			err := hub.Send(ctx, "offline-user", []byte("failed message"), 300)
 			Expect(err).To(HaveOccurred()) // Send returns nil even if queue fails
 		})
+
+		It("should pass caller deadline to queue", func() {
+			deadline := time.Now().Add(time.Minute)
+			mockQueue.EXPECT().Enq(gomock.Any(), "offline-user", []byte("queued message"), gomock.Any()).
+				DoAndReturn(func(ctx context.Context, _ interface{}, _ []byte, _ ...uint32) error {
+					d, ok := ctx.Deadline()
+					Expect(ok).To(BeTrue())
+					Expect(d).To(Equal(deadline))
+					return nil
+				})
+
+			dctx, cancel := context.WithDeadline(ctx, deadline)
+			defer cancel()
+			Expect(hub.Send(dctx, "offline-user", []byte("queued message"), 300)).To(Succeed())
+		})
+
+		It("should return ctx error if ctx is done", func() {
+			cctx, cancel := context.WithCancel(ctx)
+			cancel()
+			Expect(hub.Send(cctx, "offline-user", []byte("test message"), 0)).To(MatchError(context.Canceled))
+		})
 	})
 
 	Describe("CheckOnline", func() {
@@ -206,6 +227,30 @@ var _ = Describe("Hub", func() {
 		It("should handle kicking offline device gracefully", func() {
 			// No error should occur when kicking offline device
 			hub.Kick(ctx, "offline-user")
+			Expect(hub.KickDevice(ctx, "offline-user", "dv-id")).To(MatchError(tok.ErrOffline))
+			Expect(hub.SendDevice(ctx, "offline-user", "dv-id", []byte("hi"))).To(MatchError(tok.ErrOffline))
+		})
+	})
+
+	Describe("Queue", func() {
+		It("should purge cached messages", func() {
+			gomock.InOrder(
+				mockQueue.EXPECT().Deq(gomock.Any(), "offline-user").Return([]byte("m1"), nil),
+				mockQueue.EXPECT().Deq(gomock.Any(), "offline-user").Return([]byte("m2"), nil),
+				mockQueue.EXPECT().Deq(gomock.Any(), "offline-user").Return(nil, nil),
+				mockQueue.EXPECT().Len(gomock.Any(), "offline-user").Return(0, nil),
+			)
+			Expect(hub.PurgeQueue(ctx, "offline-user")).To(Equal(2))
+			Expect(hub.QueueLen(ctx, "offline-user")).To(BeZero())
+		})
+
+		It("should fail without queue", func() {
+			hubConfig = tok.NewHubConfig(mockActor, tok.WithHubConfigQueue(nil), tok.WithHubConfigPingProducer(mockPingGen))
+			hub, _ = tok.CreateWsHandler(nil, tok.WithWsHandlerHubConfig(hubConfig))
+			_, err := hub.QueueLen(ctx, "offline-user")
+			Expect(err).To(MatchError(tok.ErrQueueRequired))
+			_, err = hub.PurgeQueue(ctx, "offline-user")
+			Expect(err).To(MatchError(tok.ErrQueueRequired))
 		})
 	})
 
@@ -0,0 +1,53 @@
+// Package httpapi provides helpers shared by HTTP handlers of tok packages.
+package httpapi
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// BearerToken returns token of header "Authorization: Bearer <token>", ok is false if there is none
+func BearerToken(r *http.Request) (token string, ok bool) {
+	token, ok = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token, ok && token != ""
+}
+
+// TokenEqual compares tokens in constant time, empty token never matches
+func TokenEqual(got, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// StringUID is default uid parser, it keeps uid as string
+func StringUID(s string) (interface{}, error) {
+	return s, nil
+}
+
+// ParseUID parses uid of request with parse, empty uid is error
+func ParseUID(parse func(s string) (interface{}, error), s string) (interface{}, error) {
+	if s == "" {
+		return nil, errors.New("uid is required")
+	}
+	uid, err := parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("bad uid %q: %w", s, err)
+	}
+	return uid, nil
+}
+
+// WriteJSON answers v as JSON with status
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// WriteBody answers JSON body with status
+func WriteBody(w http.ResponseWriter, status int, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,52 @@
+// Package testutil provides fixtures shared by tests of tok packages.
+package testutil
+
+import (
+	"io"
+
+	"github.com/quexer/tok"
+)
+
+// FakeAdapter is tok.ConAdapter backed by channels.
+// Read returns messages sent to ChRead, written messages are sent to ChWritten, ChClosed is closed by Close.
+type FakeAdapter struct {
+	ChRead    chan []byte
+	ChWritten chan []byte
+	ChClosed  chan struct{}
+}
+
+// NewFakeAdapter create FakeAdapter, ChWritten buffers 10 messages
+func NewFakeAdapter() *FakeAdapter {
+	return &FakeAdapter{ChRead: make(chan []byte), ChWritten: make(chan []byte, 10), ChClosed: make(chan struct{})}
+}
+
+// Read implements tok.ConAdapter, io.EOF is returned after Close
+func (p *FakeAdapter) Read() ([]byte, error) {
+	select {
+	case b := <-p.ChRead:
+		return b, nil
+	case <-p.ChClosed:
+		return nil, io.EOF
+	}
+}
+
+// Write implements tok.ConAdapter
+func (p *FakeAdapter) Write(b []byte) error {
+	p.ChWritten <- b
+	return nil
+}
+
+// Close implements tok.ConAdapter, it's safe to call more than once
+func (p *FakeAdapter) Close() error {
+	select {
+	case <-p.ChClosed:
+	default:
+		close(p.ChClosed)
+	}
+	return nil
+}
+
+// ShareConn implements tok.ConAdapter
+func (p *FakeAdapter) ShareConn(adapter tok.ConAdapter) bool {
+	return p == adapter
+}
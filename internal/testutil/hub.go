@@ -0,0 +1,38 @@
+package testutil
+
+import (
+	"context"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/mocks"
+)
+
+// NewHub create hub of mock actor, see NewHubWithActor
+func NewHub(ctl *gomock.Controller, opts ...tok.HubConfigOption) *tok.Hub {
+	return NewHubWithActor(ctl, mocks.NewMockActorWithResult(ctl), opts...)
+}
+
+// NewHubWithActor create hub of actor with mock ping producer, without queue and sso, opts are applied after them
+func NewHubWithActor(ctl *gomock.Controller, actor tok.ActorWithResult, opts ...tok.HubConfigOption) *tok.Hub {
+	opts = append([]tok.HubConfigOption{
+		tok.WithHubConfigPingProducer(mocks.NewMockPingGenerator(ctl)),
+		tok.WithHubConfigQueue(nil),
+		tok.WithHubConfigSso(false),
+	}, opts...)
+	hub, _ := tok.CreateWsHandler(nil, tok.WithWsHandlerHubConfig(tok.NewHubConfigWithResult(actor, opts...)))
+	return hub
+}
+
+// Connect registers FakeAdapter of dv to hub, and waits until it's online. Adapter is closed while spec ends.
+func Connect(ctx context.Context, hub *tok.Hub, dv *tok.Device) *FakeAdapter {
+	adapter := NewFakeAdapter()
+	ginkgo.DeferCleanup(adapter.Close)
+	go hub.RegisterConnection(ctx, dv, adapter)
+	gomega.Eventually(func() []tok.ConnInfo { return hub.Devices(ctx, dv.UID()) }).
+		Should(gomega.ContainElement(gomega.HaveField("DeviceID", dv.ID())))
+	return adapter
+}
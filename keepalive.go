@@ -0,0 +1,157 @@
+package tok
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+)
+
+// keepalive pings connection and checks its idleness periodically.
+// It's driven by the timing wheel shared by all connections of hub, instead of a goroutine per connection.
+type keepalive struct {
+	hub       *Hub
+	conn      *connection
+	ctx       context.Context // connection context, done after connection closed
+	pinger    ControlPinger   // protocol-level pinger, nil if it's disabled or unsupported by adapter
+	tracker   PongTracker     // pong tracker, nil if it's unsupported by adapter
+	lastPing  time.Time       // when last control ping was sent
+	missed    int             // pongs missed in a row
+	pingTimer *wheelTimer
+	idleTimer *wheelTimer
+}
+
+// startKeepalive starts server ping and idle check for conn if necessary, until ctx is done.
+// Protocol-level ping is preferred if it's enabled and supported by adapter, otherwise PingGenerator payload is sent.
+func (p *Hub) startKeepalive(ctx context.Context, conn *connection) {
+	k := &keepalive{
+		hub:  p,
+		conn: conn,
+		ctx:  ctx,
+	}
+	if p.config.controlPing {
+		k.pinger, _ = conn.adapter.(ControlPinger)
+		k.tracker, _ = conn.adapter.(PongTracker)
+	}
+
+	if k.pinger != nil || p.config.pingProducer != nil {
+		k.pingTimer = p.wheel.newTimer(k.onPing)
+		// spread first ping across the second half of interval to avoid thundering herd,
+		// a connection is never pinged sooner than half interval after connected
+		interval := p.config.serverPingInterval
+		p.wheel.reset(k.pingTimer, interval/2+rand.N(interval-interval/2)+1)
+	}
+
+	// close connection silent for too long
+	if p.config.idleTimeout > 0 {
+		k.idleTimer = p.wheel.newTimer(k.onIdleCheck)
+		p.wheel.reset(k.idleTimer, p.config.idleTimeout)
+	}
+
+	if k.pingTimer != nil || k.idleTimer != nil {
+		context.AfterFunc(ctx, k.stop)
+	}
+}
+
+func (k *keepalive) stop() {
+	if k.pingTimer != nil {
+		k.hub.wheel.stop(k.pingTimer)
+	}
+	if k.idleTimer != nil {
+		k.hub.wheel.stop(k.idleTimer)
+	}
+}
+
+// onPing runs in wheel goroutine, it hands ping over to keepalive workers as writing might block
+func (k *keepalive) onPing() {
+	if k.ctx.Err() != nil {
+		return
+	}
+	select {
+	case k.hub.chKeepalive <- k.pingThenReschedule:
+	default:
+		// all workers are busy, e.g. blocked by slow peers
+		go k.pingThenReschedule()
+	}
+}
+
+func (k *keepalive) pingThenReschedule() {
+	// next ping is scheduled after this one finished, so pings never overlap
+	if k.ping() && k.ctx.Err() == nil {
+		k.hub.wheel.reset(k.pingTimer, k.hub.config.serverPingInterval)
+	}
+}
+
+// keepaliveWorker runs keepalive jobs handed over by timing wheel
+func (p *Hub) keepaliveWorker() {
+	for job := range p.chKeepalive {
+		job()
+	}
+}
+
+// pingData generates server ping payload
+func (p *Hub) pingData(conn *connection) (b []byte, err error) {
+	defer p.recoverHook("Ping", conn, &err)
+	return p.config.pingProducer.Ping(), nil
+}
+
+// ping sends server ping, it returns false if connection is broken
+func (k *keepalive) ping() bool {
+	p, conn := k.hub, k.conn
+	if k.pinger == nil {
+		// Use the optional BeforeSend function if provided
+		// Get fresh ping data for each iteration to ensure the current state of the connection
+		pingData, err := p.pingData(conn)
+		if err != nil {
+			return true
+		}
+		data, err := p.beforeSend(conn.messageContext(false, nil), conn, pingData)
+		if err != nil {
+			slog.Warn("[tok] before send ping failed", "err", err)
+			return true
+		}
+		if err := conn.Write(data); err != nil {
+			slog.Warn("[tok] write ping failed", "err", err)
+			// write failed, connection might be closed, stop ping
+			return false
+		}
+		return true
+	}
+
+	if k.tracker != nil && !k.lastPing.IsZero() {
+		if k.tracker.LastPong().Before(k.lastPing) {
+			k.missed++
+		} else {
+			k.missed = 0
+		}
+		if p.config.maxMissedPongs > 0 && k.missed >= p.config.maxMissedPongs {
+			slog.Warn("[tok] pong missed, close connection", "missed", k.missed, "uid", conn.uid())
+			conn.triggerOffline(DisconnectReasonPongMissed)
+			return false
+		}
+	}
+
+	k.lastPing = time.Now()
+	if err := conn.controlPing(k.pinger); err != nil {
+		slog.Warn("[tok] control ping failed", "err", err)
+		// ping failed, connection might be closed, stop ping
+		return false
+	}
+	return true
+}
+
+// onIdleCheck runs in wheel goroutine, it closes connection if peer is silent for longer than idle timeout
+func (k *keepalive) onIdleCheck() {
+	if k.ctx.Err() != nil {
+		return
+	}
+
+	timeout := k.hub.config.idleTimeout
+	idle := time.Since(k.conn.LastInbound())
+	if idle >= timeout {
+		slog.Warn("[tok] connection idle, close it", "idle", idle, "uid", k.conn.uid())
+		go k.conn.triggerOffline(DisconnectReasonIdle)
+		return
+	}
+	k.hub.wheel.reset(k.idleTimer, timeout-idle)
+}
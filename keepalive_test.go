@@ -0,0 +1,143 @@
+package tok_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quexer/tok"
+)
+
+const benchConns = 100_000
+
+// benchActor drops everything
+type benchActor struct{}
+
+func (benchActor) OnReceive(*tok.Device, []byte) {}
+
+// benchPingGen generates static ping payload
+type benchPingGen struct{}
+
+func (benchPingGen) Ping() []byte { return []byte("ping") }
+
+// benchAdapter is a silent connection, Read blocks until closed
+type benchAdapter struct {
+	chClosed chan struct{}
+	once     sync.Once
+}
+
+func (p *benchAdapter) Read() ([]byte, error) {
+	<-p.chClosed
+	return nil, io.EOF
+}
+
+func (p *benchAdapter) Write([]byte) error { return nil }
+
+func (p *benchAdapter) Close() error {
+	p.once.Do(func() { close(p.chClosed) })
+	return nil
+}
+
+func (p *benchAdapter) ShareConn(adapter tok.ConAdapter) bool { return p == adapter }
+
+// cpuSeconds returns cpu time spent running Go code, GC excluded. It's estimated by runtime at GC
+func cpuSeconds() float64 {
+	runtime.GC()
+	sample := []metrics.Sample{{Name: "/cpu/classes/user:cpu-seconds"}}
+	metrics.Read(sample)
+	return sample[0].Value.Float64()
+}
+
+// measure reports heap and goroutines per connection after setup of conns connections, and cpu time per second over d.
+func measure(b *testing.B, conns int, d time.Duration, setup func() (teardown func())) {
+	defer slog.SetLogLoggerLevel(slog.SetLogLoggerLevel(slog.LevelError))
+
+	var before, after runtime.MemStats
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		goroutines := runtime.NumGoroutine()
+
+		teardown := setup()
+
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(after.HeapInuse+after.StackInuse-before.HeapInuse-before.StackInuse)/float64(conns), "B/conn")
+		b.ReportMetric(float64(runtime.NumGoroutine()-goroutines)/float64(conns), "goroutines/conn")
+
+		cpu := cpuSeconds()
+		time.Sleep(d)
+		b.ReportMetric((cpuSeconds()-cpu)/d.Seconds()*1000, "cpu-ms/s")
+		teardown()
+	}
+}
+
+// BenchmarkKeepalive100k measures keepalive cost of 100k idle connections, pinged every second by hub timing wheel.
+// Each connection still costs one goroutine blocking in read loop.
+func BenchmarkKeepalive100k(b *testing.B) {
+	config := tok.NewHubConfig(benchActor{},
+		tok.WithHubConfigQueue(nil),
+		tok.WithHubConfigPingProducer(benchPingGen{}),
+		tok.WithHubConfigServerPingInterval(time.Second))
+	hub, _ := tok.CreateWsHandler(nil, tok.WithWsHandlerHubConfig(config))
+
+	measure(b, benchConns, 3*time.Second, func() func() {
+		ctx := context.Background()
+		for n := 0; n < benchConns; n++ {
+			adapter := &benchAdapter{chClosed: make(chan struct{})}
+			go hub.RegisterConnection(ctx, tok.CreateDevice(n, ""), adapter)
+		}
+		for len(hub.Online(ctx)) < benchConns {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		return func() {
+			for n := 0; n < benchConns; n++ {
+				hub.Kick(ctx, n)
+			}
+			// wait for pings in flight
+			time.Sleep(100 * time.Millisecond)
+		}
+	})
+}
+
+// BenchmarkTickerKeepalive100k is the baseline, a goroutine with its own ticker per connection,
+// beside the goroutine blocking in read loop.
+func BenchmarkTickerKeepalive100k(b *testing.B) {
+	measure(b, benchConns, 3*time.Second, func() func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		for n := 0; n < benchConns; n++ {
+			adapter := &benchAdapter{chClosed: make(chan struct{})}
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				_, _ = adapter.Read()
+			}()
+			go func() {
+				defer wg.Done()
+				ticker := time.NewTicker(time.Second)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ctx.Done():
+						_ = adapter.Close()
+						return
+					case <-ticker.C:
+						_ = adapter.Write(benchPingGen{}.Ping())
+					}
+				}
+			}()
+		}
+
+		return func() {
+			cancel()
+			wg.Wait()
+		}
+	})
+}
@@ -0,0 +1,226 @@
+/**
+ * last seen tracking of uids, and built-in stores
+ */
+
+package tok
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrLastSeenStoreRequired occurs while querying last seen without store, see WithHubConfigLastSeen
+var ErrLastSeenStoreRequired = errors.New("tok: last seen store is required")
+
+// LastSeen returns when uid was last seen, it's now if uid is online, zero time if uid has never been seen
+func (p *Hub) LastSeen(ctx context.Context, uid interface{}) (time.Time, error) {
+	store := p.config.lastSeen
+	if store == nil {
+		return time.Time{}, ErrLastSeenStoreRequired
+	}
+	if p.CheckOnline(ctx, uid) {
+		return time.Now(), nil
+	}
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return store.LastSeen(ctx, uid)
+}
+
+// touchLastSeen records last seen on inbound message of conn, at most once per interval
+func (p *Hub) touchLastSeen(conn *connection) {
+	interval := p.config.lastSeenInterval
+	if p.config.lastSeen == nil || interval <= 0 {
+		return
+	}
+	now := time.Now()
+	last := conn.lastSeenAt.Load()
+	if now.UnixNano()-last < int64(interval) || !conn.lastSeenAt.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	go p.saveLastSeen(conn, now)
+}
+
+// saveLastSeen writes last seen of uid of conn to store, errors are logged only
+func (p *Hub) saveLastSeen(conn *connection, t time.Time) {
+	store := p.config.lastSeen
+	if store == nil {
+		return
+	}
+	if err := p.setLastSeen(store, conn, t); err != nil {
+		slog.Warn("[tok] save last seen failed", "err", err, "uid", conn.uid())
+	}
+}
+
+// setLastSeen calls store, panic is reported to PanicHandler
+func (p *Hub) setLastSeen(store LastSeenStore, conn *connection, t time.Time) (err error) {
+	defer p.recoverHook("SetLastSeen", conn, &err)
+	return store.SetLastSeen(context.Background(), conn.uid(), t)
+}
+
+// MemoryLastSeenStore is LastSeenStore in memory
+type MemoryLastSeenStore struct {
+	mu   sync.RWMutex
+	seen map[interface{}]time.Time
+}
+
+// NewMemoryLastSeenStore create memory last seen store
+func NewMemoryLastSeenStore() *MemoryLastSeenStore {
+	return &MemoryLastSeenStore{seen: make(map[interface{}]time.Time)}
+}
+
+// SetLastSeen implements LastSeenStore
+func (p *MemoryLastSeenStore) SetLastSeen(_ context.Context, uid interface{}, t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t.After(p.seen[uid]) {
+		p.seen[uid] = t
+	}
+	return nil
+}
+
+// LastSeen implements LastSeenStore
+func (p *MemoryLastSeenStore) LastSeen(_ context.Context, uid interface{}) (time.Time, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.seen[uid], nil
+}
+
+// FileLastSeenStore is LastSeenStore kept in memory and flushed to a JSON file periodically.
+// uids are keyed by their fmt "%v" form, so uids of different types printed alike share the same record.
+type FileLastSeenStore struct {
+	path       string
+	mu         sync.Mutex
+	seen       map[string]time.Time
+	dirty      bool       // changed since last flush
+	flushMu    sync.Mutex // serializes writing of file
+	chStop     chan struct{}
+	chStopped  chan struct{}
+	stopOnce   sync.Once
+	flushError error // error of the final flush in Close
+}
+
+// NewFileLastSeenStore create file last seen store, records in path are loaded if it exists.
+// Changes are flushed to path every interval and on Close, interval <= 0 means 1 minute.
+func NewFileLastSeenStore(path string, interval time.Duration) (*FileLastSeenStore, error) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	p := &FileLastSeenStore{
+		path:      path,
+		seen:      make(map[string]time.Time),
+		chStop:    make(chan struct{}),
+		chStopped: make(chan struct{}),
+	}
+
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(b, &p.seen); err != nil {
+			return nil, fmt.Errorf("tok: load last seen file %s: %w", path, err)
+		}
+	}
+
+	go p.flushLoop(interval)
+	return p, nil
+}
+
+// SetLastSeen implements LastSeenStore
+func (p *FileLastSeenStore) SetLastSeen(_ context.Context, uid interface{}, t time.Time) error {
+	key := fmt.Sprint(uid)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t.After(p.seen[key]) {
+		p.seen[key] = t
+		p.dirty = true
+	}
+	return nil
+}
+
+// LastSeen implements LastSeenStore
+func (p *FileLastSeenStore) LastSeen(_ context.Context, uid interface{}) (time.Time, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.seen[fmt.Sprint(uid)], nil
+}
+
+// Flush writes changes to file, it's atomic by writing a temp file then renaming it
+func (p *FileLastSeenStore) Flush() error {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+
+	p.mu.Lock()
+	if !p.dirty {
+		p.mu.Unlock()
+		return nil
+	}
+	b, err := json.Marshal(p.seen)
+	p.dirty = false
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := p.write(b); err != nil {
+		// retry on next flush
+		p.mu.Lock()
+		p.dirty = true
+		p.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (p *FileLastSeenStore) write(b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(p.path), filepath.Base(p.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p.path)
+}
+
+func (p *FileLastSeenStore) flushLoop(interval time.Duration) {
+	defer close(p.chStopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.Flush(); err != nil {
+				slog.Warn("[tok] flush last seen failed", "err", err, "path", p.path)
+			}
+		case <-p.chStop:
+			return
+		}
+	}
+}
+
+// Close stops periodic flush and flushes changes
+func (p *FileLastSeenStore) Close() error {
+	p.stopOnce.Do(func() {
+		close(p.chStop)
+		<-p.chStopped
+		p.flushError = p.Flush()
+	})
+	return p.flushError
+}
@@ -0,0 +1,166 @@
+package tok_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/mocks"
+)
+
+// countingLastSeenStore counts writes to memory store
+type countingLastSeenStore struct {
+	*tok.MemoryLastSeenStore
+	writes atomic.Int32
+}
+
+func (p *countingLastSeenStore) SetLastSeen(ctx context.Context, uid interface{}, t time.Time) error {
+	p.writes.Add(1)
+	return p.MemoryLastSeenStore.SetLastSeen(ctx, uid, t)
+}
+
+var _ = Describe("LastSeen", func() {
+	Context("Store", func() {
+		It("should keep the latest time in memory", func() {
+			store := tok.NewMemoryLastSeenStore()
+			Ω(store.LastSeen(ctx, "u1")).To(BeZero())
+
+			t := time.Now()
+			Ω(store.SetLastSeen(ctx, "u1", t)).To(Succeed())
+			Ω(store.SetLastSeen(ctx, "u1", t.Add(-time.Minute))).To(Succeed())
+			Ω(store.LastSeen(ctx, "u1")).To(Equal(t))
+		})
+
+		It("should persist to file", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "last_seen.json")
+			store, err := tok.NewFileLastSeenStore(path, time.Hour)
+			Ω(err).To(Succeed())
+
+			t := time.Now().Truncate(time.Millisecond)
+			Ω(store.SetLastSeen(ctx, 5, t)).To(Succeed())
+			Ω(store.SetLastSeen(ctx, 5, t.Add(-time.Minute))).To(Succeed())
+			Ω(store.LastSeen(ctx, 5)).To(Equal(t))
+			Ω(path).NotTo(BeAnExistingFile())
+			Ω(store.Close()).To(Succeed())
+
+			store, err = tok.NewFileLastSeenStore(path, time.Hour)
+			Ω(err).To(Succeed())
+			defer store.Close()
+			seen, err := store.LastSeen(ctx, 5)
+			Ω(err).To(Succeed())
+			Ω(seen.Equal(t)).To(BeTrue())
+			Ω(store.LastSeen(ctx, 6)).To(BeZero())
+		})
+
+		It("should flush file periodically", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "last_seen.json")
+			store, err := tok.NewFileLastSeenStore(path, 10*time.Millisecond)
+			Ω(err).To(Succeed())
+			defer store.Close()
+
+			Ω(store.SetLastSeen(ctx, "u1", time.Now())).To(Succeed())
+			Eventually(path).Should(BeAnExistingFile())
+		})
+
+		It("should fail on corrupted file", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "last_seen.json")
+			Ω(os.WriteFile(path, []byte("{"), 0o600)).To(Succeed())
+			_, err := tok.NewFileLastSeenStore(path, time.Hour)
+			Ω(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Hub", func() {
+		var (
+			mockAdapter *mocks.MockConAdapter
+			mockActor   *mocks.MockActor
+			hub         *tok.Hub
+			store       *countingLastSeenStore
+			chRead      chan []byte
+			chClosed    chan struct{}
+		)
+
+		BeforeEach(func() {
+			mockAdapter = mocks.NewMockConAdapter(ctl)
+			mockActor = mocks.NewMockActor(ctl)
+			store = &countingLastSeenStore{MemoryLastSeenStore: tok.NewMemoryLastSeenStore()}
+
+			chRead = make(chan []byte)
+			chClosed = make(chan struct{})
+			mockAdapter.EXPECT().Read().DoAndReturn(func() ([]byte, error) {
+				select {
+				case b := <-chRead:
+					return b, nil
+				case <-chClosed:
+					return nil, io.EOF
+				}
+			}).AnyTimes()
+			mockAdapter.EXPECT().Close().DoAndReturn(func() error {
+				close(chClosed)
+				return nil
+			})
+		})
+
+		AfterEach(func() {
+			hub.Kick(ctx, "u1")
+			Eventually(chClosed).Should(BeClosed())
+		})
+
+		register := func(opts ...tok.HubConfigOption) {
+			opts = append([]tok.HubConfigOption{
+				tok.WithHubConfigPingProducer(mocks.NewMockPingGenerator(ctl)),
+				tok.WithHubConfigQueue(nil),
+			}, opts...)
+			config := tok.NewHubConfig(mockActor, opts...)
+			hub, _ = tok.CreateWsHandler(nil, tok.WithWsHandlerHubConfig(config))
+
+			go hub.RegisterConnection(ctx, tok.CreateDevice("u1", ""), mockAdapter)
+			Eventually(func() bool { return hub.CheckOnline(ctx, "u1") }).Should(BeTrue())
+		}
+
+		It("should record last seen while uid goes offline", func() {
+			register(tok.WithHubConfigLastSeen(store, 0))
+
+			seen, err := hub.LastSeen(ctx, "u1")
+			Ω(err).To(Succeed())
+			Ω(seen).To(BeTemporally("~", time.Now(), time.Second))
+			Ω(store.LastSeen(ctx, "u1")).To(BeZero())
+
+			before := time.Now()
+			hub.Kick(ctx, "u1")
+			Eventually(func() time.Time {
+				t, _ := hub.LastSeen(ctx, "u1")
+				return t
+			}).Should(BeTemporally(">=", before))
+			Ω(hub.LastSeen(ctx, "nobody")).To(BeZero())
+		})
+
+		It("should record last seen on inbound message with throttling", func() {
+			mockActor.EXPECT().OnReceive(gomock.Any(), gomock.Any()).AnyTimes()
+			register(tok.WithHubConfigLastSeen(store, time.Hour))
+
+			for i := 0; i < 5; i++ {
+				chRead <- []byte("hi")
+			}
+			Eventually(store.writes.Load).Should(BeEquivalentTo(1))
+			Consistently(store.writes.Load, "50ms").Should(BeEquivalentTo(1))
+
+			hub.Kick(ctx, "u1")
+			Eventually(store.writes.Load).Should(BeEquivalentTo(2))
+		})
+
+		It("should fail without store", func() {
+			register()
+			_, err := hub.LastSeen(ctx, "u1")
+			Ω(err).To(MatchError(tok.ErrLastSeenStoreRequired))
+		})
+	})
+})
@@ -1,16 +1,18 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/quexer/tok (interfaces: Actor,BeforeReceiveHandler,BeforeSendHandler,AfterSendHandler,CloseHandler,PingGenerator,ByeGenerator)
+// Source: github.com/quexer/tok (interfaces: Actor,BeforeReceiveHandler,BeforeSendHandler,AfterSendHandler,CloseHandler,PingGenerator,ByeGenerator,ContextActor,ContextBeforeReceiveHandler,ContextBeforeSendHandler,ContextAfterSendHandler,ContextCloseHandler,ActorWithResult,ErrorPolicy,PanicHandler,RequestCodec,LastSeenStore)
 //
 // Generated by this command:
 //
-//	mockgen -destination=mocks/tok.go -package=mocks . Actor,BeforeReceiveHandler,BeforeSendHandler,AfterSendHandler,CloseHandler,PingGenerator,ByeGenerator
+//	mockgen -destination=mocks/tok.go -package=mocks . Actor,BeforeReceiveHandler,BeforeSendHandler,AfterSendHandler,CloseHandler,PingGenerator,ByeGenerator,ContextActor,ContextBeforeReceiveHandler,ContextBeforeSendHandler,ContextAfterSendHandler,ContextCloseHandler,ActorWithResult,ErrorPolicy,PanicHandler,RequestCodec,LastSeenStore
 //
 
 // Package mocks is a generated GoMock package.
 package mocks
 
 import (
+	context "context"
 	reflect "reflect"
+	time "time"
 
 	tok "github.com/quexer/tok"
 	gomock "go.uber.org/mock/gomock"
@@ -277,3 +279,409 @@ func (mr *MockByeGeneratorMockRecorder) Bye(kicker, reason, dv any) *gomock.Call
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Bye", reflect.TypeOf((*MockByeGenerator)(nil).Bye), kicker, reason, dv)
 }
+
+// MockContextActor is a mock of ContextActor interface.
+type MockContextActor struct {
+	ctrl     *gomock.Controller
+	recorder *MockContextActorMockRecorder
+	isgomock struct{}
+}
+
+// MockContextActorMockRecorder is the mock recorder for MockContextActor.
+type MockContextActorMockRecorder struct {
+	mock *MockContextActor
+}
+
+// NewMockContextActor creates a new mock instance.
+func NewMockContextActor(ctrl *gomock.Controller) *MockContextActor {
+	mock := &MockContextActor{ctrl: ctrl}
+	mock.recorder = &MockContextActorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockContextActor) EXPECT() *MockContextActorMockRecorder {
+	return m.recorder
+}
+
+// OnReceive mocks base method.
+func (m *MockContextActor) OnReceive(ctx context.Context, dv *tok.Device, data []byte) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "OnReceive", ctx, dv, data)
+}
+
+// OnReceive indicates an expected call of OnReceive.
+func (mr *MockContextActorMockRecorder) OnReceive(ctx, dv, data any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnReceive", reflect.TypeOf((*MockContextActor)(nil).OnReceive), ctx, dv, data)
+}
+
+// MockContextBeforeReceiveHandler is a mock of ContextBeforeReceiveHandler interface.
+type MockContextBeforeReceiveHandler struct {
+	ctrl     *gomock.Controller
+	recorder *MockContextBeforeReceiveHandlerMockRecorder
+	isgomock struct{}
+}
+
+// MockContextBeforeReceiveHandlerMockRecorder is the mock recorder for MockContextBeforeReceiveHandler.
+type MockContextBeforeReceiveHandlerMockRecorder struct {
+	mock *MockContextBeforeReceiveHandler
+}
+
+// NewMockContextBeforeReceiveHandler creates a new mock instance.
+func NewMockContextBeforeReceiveHandler(ctrl *gomock.Controller) *MockContextBeforeReceiveHandler {
+	mock := &MockContextBeforeReceiveHandler{ctrl: ctrl}
+	mock.recorder = &MockContextBeforeReceiveHandlerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockContextBeforeReceiveHandler) EXPECT() *MockContextBeforeReceiveHandlerMockRecorder {
+	return m.recorder
+}
+
+// BeforeReceive mocks base method.
+func (m *MockContextBeforeReceiveHandler) BeforeReceive(ctx context.Context, dv *tok.Device, data []byte) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BeforeReceive", ctx, dv, data)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// BeforeReceive indicates an expected call of BeforeReceive.
+func (mr *MockContextBeforeReceiveHandlerMockRecorder) BeforeReceive(ctx, dv, data any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BeforeReceive", reflect.TypeOf((*MockContextBeforeReceiveHandler)(nil).BeforeReceive), ctx, dv, data)
+}
+
+// MockContextBeforeSendHandler is a mock of ContextBeforeSendHandler interface.
+type MockContextBeforeSendHandler struct {
+	ctrl     *gomock.Controller
+	recorder *MockContextBeforeSendHandlerMockRecorder
+	isgomock struct{}
+}
+
+// MockContextBeforeSendHandlerMockRecorder is the mock recorder for MockContextBeforeSendHandler.
+type MockContextBeforeSendHandlerMockRecorder struct {
+	mock *MockContextBeforeSendHandler
+}
+
+// NewMockContextBeforeSendHandler creates a new mock instance.
+func NewMockContextBeforeSendHandler(ctrl *gomock.Controller) *MockContextBeforeSendHandler {
+	mock := &MockContextBeforeSendHandler{ctrl: ctrl}
+	mock.recorder = &MockContextBeforeSendHandlerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockContextBeforeSendHandler) EXPECT() *MockContextBeforeSendHandlerMockRecorder {
+	return m.recorder
+}
+
+// BeforeSend mocks base method.
+func (m *MockContextBeforeSendHandler) BeforeSend(ctx context.Context, dv *tok.Device, data []byte) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BeforeSend", ctx, dv, data)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// BeforeSend indicates an expected call of BeforeSend.
+func (mr *MockContextBeforeSendHandlerMockRecorder) BeforeSend(ctx, dv, data any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BeforeSend", reflect.TypeOf((*MockContextBeforeSendHandler)(nil).BeforeSend), ctx, dv, data)
+}
+
+// MockContextAfterSendHandler is a mock of ContextAfterSendHandler interface.
+type MockContextAfterSendHandler struct {
+	ctrl     *gomock.Controller
+	recorder *MockContextAfterSendHandlerMockRecorder
+	isgomock struct{}
+}
+
+// MockContextAfterSendHandlerMockRecorder is the mock recorder for MockContextAfterSendHandler.
+type MockContextAfterSendHandlerMockRecorder struct {
+	mock *MockContextAfterSendHandler
+}
+
+// NewMockContextAfterSendHandler creates a new mock instance.
+func NewMockContextAfterSendHandler(ctrl *gomock.Controller) *MockContextAfterSendHandler {
+	mock := &MockContextAfterSendHandler{ctrl: ctrl}
+	mock.recorder = &MockContextAfterSendHandlerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockContextAfterSendHandler) EXPECT() *MockContextAfterSendHandlerMockRecorder {
+	return m.recorder
+}
+
+// AfterSend mocks base method.
+func (m *MockContextAfterSendHandler) AfterSend(ctx context.Context, dv *tok.Device, data []byte) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "AfterSend", ctx, dv, data)
+}
+
+// AfterSend indicates an expected call of AfterSend.
+func (mr *MockContextAfterSendHandlerMockRecorder) AfterSend(ctx, dv, data any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AfterSend", reflect.TypeOf((*MockContextAfterSendHandler)(nil).AfterSend), ctx, dv, data)
+}
+
+// MockContextCloseHandler is a mock of ContextCloseHandler interface.
+type MockContextCloseHandler struct {
+	ctrl     *gomock.Controller
+	recorder *MockContextCloseHandlerMockRecorder
+	isgomock struct{}
+}
+
+// MockContextCloseHandlerMockRecorder is the mock recorder for MockContextCloseHandler.
+type MockContextCloseHandlerMockRecorder struct {
+	mock *MockContextCloseHandler
+}
+
+// NewMockContextCloseHandler creates a new mock instance.
+func NewMockContextCloseHandler(ctrl *gomock.Controller) *MockContextCloseHandler {
+	mock := &MockContextCloseHandler{ctrl: ctrl}
+	mock.recorder = &MockContextCloseHandlerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockContextCloseHandler) EXPECT() *MockContextCloseHandlerMockRecorder {
+	return m.recorder
+}
+
+// OnClose mocks base method.
+func (m *MockContextCloseHandler) OnClose(ctx context.Context, dv *tok.Device) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "OnClose", ctx, dv)
+}
+
+// OnClose indicates an expected call of OnClose.
+func (mr *MockContextCloseHandlerMockRecorder) OnClose(ctx, dv any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnClose", reflect.TypeOf((*MockContextCloseHandler)(nil).OnClose), ctx, dv)
+}
+
+// MockActorWithResult is a mock of ActorWithResult interface.
+type MockActorWithResult struct {
+	ctrl     *gomock.Controller
+	recorder *MockActorWithResultMockRecorder
+	isgomock struct{}
+}
+
+// MockActorWithResultMockRecorder is the mock recorder for MockActorWithResult.
+type MockActorWithResultMockRecorder struct {
+	mock *MockActorWithResult
+}
+
+// NewMockActorWithResult creates a new mock instance.
+func NewMockActorWithResult(ctrl *gomock.Controller) *MockActorWithResult {
+	mock := &MockActorWithResult{ctrl: ctrl}
+	mock.recorder = &MockActorWithResultMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockActorWithResult) EXPECT() *MockActorWithResultMockRecorder {
+	return m.recorder
+}
+
+// OnReceive mocks base method.
+func (m *MockActorWithResult) OnReceive(ctx context.Context, dv *tok.Device, data []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "OnReceive", ctx, dv, data)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// OnReceive indicates an expected call of OnReceive.
+func (mr *MockActorWithResultMockRecorder) OnReceive(ctx, dv, data any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnReceive", reflect.TypeOf((*MockActorWithResult)(nil).OnReceive), ctx, dv, data)
+}
+
+// MockErrorPolicy is a mock of ErrorPolicy interface.
+type MockErrorPolicy struct {
+	ctrl     *gomock.Controller
+	recorder *MockErrorPolicyMockRecorder
+	isgomock struct{}
+}
+
+// MockErrorPolicyMockRecorder is the mock recorder for MockErrorPolicy.
+type MockErrorPolicyMockRecorder struct {
+	mock *MockErrorPolicy
+}
+
+// NewMockErrorPolicy creates a new mock instance.
+func NewMockErrorPolicy(ctrl *gomock.Controller) *MockErrorPolicy {
+	mock := &MockErrorPolicy{ctrl: ctrl}
+	mock.recorder = &MockErrorPolicyMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockErrorPolicy) EXPECT() *MockErrorPolicyMockRecorder {
+	return m.recorder
+}
+
+// OnError mocks base method.
+func (m *MockErrorPolicy) OnError(ctx context.Context, dv *tok.Device, err error) tok.ErrorDecision {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "OnError", ctx, dv, err)
+	ret0, _ := ret[0].(tok.ErrorDecision)
+	return ret0
+}
+
+// OnError indicates an expected call of OnError.
+func (mr *MockErrorPolicyMockRecorder) OnError(ctx, dv, err any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnError", reflect.TypeOf((*MockErrorPolicy)(nil).OnError), ctx, dv, err)
+}
+
+// MockPanicHandler is a mock of PanicHandler interface.
+type MockPanicHandler struct {
+	ctrl     *gomock.Controller
+	recorder *MockPanicHandlerMockRecorder
+	isgomock struct{}
+}
+
+// MockPanicHandlerMockRecorder is the mock recorder for MockPanicHandler.
+type MockPanicHandlerMockRecorder struct {
+	mock *MockPanicHandler
+}
+
+// NewMockPanicHandler creates a new mock instance.
+func NewMockPanicHandler(ctrl *gomock.Controller) *MockPanicHandler {
+	mock := &MockPanicHandler{ctrl: ctrl}
+	mock.recorder = &MockPanicHandlerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockPanicHandler) EXPECT() *MockPanicHandlerMockRecorder {
+	return m.recorder
+}
+
+// OnPanic mocks base method.
+func (m *MockPanicHandler) OnPanic(dv *tok.Device, hook string, value any, stack []byte) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "OnPanic", dv, hook, value, stack)
+}
+
+// OnPanic indicates an expected call of OnPanic.
+func (mr *MockPanicHandlerMockRecorder) OnPanic(dv, hook, value, stack any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnPanic", reflect.TypeOf((*MockPanicHandler)(nil).OnPanic), dv, hook, value, stack)
+}
+
+// MockRequestCodec is a mock of RequestCodec interface.
+type MockRequestCodec struct {
+	ctrl     *gomock.Controller
+	recorder *MockRequestCodecMockRecorder
+	isgomock struct{}
+}
+
+// MockRequestCodecMockRecorder is the mock recorder for MockRequestCodec.
+type MockRequestCodecMockRecorder struct {
+	mock *MockRequestCodec
+}
+
+// NewMockRequestCodec creates a new mock instance.
+func NewMockRequestCodec(ctrl *gomock.Controller) *MockRequestCodec {
+	mock := &MockRequestCodec{ctrl: ctrl}
+	mock.recorder = &MockRequestCodecMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRequestCodec) EXPECT() *MockRequestCodecMockRecorder {
+	return m.recorder
+}
+
+// Decode mocks base method.
+func (m *MockRequestCodec) Decode(data []byte) (tok.RequestFrame, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Decode", data)
+	ret0, _ := ret[0].(tok.RequestFrame)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Decode indicates an expected call of Decode.
+func (mr *MockRequestCodecMockRecorder) Decode(data any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Decode", reflect.TypeOf((*MockRequestCodec)(nil).Decode), data)
+}
+
+// Encode mocks base method.
+func (m *MockRequestCodec) Encode(f tok.RequestFrame) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Encode", f)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Encode indicates an expected call of Encode.
+func (mr *MockRequestCodecMockRecorder) Encode(f any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Encode", reflect.TypeOf((*MockRequestCodec)(nil).Encode), f)
+}
+
+// MockLastSeenStore is a mock of LastSeenStore interface.
+type MockLastSeenStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockLastSeenStoreMockRecorder
+	isgomock struct{}
+}
+
+// MockLastSeenStoreMockRecorder is the mock recorder for MockLastSeenStore.
+type MockLastSeenStoreMockRecorder struct {
+	mock *MockLastSeenStore
+}
+
+// NewMockLastSeenStore creates a new mock instance.
+func NewMockLastSeenStore(ctrl *gomock.Controller) *MockLastSeenStore {
+	mock := &MockLastSeenStore{ctrl: ctrl}
+	mock.recorder = &MockLastSeenStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLastSeenStore) EXPECT() *MockLastSeenStoreMockRecorder {
+	return m.recorder
+}
+
+// LastSeen mocks base method.
+func (m *MockLastSeenStore) LastSeen(ctx context.Context, uid any) (time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LastSeen", ctx, uid)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LastSeen indicates an expected call of LastSeen.
+func (mr *MockLastSeenStoreMockRecorder) LastSeen(ctx, uid any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LastSeen", reflect.TypeOf((*MockLastSeenStore)(nil).LastSeen), ctx, uid)
+}
+
+// SetLastSeen mocks base method.
+func (m *MockLastSeenStore) SetLastSeen(ctx context.Context, uid any, t time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetLastSeen", ctx, uid, t)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetLastSeen indicates an expected call of SetLastSeen.
+func (mr *MockLastSeenStoreMockRecorder) SetLastSeen(ctx, uid, t any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLastSeen", reflect.TypeOf((*MockLastSeenStore)(nil).SetLastSeen), ctx, uid, t)
+}
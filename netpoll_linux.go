@@ -0,0 +1,369 @@
+//go:build linux
+
+/**
+ * epoll reactor for tcp connections
+ */
+
+package tok
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	netpollEvents = syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT
+	netpollRead   = 16 * 1024 // upper limit of bytes read from a socket at once, socket is rearmed for the rest
+)
+
+// netpollBufPool holds read buffers of netpoll, a connection keeps one only while it has a partial frame
+var netpollBufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, netpollRead)
+		return &b
+	},
+}
+
+// netpoller reads tcp connections only when they are readable.
+// Sockets are watched by one epoll goroutine, and readable ones are read by a fixed pool of workers,
+// so idle connections cost no goroutine.
+//
+// Each socket is armed with EPOLLONESHOT, and rearmed after worker has read what's available,
+// so a connection is never read by two workers at the same time.
+// Workers never block on a socket: bytes of partial frame are kept by the connection until the rest arrives.
+type netpoller struct {
+	epfd    int
+	mu      sync.Mutex
+	conns   map[int32]*pollAdapter // registered adapters by id, id instead of fd is used as event data, as fd can be reused
+	nextID  int32
+	chReady chan *pollAdapter
+}
+
+// pollAdapter is tcp adapter registered to netpoller
+type pollAdapter struct {
+	*tcpAdapter
+	poller   *netpoller
+	id       int32
+	rc       syscall.RawConn // raw socket of tcpAdapter.raw
+	conn     *connection
+	buf      []byte        // bytes read but not framed yet, touched by the worker reading the socket only
+	rd       bytes.Reader  // reader of buf for framer
+	partial  atomic.Int64  // unix nano time when the partial frame in buf started, 0 if there is none
+	lastFill atomic.Int64  // unix nano time when bytes were last read from socket
+	idle     time.Duration // read timeout of hub, connection silent for longer is closed, 0 means never
+	handoff  atomic.Uint32 // synchronizes buf between workers, as epoll rearming is invisible to go memory model
+	wheel    *timingWheel  // timing wheel of hub
+	timer    *wheelTimer   // closes connection if it's silent, or its partial frame isn't completed, within read timeout
+}
+
+func newNetpoller(workers int) (*netpoller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("epoll create err: %w", err)
+	}
+
+	p := &netpoller{
+		epfd:    epfd,
+		conns:   make(map[int32]*pollAdapter),
+		chReady: make(chan *pollAdapter, workers),
+	}
+	go p.wait()
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p, nil
+}
+
+// register brings authorized connection online in hub, and watches it for reading
+func (p *netpoller) register(hub *Hub, dv *Device, adapter *tcpAdapter) {
+	sc, ok := adapter.raw.(syscall.Conn)
+	if !ok {
+		slog.Warn("[tok] netpoll unsupported connection, fallback to read loop", "type", fmt.Sprintf("%T", adapter.raw))
+		hub.RegisterConnection(context.Background(), dv, adapter)
+		return
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		slog.Warn("[tok] netpoll get raw conn err", "err", err)
+		_ = adapter.Close()
+		return
+	}
+
+	pa := &pollAdapter{tcpAdapter: adapter, poller: p, rc: rc, idle: hub.config.readTimeout}
+	pa.wheel = hub.wheel
+	pa.timer = pa.wheel.newTimer(pa.onReadTimeout)
+	pa.lastFill.Store(time.Now().UnixNano())
+	if adapter.br != nil {
+		// take over bytes pipelined after auth frame
+		b, _ := adapter.br.Peek(adapter.br.Buffered())
+		pa.buf = append(pa.buf, b...)
+		adapter.br.Reset(nil)
+		readerPool.Put(adapter.br)
+		adapter.br = nil
+	}
+	pa.conn = hub.register(context.Background(), dv, pa)
+	if pa.idle > 0 {
+		// same as read deadline of goroutine per connection, there is no reader to notice silent peer
+		pa.wheel.reset(pa.timer, pa.idle)
+	}
+
+	if len(pa.buf) > 0 {
+		// socket might never be readable again for buffered frames
+		p.chReady <- pa
+		return
+	}
+	if err := p.add(pa); err != nil {
+		slog.Warn("[tok] netpoll add err", "err", err)
+		pa.conn.triggerOffline(DisconnectReasonClosed)
+	}
+}
+
+func (p *netpoller) add(pa *pollAdapter) error {
+	p.mu.Lock()
+	for {
+		p.nextID++
+		if _, ok := p.conns[p.nextID]; !ok && p.nextID != 0 {
+			break
+		}
+	}
+	pa.id = p.nextID
+	p.conns[pa.id] = pa
+	p.mu.Unlock()
+
+	if err := p.ctl(pa, syscall.EPOLL_CTL_ADD); err != nil {
+		p.mu.Lock()
+		delete(p.conns, pa.id)
+		p.mu.Unlock()
+		return err
+	}
+	if pa.conn.isClosed() {
+		// closed meanwhile, its remove might have missed the entry
+		p.remove(pa)
+	}
+	return nil
+}
+
+// rearm watches socket again after it has been read
+func (p *netpoller) rearm(pa *pollAdapter) error {
+	if pa.id == 0 {
+		// never added, it was read for buffered frames right after auth
+		return p.add(pa)
+	}
+	return p.ctl(pa, syscall.EPOLL_CTL_MOD)
+}
+
+// remove stops watching socket, it must be called before socket closed
+func (p *netpoller) remove(pa *pollAdapter) {
+	p.mu.Lock()
+	_, ok := p.conns[pa.id]
+	delete(p.conns, pa.id)
+	p.mu.Unlock()
+
+	if ok {
+		_ = p.ctl(pa, syscall.EPOLL_CTL_DEL)
+	}
+}
+
+func (p *netpoller) ctl(pa *pollAdapter, op int) error {
+	var err error
+	ctlErr := pa.rc.Control(func(fd uintptr) {
+		ev := syscall.EpollEvent{Events: netpollEvents, Fd: pa.id}
+		err = syscall.EpollCtl(p.epfd, op, int(fd), &ev)
+	})
+	return errors.Join(ctlErr, err)
+}
+
+// wait dispatches readable sockets to workers
+func (p *netpoller) wait() {
+	events := make([]syscall.EpollEvent, 256)
+	for {
+		n, err := syscall.EpollWait(p.epfd, events, -1)
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			slog.Error("[tok] epoll wait err", "err", err)
+			return
+		}
+
+		for _, ev := range events[:n] {
+			p.mu.Lock()
+			pa := p.conns[ev.Fd]
+			p.mu.Unlock()
+			if pa != nil {
+				p.chReady <- pa
+			}
+		}
+	}
+}
+
+func (p *netpoller) work() {
+	for pa := range p.chReady {
+		p.read(pa)
+	}
+}
+
+// read reads available bytes of socket without blocking, passes complete frames to hub, then rearms socket.
+// A partial frame is kept until the rest arrives, it must be completed within read timeout of adapter.
+// If hub has read timeout, connection silent for longer is closed as well, see onReadTimeout.
+func (p *netpoller) read(pa *pollAdapter) {
+	pa.handoff.Add(1)
+	conn := pa.conn
+	if conn.isClosed() {
+		return
+	}
+
+	// frames read before EOF are still passed to hub
+	err := pa.fill()
+	if frameErr := pa.frames(); frameErr != nil {
+		err = frameErr
+	}
+	if err != nil {
+		slog.Debug("read err", "err", err)
+		conn.triggerOffline(DisconnectReasonClosed)
+		return
+	}
+
+	pa.handoff.Add(1)
+	if err := p.rearm(pa); err != nil && !conn.isClosed() {
+		slog.Warn("[tok] netpoll rearm err", "err", err)
+		conn.triggerOffline(DisconnectReasonClosed)
+	}
+}
+
+// fill reads available bytes of socket into buf, it never waits for socket to be readable
+func (pa *pollAdapter) fill() error {
+	if pa.buf == nil {
+		pa.buf = (*netpollBufPool.Get().(*[]byte))[:0]
+	}
+	pa.buf = growBuf(pa.buf, netpollRead)
+	free := pa.buf[len(pa.buf):cap(pa.buf)]
+	free = free[:min(len(free), netpollRead)]
+
+	var n int
+	var err error
+	ctlErr := pa.rc.Read(func(fd uintptr) bool {
+		n, err = syscall.Read(int(fd), free)
+		return true
+	})
+	switch {
+	case ctlErr != nil:
+		return ctlErr
+	case errors.Is(err, syscall.EAGAIN), errors.Is(err, syscall.EINTR):
+		return nil
+	case err != nil:
+		return err
+	case n == 0:
+		return io.EOF
+	}
+	pa.buf = pa.buf[:len(pa.buf)+n]
+	pa.lastFill.Store(time.Now().UnixNano())
+	return nil
+}
+
+// growBuf makes sure there is room for n more bytes in b
+func growBuf(b []byte, n int) []byte {
+	if cap(b)-len(b) >= n {
+		return b
+	}
+	return append(b, make([]byte, n)...)[:len(b)]
+}
+
+// frames passes complete frames in buf to hub, and keeps partial frame in buf
+func (pa *pollAdapter) frames() error {
+	br := readerPool.Get().(*bufio.Reader)
+	defer func() {
+		br.Reset(nil)
+		readerPool.Put(br)
+	}()
+
+	off := 0
+	completed := false
+	for off < len(pa.buf) {
+		pa.rd.Reset(pa.buf[off:])
+		br.Reset(&pa.rd)
+		b, err := pa.framer.ReadFrame(br, pa.maxFrameLen)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// partial frame
+			break
+		}
+		if err != nil {
+			return err
+		}
+		off += len(pa.buf) - off - pa.rd.Len() - br.Buffered()
+		completed = true
+		pa.conn.received(b)
+	}
+	pa.rd.Reset(nil)
+
+	pa.buf = pa.buf[:copy(pa.buf, pa.buf[off:])]
+	switch {
+	case len(pa.buf) == 0:
+		pa.partial.Store(0)
+		if pa.idle == 0 {
+			pa.wheel.stop(pa.timer)
+		}
+		if b := pa.buf; cap(b) == netpollRead {
+			netpollBufPool.Put(&b)
+		}
+		pa.buf = nil
+	case completed || pa.partial.Load() == 0:
+		// a new partial frame started
+		pa.partial.Store(time.Now().UnixNano())
+		if pa.idle == 0 {
+			// otherwise timer is armed already, and fires no later than the partial frame deadline
+			pa.wheel.reset(pa.timer, pa.readTimeout)
+		}
+	}
+	return nil
+}
+
+// readDeadline returns when connection times out, zero if never.
+// It's read timeout of hub after bytes were last read, or read timeout of adapter after the partial frame started.
+func (pa *pollAdapter) readDeadline() time.Time {
+	var deadline time.Time
+	if pa.idle > 0 {
+		deadline = time.Unix(0, pa.lastFill.Load()).Add(pa.idle)
+	}
+	if started := pa.partial.Load(); started != 0 {
+		if d := time.Unix(0, started).Add(pa.readTimeout); deadline.IsZero() || d.Before(deadline) {
+			deadline = d
+		}
+	}
+	return deadline
+}
+
+// onReadTimeout runs in wheel goroutine, it closes connection which is silent, or whose partial frame isn't completed, in time.
+// With read timeout of hub, reads only move the deadline, and timer is rescheduled here for it.
+func (pa *pollAdapter) onReadTimeout() {
+	deadline := pa.readDeadline()
+	if deadline.IsZero() {
+		return
+	}
+	if left := time.Until(deadline); left > 0 {
+		pa.wheel.reset(pa.timer, left)
+		return
+	}
+	slog.Debug("[tok] netpoll read timeout", "uid", pa.conn.uid())
+	pa.conn.triggerOffline(DisconnectReasonClosed)
+}
+
+func (pa *pollAdapter) Close() error {
+	pa.poller.remove(pa)
+	pa.wheel.stop(pa.timer)
+	return pa.tcpAdapter.Close()
+}
+
+func (pa *pollAdapter) ShareConn(adapter ConAdapter) bool {
+	other, ok := adapter.(*pollAdapter)
+	return ok && pa.tcpAdapter.conn == other.tcpAdapter.conn
+}
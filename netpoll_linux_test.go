@@ -0,0 +1,73 @@
+//go:build linux
+
+package tok_test
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/quexer/tok"
+)
+
+const benchTCPConns = 5000
+
+// benchIdleTCP measures memory of idle tcp connections, both ends of connections live in this process
+func benchIdleTCP(b *testing.B, opts ...tok.TCPListenerOption) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	config := tok.NewHubConfig(benchActor{},
+		tok.WithHubConfigQueue(nil),
+		tok.WithHubConfigPingProducer(benchPingGen{}),
+		tok.WithHubConfigServerPingInterval(time.Minute))
+	auth := func(b []byte) (*tok.Device, error) {
+		return tok.CreateDevice(string(b), ""), nil
+	}
+	hub, err := tok.Listen(nil, config, addr, auth, opts...)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	measure(b, benchTCPConns, time.Second, func() func() {
+		ctx := context.Background()
+		conns := make([]net.Conn, 0, benchTCPConns)
+		for n := 0; n < benchTCPConns; n++ {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				b.Fatal(err)
+			}
+			uid := strconv.Itoa(n)
+			frame := binary.BigEndian.AppendUint32(nil, uint32(len(uid)))
+			if _, err := conn.Write(append(frame, uid...)); err != nil {
+				b.Fatal(err)
+			}
+			conns = append(conns, conn)
+		}
+		for len(hub.Online(ctx)) < benchTCPConns {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		return func() {
+			for _, conn := range conns {
+				_ = conn.Close()
+			}
+			for len(hub.Online(ctx)) > 0 {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	})
+}
+
+// BenchmarkTCPIdle5k is the baseline, each connection costs a goroutine blocking in read loop
+func BenchmarkTCPIdle5k(b *testing.B) { benchIdleTCP(b) }
+
+// BenchmarkTCPNetpollIdle5k reads connections by epoll workers
+func BenchmarkTCPNetpollIdle5k(b *testing.B) { benchIdleTCP(b, tok.WithTCPListenerNetpoll(0)) }
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package tok
+
+import "errors"
+
+// netpoller is only available on linux
+type netpoller struct{}
+
+func newNetpoller(int) (*netpoller, error) {
+	return nil, errors.New("tok: netpoll is only supported on linux")
+}
+
+func (p *netpoller) register(*Hub, *Device, *tcpAdapter) {}
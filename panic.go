@@ -0,0 +1,54 @@
+/**
+ * panic isolation of user hooks
+ */
+
+package tok
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+)
+
+// recoverHook recovers panic of user hook, it must be deferred directly.
+// The panic is reported to PanicHandler, and conn is closed if it's configured, closing is idempotent. conn might be nil.
+// If errp is not nil, it's set to an error wrapping ErrHookPanic.
+func (p *Hub) recoverHook(hook string, conn *connection, errp *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	var dv *Device
+	if conn != nil {
+		dv = conn.dv
+	}
+	p.reportPanic(dv, hook, r, debug.Stack())
+
+	if errp != nil {
+		*errp = fmt.Errorf("%w: %s: %v", ErrHookPanic, hook, r)
+	}
+	if conn != nil && p.config.closeOnPanic {
+		conn.triggerOffline(DisconnectReasonPanic)
+	}
+}
+
+// reportPanic passes panic to PanicHandler, or logs it if there is no handler
+func (p *Hub) reportPanic(dv *Device, hook string, value interface{}, stack []byte) {
+	hdl := p.config.panicHandler
+	if hdl == nil {
+		var uid interface{}
+		if dv != nil {
+			uid = dv.UID()
+		}
+		slog.Error("[tok] hook panicked", "hook", hook, "uid", uid, "panic", value, "stack", string(stack))
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("[tok] panic handler panicked", "hook", hook, "panic", r)
+		}
+	}()
+	hdl.OnPanic(dv, hook, value, stack)
+}
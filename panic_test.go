@@ -0,0 +1,266 @@
+package tok_test
+
+import (
+	"context"
+	"io"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/mocks"
+)
+
+// panicQueue is Queue panicking in all methods
+type panicQueue struct{}
+
+func (panicQueue) Enq(context.Context, interface{}, []byte, ...uint32) error {
+	panic("enq")
+}
+
+func (panicQueue) Deq(context.Context, interface{}) ([]byte, error) {
+	panic("deq")
+}
+
+func (panicQueue) Len(context.Context, interface{}) (int, error) {
+	panic("len")
+}
+
+var _ = Describe("Panic", func() {
+	var (
+		mockAdapter *mocks.MockConAdapter
+		mockActor   *mocks.MockActorWithResult
+		mockPanic   *mocks.MockPanicHandler
+		hub         *tok.Hub
+		device      *tok.Device
+		chRead      chan []byte
+		chWritten   chan []byte
+		chClosed    chan struct{}
+	)
+
+	BeforeEach(func() {
+		mockAdapter = mocks.NewMockConAdapter(ctl)
+		mockActor = mocks.NewMockActorWithResult(ctl)
+		mockPanic = mocks.NewMockPanicHandler(ctl)
+		device = tok.CreateDevice("panic-user", "panic-session")
+
+		read := make(chan []byte)
+		writes := make(chan []byte, 10)
+		closed := make(chan struct{})
+		mockAdapter.EXPECT().Read().DoAndReturn(func() ([]byte, error) {
+			select {
+			case b := <-read:
+				return b, nil
+			case <-closed:
+				return nil, io.EOF
+			}
+		}).AnyTimes()
+		mockAdapter.EXPECT().Close().DoAndReturn(func() error {
+			close(closed)
+			return nil
+		})
+		mockAdapter.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) error {
+			writes <- b
+			return nil
+		}).AnyTimes()
+		chRead, chWritten, chClosed = read, writes, closed
+	})
+
+	AfterEach(func() {
+		hub.Kick(ctx, "panic-user")
+		Eventually(chClosed).Should(BeClosed())
+	})
+
+	// register creates hub with opts and registers the connection
+	register := func(opts ...tok.HubConfigOption) {
+		opts = append([]tok.HubConfigOption{
+			tok.WithHubConfigPingProducer(mocks.NewMockPingGenerator(ctl)),
+			tok.WithHubConfigQueue(nil),
+		}, opts...)
+		config := tok.NewHubConfigWithResult(mockActor, opts...)
+		hub, _ = tok.CreateWsHandler(nil, tok.WithWsHandlerHubConfig(config))
+
+		go hub.RegisterConnection(ctx, device, mockAdapter)
+		Eventually(func() bool { return hub.CheckOnline(ctx, "panic-user") }).Should(BeTrue())
+	}
+
+	It("should report actor panic with device and stack, and keep connection", func() {
+		chDone := make(chan struct{}, 1)
+		gomock.InOrder(
+			mockActor.EXPECT().OnReceive(gomock.Any(), device, []byte("boom")).DoAndReturn(
+				func(context.Context, *tok.Device, []byte) error {
+					panic("boom")
+				}),
+			mockActor.EXPECT().OnReceive(gomock.Any(), device, []byte("ok")).DoAndReturn(
+				func(context.Context, *tok.Device, []byte) error {
+					chDone <- struct{}{}
+					return nil
+				}),
+		)
+		chStack := make(chan []byte, 1)
+		mockPanic.EXPECT().OnPanic(device, "OnReceive", "boom", gomock.Any()).Do(
+			func(_ *tok.Device, _ string, _ interface{}, stack []byte) {
+				chStack <- stack
+			})
+		// panic is not passed to error policy
+		policy := tok.ErrorPolicyFunc(func(context.Context, *tok.Device, error) tok.ErrorDecision {
+			Fail("policy should not be called")
+			return tok.ErrorDecision{}
+		})
+		register(tok.WithHubConfigPanicHandler(mockPanic, false), tok.WithHubConfigErrorPolicy(policy, 1))
+
+		chRead <- []byte("boom")
+		var stack []byte
+		Eventually(chStack).Should(Receive(&stack))
+		Ω(string(stack)).To(ContainSubstring("panic_test.go"))
+
+		chRead <- []byte("ok")
+		Eventually(chDone).Should(Receive())
+		Ω(chClosed).NotTo(BeClosed())
+	})
+
+	It("should close connection of panicked hook if configured", func() {
+		mockActor.EXPECT().OnReceive(gomock.Any(), device, []byte("boom")).DoAndReturn(
+			func(context.Context, *tok.Device, []byte) error {
+				panic("boom")
+			})
+		mockPanic.EXPECT().OnPanic(device, "OnReceive", "boom", gomock.Any())
+		register(tok.WithHubConfigPanicHandler(mockPanic, true))
+
+		chRead <- []byte("boom")
+		Eventually(chClosed).Should(BeClosed())
+		Eventually(func() bool { return hub.CheckOnline(ctx, "panic-user") }).Should(BeFalse())
+	})
+
+	It("should recover BeforeSend and AfterSend panics", func() {
+		mockBefore := mocks.NewMockBeforeSendHandler(ctl)
+		mockBefore.EXPECT().BeforeSend(device, []byte("bad")).Do(func(*tok.Device, []byte) {
+			panic("before")
+		})
+		mockBefore.EXPECT().BeforeSend(device, []byte("good")).Return([]byte("good"), nil)
+		mockAfter := mocks.NewMockAfterSendHandler(ctl)
+		mockAfter.EXPECT().AfterSend(device, []byte("good")).Do(func(*tok.Device, []byte) {
+			panic("after")
+		})
+
+		chHook := make(chan string, 2)
+		mockPanic.EXPECT().OnPanic(device, gomock.Any(), gomock.Any(), gomock.Any()).Do(
+			func(_ *tok.Device, hook string, _ interface{}, _ []byte) {
+				chHook <- hook
+			}).Times(2)
+		register(tok.WithHubConfigPanicHandler(mockPanic, false),
+			tok.WithHubConfigBeforeSend(mockBefore), tok.WithHubConfigAfterSend(mockAfter))
+
+		Ω(hub.Send(ctx, "panic-user", []byte("bad"), 0)).To(MatchError(tok.ErrHookPanic))
+		Eventually(chHook).Should(Receive(Equal("BeforeSend")))
+
+		Ω(hub.Send(ctx, "panic-user", []byte("good"), 0)).To(Succeed())
+		Eventually(chWritten).Should(Receive(Equal([]byte("good"))))
+		Eventually(chHook).Should(Receive(Equal("AfterSend")))
+	})
+
+	It("should recover Bye and OnClose panics", func() {
+		mockBye := mocks.NewMockByeGenerator(ctl)
+		mockBye.EXPECT().Bye(gomock.Any(), tok.ByeReasonSSO, device).Do(func(*tok.Device, string, *tok.Device) {
+			panic("bye")
+		})
+		mockClose := mocks.NewMockCloseHandler(ctl)
+		mockClose.EXPECT().OnClose(device).Do(func(*tok.Device) {
+			panic("close")
+		})
+
+		chHook := make(chan string, 2)
+		mockPanic.EXPECT().OnPanic(device, gomock.Any(), gomock.Any(), gomock.Any()).Do(
+			func(_ *tok.Device, hook string, _ interface{}, _ []byte) {
+				chHook <- hook
+			}).Times(2)
+		register(tok.WithHubConfigPanicHandler(mockPanic, false),
+			tok.WithHubConfigByeGenerator(mockBye), tok.WithHubConfigCloseHandler(mockClose))
+
+		// kicked by a newer connection of the same uid
+		chNewClosed := make(chan struct{})
+		newAdapter := mocks.NewMockConAdapter(ctl)
+		newAdapter.EXPECT().Read().DoAndReturn(func() ([]byte, error) {
+			<-chNewClosed
+			return nil, io.EOF
+		})
+		newAdapter.EXPECT().ShareConn(mockAdapter).Return(false)
+		newAdapter.EXPECT().Close().DoAndReturn(func() error {
+			close(chNewClosed)
+			return nil
+		})
+		mockClose.EXPECT().OnClose(gomock.Any()).AnyTimes()
+		go hub.RegisterConnection(ctx, tok.CreateDevice("panic-user", "new-session"), newAdapter)
+
+		Eventually(chClosed).Should(BeClosed())
+		Eventually(chHook).Should(Receive(Equal("Bye")))
+		Eventually(chHook).Should(Receive(Equal("OnClose")))
+
+		hub.Kick(ctx, "panic-user")
+		Eventually(chNewClosed).Should(BeClosed())
+	})
+
+	It("should recover SetLastSeen panic", func() {
+		mockStore := mocks.NewMockLastSeenStore(ctl)
+		mockStore.EXPECT().SetLastSeen(gomock.Any(), "panic-user", gomock.Any()).Do(
+			func(context.Context, interface{}, time.Time) {
+				panic("store")
+			}).MinTimes(1)
+		chHook := make(chan string, 10)
+		mockPanic.EXPECT().OnPanic(device, "SetLastSeen", "store", gomock.Any()).Do(
+			func(_ *tok.Device, hook string, _ interface{}, _ []byte) {
+				chHook <- hook
+			}).MinTimes(1)
+		register(tok.WithHubConfigPanicHandler(mockPanic, false),
+			tok.WithHubConfigLastSeen(mockStore, time.Millisecond))
+
+		mockActor.EXPECT().OnReceive(gomock.Any(), device, []byte("m")).Return(nil).AnyTimes()
+		chRead <- []byte("m")
+		Eventually(chHook).Should(Receive())
+		Ω(chClosed).NotTo(BeClosed())
+	})
+
+	It("should recover Queue panics", func() {
+		chHook := make(chan string, 10)
+		mockPanic.EXPECT().OnPanic(nil, gomock.Any(), gomock.Any(), gomock.Any()).Do(
+			func(_ *tok.Device, hook string, _ interface{}, _ []byte) {
+				chHook <- hook
+			}).AnyTimes()
+		register(tok.WithHubConfigPanicHandler(mockPanic, false), tok.WithHubConfigQueue(panicQueue{}))
+
+		err := hub.Send(ctx, "offline-user", []byte("m"), 60)
+		Ω(err).To(MatchError(tok.ErrCacheFailed))
+		Ω(err).To(MatchError(tok.ErrHookPanic))
+		Eventually(chHook).Should(Receive(Equal("Enq")))
+
+		_, err = hub.QueueLen(ctx, "offline-user")
+		Ω(err).To(MatchError(tok.ErrHookPanic))
+		Eventually(chHook).Should(Receive(Equal("Len")))
+
+		_, err = hub.PurgeQueue(ctx, "offline-user")
+		Ω(err).To(MatchError(tok.ErrHookPanic))
+		Eventually(chHook).Should(Receive(Equal("Deq")))
+		Ω(chClosed).NotTo(BeClosed())
+	})
+
+	It("should recover Ping panic, and keep pinging", func() {
+		mockPing := mocks.NewMockPingGenerator(ctl)
+		mockPing.EXPECT().Ping().Do(func() {
+			panic("ping")
+		}).MinTimes(2)
+		chHook := make(chan string, 100)
+		mockPanic.EXPECT().OnPanic(device, "Ping", "ping", gomock.Any()).Do(
+			func(_ *tok.Device, hook string, _ interface{}, _ []byte) {
+				chHook <- hook
+			}).MinTimes(2)
+		register(tok.WithHubConfigPanicHandler(mockPanic, false),
+			tok.WithHubConfigPingProducer(mockPing),
+			tok.WithHubConfigServerPingInterval(20*time.Millisecond))
+
+		Eventually(chHook).Should(Receive())
+		Eventually(chHook).Should(Receive())
+		Ω(chClosed).NotTo(BeClosed())
+	})
+})
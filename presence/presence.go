@@ -0,0 +1,273 @@
+// Package presence notifies watchers of presence changes of the uids they watch.
+// It follows uid online/offline events of tok hub, see tok.Hub.Subscribe, debounces them to avoid flapping on reconnects,
+// and sends notifications produced by PresenceFormatter to online watchers through tok.Hub.Send.
+package presence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"time"
+
+	"github.com/quexer/tok"
+)
+
+// Presence is presence of uid
+type Presence struct {
+	UID    interface{} // user id
+	Online bool        // whether uid is online
+	Time   time.Time   // when uid got online or offline
+}
+
+// PresenceFormatter produces notification payload of presence change for watcher
+type PresenceFormatter interface {
+	FormatPresence(watcher interface{}, p Presence) ([]byte, error)
+}
+
+// FormatterFunc adapts function to PresenceFormatter
+type FormatterFunc func(watcher interface{}, p Presence) ([]byte, error)
+
+// FormatPresence implements PresenceFormatter
+func (f FormatterFunc) FormatPresence(watcher interface{}, p Presence) ([]byte, error) {
+	return f(watcher, p)
+}
+
+const (
+	defaultDebounce    = 2 * time.Second
+	defaultEventBuffer = 4096
+	defaultWorkers     = 4
+	workerQueue        = 256 // notifications queued per worker, event loop waits while it's full
+)
+
+// Option option of Notifier
+type Option func(*Notifier)
+
+// WithDebounce set how long presence must stay unchanged before it's notified, default 2s.
+// Offline followed by online within it, e.g. reconnect, is not notified at all.
+func WithDebounce(d time.Duration) Option {
+	return func(p *Notifier) {
+		p.debounce = d
+	}
+}
+
+// WithEventBuffer set buffer size of hub event subscription, default 4096.
+// Presence is resynced if buffer overflows.
+func WithEventBuffer(size int) Option {
+	return func(p *Notifier) {
+		p.eventBuffer = size
+	}
+}
+
+// WithWorkers set how many goroutines send notifications, default 4.
+// Notifications of the same uid are sent by the same worker in order.
+func WithWorkers(n int) Option {
+	return func(p *Notifier) {
+		p.workers = n
+	}
+}
+
+// Notifier sends presence notifications to watchers
+type Notifier struct {
+	hub         *tok.Hub
+	store       WatchStore
+	formatter   PresenceFormatter
+	debounce    time.Duration
+	eventBuffer int
+	workers     int
+
+	// states below are touched in loop goroutine only
+	notified map[interface{}]bool          // uids notified as online, or online when notifier started
+	pending  map[interface{}]pendingChange // presence waiting for debounce
+	seq      uint64                        // sequence of changes, tells stale timers
+	chFire   chan debounceFire             // changes whose debounce timer fired
+}
+
+// pendingChange is presence change waiting for debounce
+type pendingChange struct {
+	pr    Presence
+	seq   uint64
+	timer *time.Timer
+}
+
+// debounceFire tells loop that debounce of change seq of uid is over
+type debounceFire struct {
+	uid interface{}
+	seq uint64
+}
+
+// New create notifier of hub, watch lists are kept in store, payloads are produced by formatter
+func New(hub *tok.Hub, store WatchStore, formatter PresenceFormatter, opts ...Option) *Notifier {
+	p := &Notifier{
+		hub:         hub,
+		store:       store,
+		formatter:   formatter,
+		debounce:    defaultDebounce,
+		eventBuffer: defaultEventBuffer,
+		workers:     defaultWorkers,
+		notified:    make(map[interface{}]bool),
+		pending:     make(map[interface{}]pendingChange),
+		chFire:      make(chan debounceFire),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.workers < 1 {
+		p.workers = 1
+	}
+	return p
+}
+
+// Watch adds targets to watch list of watcher
+func (p *Notifier) Watch(ctx context.Context, watcher interface{}, targets ...interface{}) error {
+	return p.store.Watch(ctx, watcher, targets...)
+}
+
+// Unwatch removes targets from watch list of watcher, all targets if none is given
+func (p *Notifier) Unwatch(ctx context.Context, watcher interface{}, targets ...interface{}) error {
+	return p.store.Unwatch(ctx, watcher, targets...)
+}
+
+// Start follows hub events in background until ctx is done.
+// uids online already are taken as notified online, so that watchers are notified when they get offline.
+func (p *Notifier) Start(ctx context.Context) {
+	events := p.subscribe(ctx)
+	for _, uid := range p.hub.Online(ctx) {
+		p.notified[uid] = true
+	}
+
+	queues := make([]chan Presence, p.workers)
+	for i := range queues {
+		queues[i] = make(chan Presence, workerQueue)
+		go p.work(ctx, queues[i])
+	}
+	go p.loop(ctx, events, queues)
+}
+
+func (p *Notifier) subscribe(ctx context.Context) <-chan tok.Event {
+	return p.hub.Subscribe(ctx, tok.WithSubscribeBuffer(p.eventBuffer, tok.OverflowUnsubscribe))
+}
+
+func (p *Notifier) loop(ctx context.Context, events <-chan tok.Event, queues []chan Presence) {
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					return
+				}
+				// events are lost, follow hub again, and recheck uids of known state
+				slog.Warn("[tok] presence events overflow, resync")
+				events = p.subscribe(ctx)
+				p.resync(ctx)
+				continue
+			}
+			switch e.Type {
+			case tok.EventOnline:
+				p.change(ctx, Presence{UID: e.UID, Online: true, Time: e.Time})
+			case tok.EventOffline:
+				p.change(ctx, Presence{UID: e.UID, Online: false, Time: e.Time})
+			}
+		case f := <-p.chFire:
+			uid := f.uid
+			pc, ok := p.pending[uid]
+			if !ok || pc.seq != f.seq {
+				// changed again after timer fired
+				continue
+			}
+			pr := pc.pr
+			delete(p.pending, uid)
+			if pr.Online == p.notified[uid] {
+				// changed back within debounce
+				continue
+			}
+			if pr.Online {
+				p.notified[uid] = true
+			} else {
+				delete(p.notified, uid)
+			}
+			select {
+			case queues[worker(uid, len(queues))] <- pr:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// change schedules notification of presence change after debounce, which restarts on each change of uid
+func (p *Notifier) change(ctx context.Context, pr Presence) {
+	if pc, ok := p.pending[pr.UID]; ok {
+		pc.timer.Stop()
+	}
+	p.seq++
+	f := debounceFire{uid: pr.UID, seq: p.seq}
+	timer := time.AfterFunc(p.debounce, func() {
+		select {
+		case p.chFire <- f:
+		case <-ctx.Done():
+		}
+	})
+	p.pending[pr.UID] = pendingChange{pr: pr, seq: f.seq, timer: timer}
+}
+
+// resync rechecks uids of known state after events lost
+func (p *Notifier) resync(ctx context.Context) {
+	uids := make(map[interface{}]struct{}, len(p.notified)+len(p.pending))
+	for uid := range p.notified {
+		uids[uid] = struct{}{}
+	}
+	for uid := range p.pending {
+		uids[uid] = struct{}{}
+	}
+	for uid := range uids {
+		online := p.hub.CheckOnline(ctx, uid)
+		if pc, ok := p.pending[uid]; (ok && pc.pr.Online != online) || (!ok && p.notified[uid] != online) {
+			p.change(ctx, Presence{UID: uid, Online: online, Time: time.Now()})
+		}
+	}
+}
+
+// worker picks worker of uid, so that its notifications are sent in order
+func worker(uid interface{}, n int) int {
+	h := fnv.New32a()
+	_, _ = fmt.Fprint(h, uid)
+	return int(h.Sum32() % uint32(n))
+}
+
+// work sends queued notifications until ctx is done
+func (p *Notifier) work(ctx context.Context, queue <-chan Presence) {
+	for {
+		select {
+		case pr := <-queue:
+			p.notify(ctx, pr)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// notify sends presence to online watchers
+func (p *Notifier) notify(ctx context.Context, pr Presence) {
+	watchers, err := p.store.Watchers(ctx, pr.UID)
+	if err != nil {
+		slog.Warn("[tok] presence watchers failed", "err", err, "uid", pr.UID)
+		return
+	}
+	for _, watcher := range watchers {
+		b, err := p.formatter.FormatPresence(watcher, pr)
+		if err != nil {
+			slog.Warn("[tok] format presence failed", "err", err, "uid", pr.UID, "watcher", watcher)
+			continue
+		}
+		if b == nil {
+			continue
+		}
+		if err := p.hub.Send(ctx, watcher, b, 0); err != nil && !errors.Is(err, tok.ErrOffline) {
+			slog.Warn("[tok] send presence failed", "err", err, "uid", pr.UID, "watcher", watcher)
+		}
+	}
+}
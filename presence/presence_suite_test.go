@@ -0,0 +1,26 @@
+package presence_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+)
+
+func TestPresence(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Presence Suite")
+}
+
+var ctx context.Context
+var ctl *gomock.Controller
+var _ = BeforeEach(func() {
+	ctx = context.Background()
+	ctl = gomock.NewController(GinkgoT())
+})
+
+var _ = AfterEach(func() {
+	ctl.Finish()
+})
@@ -0,0 +1,175 @@
+package presence_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/internal/testutil"
+	"github.com/quexer/tok/presence"
+)
+
+// blockingStore blocks Watchers of uid "slow" until release is closed
+type blockingStore struct {
+	presence.WatchStore
+	release chan struct{}
+}
+
+func (p *blockingStore) Watchers(ctx context.Context, target interface{}) ([]interface{}, error) {
+	if target == "slow" {
+		<-p.release
+	}
+	return p.WatchStore.Watchers(ctx, target)
+}
+
+var _ = Describe("Store", func() {
+	It("should keep watchers of target", func() {
+		store := presence.NewMemoryWatchStore()
+		Ω(store.Watch(ctx, "w1", "t1", "t2")).To(Succeed())
+		Ω(store.Watch(ctx, "w2", "t1")).To(Succeed())
+		Ω(store.Watchers(ctx, "t1")).To(ConsistOf("w1", "w2"))
+		Ω(store.Watchers(ctx, "t2")).To(ConsistOf("w1"))
+
+		Ω(store.Unwatch(ctx, "w2", "t1")).To(Succeed())
+		Ω(store.Watchers(ctx, "t1")).To(ConsistOf("w1"))
+
+		Ω(store.Unwatch(ctx, "w1")).To(Succeed())
+		Ω(store.Watchers(ctx, "t1")).To(BeEmpty())
+		Ω(store.Watchers(ctx, "t2")).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Notifier", func() {
+	var (
+		hub      *tok.Hub
+		notifier *presence.Notifier
+		watcher  *testutil.FakeAdapter
+		cancel   context.CancelFunc
+	)
+
+	formatter := presence.FormatterFunc(func(watcher interface{}, p presence.Presence) ([]byte, error) {
+		Ω(p.Time).NotTo(BeZero())
+		if p.UID == "silent" {
+			return nil, nil
+		}
+		return []byte(fmt.Sprintf("%v:%v:%v", watcher, p.UID, p.Online)), nil
+	})
+
+	connect := func(uid interface{}) *testutil.FakeAdapter {
+		return testutil.Connect(ctx, hub, tok.CreateDevice(uid, ""))
+	}
+
+	disconnect := func(uid interface{}, adapter *testutil.FakeAdapter) {
+		_ = adapter.Close()
+		Eventually(func() bool { return hub.CheckOnline(ctx, uid) }).Should(BeFalse())
+	}
+
+	BeforeEach(func() {
+		hub = testutil.NewHub(ctl, tok.WithHubConfigSso(true))
+
+		var c context.Context
+		c, cancel = context.WithCancel(ctx)
+		notifier = presence.New(hub, presence.NewMemoryWatchStore(), formatter, presence.WithDebounce(50*time.Millisecond))
+		notifier.Start(c)
+
+		watcher = connect("w")
+	})
+
+	AfterEach(func() {
+		cancel()
+		hub.Kick(ctx, "w")
+	})
+
+	It("should notify watchers of presence change", func() {
+		Ω(notifier.Watch(ctx, "w", "t")).To(Succeed())
+
+		target := connect("t")
+		Eventually(watcher.ChWritten).Should(Receive(Equal([]byte("w:t:true"))))
+
+		disconnect("t", target)
+		Eventually(watcher.ChWritten).Should(Receive(Equal([]byte("w:t:false"))))
+	})
+
+	It("should debounce reconnect", func() {
+		Ω(notifier.Watch(ctx, "w", "t")).To(Succeed())
+		target := connect("t")
+		Eventually(watcher.ChWritten).Should(Receive(Equal([]byte("w:t:true"))))
+
+		disconnect("t", target)
+		target = connect("t")
+		Consistently(watcher.ChWritten, "150ms").ShouldNot(Receive())
+
+		disconnect("t", target)
+		Eventually(watcher.ChWritten).Should(Receive(Equal([]byte("w:t:false"))))
+	})
+
+	It("should restart debounce on each change", func() {
+		c, cancel := context.WithCancel(ctx)
+		DeferCleanup(cancel)
+		notifier = presence.New(hub, presence.NewMemoryWatchStore(), formatter, presence.WithDebounce(150*time.Millisecond))
+		notifier.Start(c)
+		Ω(notifier.Watch(ctx, "w", "t")).To(Succeed())
+		target := connect("t")
+		Eventually(watcher.ChWritten).Should(Receive(Equal([]byte("w:t:true"))))
+
+		// offline when a window from the first change would end, online for good later
+		disconnect("t", target)
+		time.Sleep(40 * time.Millisecond)
+		target = connect("t")
+		time.Sleep(70 * time.Millisecond)
+		disconnect("t", target)
+		time.Sleep(50 * time.Millisecond)
+		connect("t")
+		Consistently(watcher.ChWritten, "300ms").ShouldNot(Receive())
+		hub.Kick(ctx, "t")
+	})
+
+	It("should not notify unwatched or nil payload", func() {
+		Ω(notifier.Watch(ctx, "w", "t", "silent")).To(Succeed())
+		Ω(notifier.Unwatch(ctx, "w", "t")).To(Succeed())
+
+		connect("t")
+		connect("silent")
+		connect("other")
+		Consistently(watcher.ChWritten, "150ms").ShouldNot(Receive())
+
+		hub.Kick(ctx, "t")
+		hub.Kick(ctx, "silent")
+		hub.Kick(ctx, "other")
+	})
+
+	It("should notify offline of uid online before start", func() {
+		target := connect("t")
+
+		c, cancel := context.WithCancel(ctx)
+		DeferCleanup(cancel)
+		notifier = presence.New(hub, presence.NewMemoryWatchStore(), formatter, presence.WithDebounce(50*time.Millisecond))
+		notifier.Start(c)
+		Ω(notifier.Watch(ctx, "w", "t")).To(Succeed())
+
+		disconnect("t", target)
+		Eventually(watcher.ChWritten).Should(Receive(Equal([]byte("w:t:false"))))
+	})
+
+	It("should not block notifications of other uids", func() {
+		c, cancel := context.WithCancel(ctx)
+		DeferCleanup(cancel)
+		store := &blockingStore{WatchStore: presence.NewMemoryWatchStore(), release: make(chan struct{})}
+		DeferCleanup(func() { close(store.release) })
+		notifier = presence.New(hub, store, formatter, presence.WithDebounce(50*time.Millisecond))
+		notifier.Start(c)
+		Ω(notifier.Watch(ctx, "w", "slow", "t")).To(Succeed())
+
+		connect("slow")
+		time.Sleep(100 * time.Millisecond) // notification of slow is blocked
+		connect("t")
+		Eventually(watcher.ChWritten).Should(Receive(Equal([]byte("w:t:true"))))
+
+		hub.Kick(ctx, "slow")
+		hub.Kick(ctx, "t")
+	})
+})
@@ -0,0 +1,86 @@
+package presence
+
+import (
+	"context"
+	"sync"
+)
+
+// WatchStore keeps watch lists, i.e. who watches presence of whom
+type WatchStore interface {
+	// Watch adds targets to watch list of watcher
+	Watch(ctx context.Context, watcher interface{}, targets ...interface{}) error
+	// Unwatch removes targets from watch list of watcher, all targets if none is given
+	Unwatch(ctx context.Context, watcher interface{}, targets ...interface{}) error
+	// Watchers returns watchers of target
+	Watchers(ctx context.Context, target interface{}) ([]interface{}, error)
+}
+
+// MemoryWatchStore is WatchStore in memory
+type MemoryWatchStore struct {
+	mu       sync.RWMutex
+	watching map[interface{}]map[interface{}]struct{} // watcher -> targets
+	watchers map[interface{}]map[interface{}]struct{} // target -> watchers
+}
+
+// NewMemoryWatchStore create memory watch store
+func NewMemoryWatchStore() *MemoryWatchStore {
+	return &MemoryWatchStore{
+		watching: make(map[interface{}]map[interface{}]struct{}),
+		watchers: make(map[interface{}]map[interface{}]struct{}),
+	}
+}
+
+// Watch implements WatchStore
+func (p *MemoryWatchStore) Watch(_ context.Context, watcher interface{}, targets ...interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, target := range targets {
+		add(p.watching, watcher, target)
+		add(p.watchers, target, watcher)
+	}
+	return nil
+}
+
+// Unwatch implements WatchStore
+func (p *MemoryWatchStore) Unwatch(_ context.Context, watcher interface{}, targets ...interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(targets) == 0 {
+		for target := range p.watching[watcher] {
+			targets = append(targets, target)
+		}
+	}
+	for _, target := range targets {
+		remove(p.watching, watcher, target)
+		remove(p.watchers, target, watcher)
+	}
+	return nil
+}
+
+// Watchers implements WatchStore
+func (p *MemoryWatchStore) Watchers(_ context.Context, target interface{}) ([]interface{}, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	l := make([]interface{}, 0, len(p.watchers[target]))
+	for watcher := range p.watchers[target] {
+		l = append(l, watcher)
+	}
+	return l, nil
+}
+
+func add(m map[interface{}]map[interface{}]struct{}, k, v interface{}) {
+	set := m[k]
+	if set == nil {
+		set = make(map[interface{}]struct{})
+		m[k] = set
+	}
+	set[v] = struct{}{}
+}
+
+func remove(m map[interface{}]map[interface{}]struct{}, k, v interface{}) {
+	set := m[k]
+	delete(set, v)
+	if len(set) == 0 {
+		delete(m, k)
+	}
+}
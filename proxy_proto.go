@@ -0,0 +1,195 @@
+/**
+ * HAProxy PROXY protocol v1/v2 support
+ */
+
+package tok
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	proxyV1MaxLen = 107 // max length of a v1 header line, including CRLF
+)
+
+var (
+	proxyV1Sig = []byte("PROXY ")
+	proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// ErrUntrustedProxy occurs while a PROXY protocol header is received from a source out of the trusted list
+var ErrUntrustedProxy = errors.New("tok: proxy header from untrusted source")
+
+// proxyHeader is the decoded PROXY protocol header
+type proxyHeader struct {
+	src net.Addr // real client address, nil for LOCAL/UNKNOWN headers
+}
+
+// readProxyHeader probes r for a PROXY protocol v1/v2 header.
+// Bytes are consumed one at a time while they match a signature, so no more than
+// the signature prefix is read from a stream without header.
+// If no header is present, hdr is nil and consumed holds the bytes to be replayed.
+func readProxyHeader(r io.Reader) (hdr *proxyHeader, consumed []byte, err error) {
+	var b [1]byte
+	buf := make([]byte, 0, len(proxyV2Sig))
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, buf, err
+		}
+		buf = append(buf, b[0])
+
+		v1 := bytes.HasPrefix(proxyV1Sig, buf)
+		v2 := bytes.HasPrefix(proxyV2Sig, buf)
+		switch {
+		case v1 && len(buf) == len(proxyV1Sig):
+			hdr, err := readProxyV1(r)
+			return hdr, nil, err
+		case v2 && len(buf) == len(proxyV2Sig):
+			hdr, err := readProxyV2(r)
+			return hdr, nil, err
+		case !v1 && !v2:
+			return nil, buf, nil
+		}
+	}
+}
+
+// readProxyV1 reads the rest of a v1 header line after the "PROXY " signature
+func readProxyV1(r io.Reader) (*proxyHeader, error) {
+	var b [1]byte
+	line := make([]byte, 0, proxyV1MaxLen)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line)+len(proxyV1Sig) >= proxyV1MaxLen {
+			return nil, errors.New("proxy v1 header too long")
+		}
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		line = append(line, b[0])
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if fields[0] == "UNKNOWN" {
+		return &proxyHeader{}, nil
+	}
+	if len(fields) != 5 || (fields[0] != "TCP4" && fields[0] != "TCP6") {
+		return nil, fmt.Errorf("malformed proxy v1 header: %q", line)
+	}
+
+	ip := net.ParseIP(fields[1])
+	if ip == nil {
+		return nil, fmt.Errorf("invalid proxy v1 source address: %q", fields[1])
+	}
+	port, err := strconv.ParseUint(fields[3], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy v1 source port: %w", err)
+	}
+	return &proxyHeader{src: &net.TCPAddr{IP: ip, Port: int(port)}}, nil
+}
+
+// readProxyV2 reads the rest of a v2 header after the 12 bytes signature
+func readProxyV2(r io.Reader) (*proxyHeader, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, err
+	}
+	if b[0]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported proxy v2 version %d", b[0]>>4)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(b[2:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	switch cmd := b[0] & 0x0F; cmd {
+	case 0x0: // LOCAL, e.g. health check from the proxy itself
+		return &proxyHeader{}, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("unsupported proxy v2 command %d", cmd)
+	}
+
+	// address block layout: src addr, dst addr, src port, dst port, TLVs are ignored
+	switch fam := b[1] >> 4; fam {
+	case 0x1: // AF_INET
+		if len(body) < 12 {
+			return nil, errors.New("short proxy v2 ipv4 address block")
+		}
+		return &proxyHeader{src: &net.TCPAddr{
+			IP:   net.IP(body[0:4]),
+			Port: int(binary.BigEndian.Uint16(body[8:10])),
+		}}, nil
+	case 0x2: // AF_INET6
+		if len(body) < 36 {
+			return nil, errors.New("short proxy v2 ipv6 address block")
+		}
+		return &proxyHeader{src: &net.TCPAddr{
+			IP:   net.IP(body[0:16]),
+			Port: int(binary.BigEndian.Uint16(body[32:34])),
+		}}, nil
+	default: // AF_UNSPEC, AF_UNIX carry no usable client address
+		return &proxyHeader{}, nil
+	}
+}
+
+// proxyConn replays bytes consumed while probing for PROXY header,
+// and reports the real client address as remote address
+type proxyConn struct {
+	net.Conn
+	r      io.Reader
+	remote net.Addr
+}
+
+func (p *proxyConn) Read(b []byte) (int, error) {
+	return p.r.Read(b)
+}
+
+func (p *proxyConn) RemoteAddr() net.Addr {
+	return p.remote
+}
+
+// acceptProxy parses optional PROXY header on conn.
+// Header from source out of trusted networks is rejected with ErrUntrustedProxy.
+func acceptProxy(conn net.Conn, trusted []*net.IPNet) (net.Conn, error) {
+	hdr, consumed, err := readProxyHeader(conn)
+	if err != nil {
+		return nil, fmt.Errorf("read proxy header err: %w", err)
+	}
+
+	pc := &proxyConn{
+		Conn:   conn,
+		r:      io.MultiReader(bytes.NewReader(consumed), conn),
+		remote: conn.RemoteAddr(),
+	}
+	if hdr == nil {
+		return pc, nil
+	}
+
+	if !ipTrusted(conn.RemoteAddr(), trusted) {
+		return nil, fmt.Errorf("%w: %s", ErrUntrustedProxy, conn.RemoteAddr())
+	}
+	if hdr.src != nil {
+		pc.remote = hdr.src
+	}
+	return pc, nil
+}
+
+func ipTrusted(addr net.Addr, trusted []*net.IPNet) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
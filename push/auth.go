@@ -0,0 +1,47 @@
+package push
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/quexer/tok/internal/httpapi"
+)
+
+// ErrUnauthorized is returned by authenticators for requests without valid credentials
+var ErrUnauthorized = errors.New("tok push: unauthorized")
+
+// Authenticator authenticates caller of request, and returns its name.
+// Idempotency keys are scoped by caller. Request is answered with 401 for ErrUnauthorized, and 403 for other errors.
+type Authenticator interface {
+	Authenticate(r *http.Request) (caller string, err error)
+}
+
+// AuthenticatorFunc adapts function to Authenticator
+type AuthenticatorFunc func(r *http.Request) (string, error)
+
+// Authenticate implements Authenticator
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (string, error) {
+	return f(r)
+}
+
+// BearerTokens returns Authenticator accepting requests with header "Authorization: Bearer <token>",
+// tokens maps token to caller name
+func BearerTokens(tokens map[string]string) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (string, error) {
+		got, ok := httpapi.BearerToken(r)
+		if !ok {
+			return "", ErrUnauthorized
+		}
+		caller, found := "", false
+		// compare with all tokens, so that time doesn't tell which one is close
+		for token, name := range tokens {
+			if httpapi.TokenEqual(got, token) {
+				caller, found = name, true
+			}
+		}
+		if !found {
+			return "", ErrUnauthorized
+		}
+		return caller, nil
+	})
+}
@@ -0,0 +1,97 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrInProgress is returned by IdempotencyStore.Begin while another request with the same key is running
+var ErrInProgress = errors.New("tok push: request with the same idempotency key is in progress")
+
+// Response is response saved for idempotency key
+type Response struct {
+	Status   int
+	Body     []byte
+	BodyHash string // hex SHA-256 of request body, a retry with different body is rejected
+}
+
+// IdempotencyStore keeps responses of requests by idempotency key, all fields of Response must be kept
+type IdempotencyStore interface {
+	// Begin reserves key for a new request and returns nil, or returns saved response of key.
+	// ErrInProgress is returned if key is reserved by a running request.
+	Begin(ctx context.Context, key string) (*Response, error)
+	// Done saves response of the request holding key
+	Done(ctx context.Context, key string, resp Response) error
+	// Release forgets key whose request failed, so that it can be retried
+	Release(ctx context.Context, key string) error
+}
+
+type idempotencyEntry struct {
+	resp   *Response // nil while in progress
+	expire time.Time
+}
+
+// MemoryIdempotencyStore is IdempotencyStore in memory
+type MemoryIdempotencyStore struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+}
+
+// NewMemoryIdempotencyStore create memory idempotency store keeping responses for ttl
+func NewMemoryIdempotencyStore(ttl time.Duration) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		ttl:       ttl,
+		entries:   make(map[string]*idempotencyEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// Begin implements IdempotencyStore
+func (p *MemoryIdempotencyStore) Begin(_ context.Context, key string) (*Response, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.sweep(now)
+	if e, ok := p.entries[key]; ok && now.Before(e.expire) {
+		if e.resp == nil {
+			return nil, ErrInProgress
+		}
+		return e.resp, nil
+	}
+	p.entries[key] = &idempotencyEntry{expire: now.Add(p.ttl)}
+	return nil, nil
+}
+
+// Done implements IdempotencyStore
+func (p *MemoryIdempotencyStore) Done(_ context.Context, key string, resp Response) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.entries[key] = &idempotencyEntry{resp: &resp, expire: time.Now().Add(p.ttl)}
+	return nil
+}
+
+// Release implements IdempotencyStore
+func (p *MemoryIdempotencyStore) Release(_ context.Context, key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.entries, key)
+	return nil
+}
+
+// sweep removes expired entries, at most once per ttl
+func (p *MemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < p.ttl {
+		return
+	}
+	p.lastSweep = now
+	for key, e := range p.entries {
+		if !now.Before(e.expire) {
+			delete(p.entries, key)
+		}
+	}
+}
@@ -0,0 +1,496 @@
+// Package push provides http.Handler for backend services pushing messages into a tok hub.
+//
+// Endpoints, relative to where the handler is mounted, all take and return JSON:
+//
+//	POST /send        Message                           send to one uid, answered with Result
+//	POST /send/batch  {"messages": [Message...]}        send to many uids, answered with Results
+//	POST /send/room   {"room", "payload", "base64", "ttl"}  send to members of room, see RoomResolver
+//	POST /kick        {"uid", "device"}                 kick all connections of uid, or the device only
+//
+// Outcome of each uid is reported by a stable code, see CodeXxx. Request level failures are answered with
+// non-2xx status and ErrorBody. Requests carrying header "Idempotency-Key" are answered only once per key,
+// retries get the saved response, see IdempotencyStore. Key is released instead if the request failed with 5xx,
+// or any uid got a transient code (timeout, slow_consumer, cache_failed, internal), so the request can be retried.
+// Reusing key for a different body is answered with 422.
+package push
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/internal/httpapi"
+)
+
+// Stable codes of results and errors
+const (
+	CodeOK           = "ok"            // sent, or cached for offline uid
+	CodeOffline      = "offline"       // uid is offline and ttl is 0, see tok.ErrOffline
+	CodeCacheFailed  = "cache_failed"  // caching for offline uid failed, see tok.ErrCacheFailed
+	CodeSlowConsumer = "slow_consumer" // write queue of uid is full, see tok.ErrSlowConsumer
+	CodeTimeout      = "timeout"       // hub didn't answer in time
+	CodeInternal     = "internal"      // other errors
+
+	CodeBadRequest     = "bad_request"     // request is malformed
+	CodeUnauthorized   = "unauthorized"    // caller is not authenticated
+	CodeForbidden      = "forbidden"       // caller is not allowed
+	CodeInProgress     = "in_progress"     // request with the same idempotency key is running
+	CodeKeyReused      = "key_reused"      // idempotency key was used for a different body
+	CodeRoomNotFound   = "room_not_found"  // room is unknown to RoomResolver
+	CodeNotImplemented = "not_implemented" // room send without RoomResolver
+)
+
+// ErrRoomNotFound is returned by RoomResolver for unknown room
+var ErrRoomNotFound = errors.New("tok push: room not found")
+
+// ErrorCode returns stable code of error returned by hub
+func ErrorCode(err error) string {
+	switch {
+	case err == nil:
+		return CodeOK
+	case errors.Is(err, tok.ErrCacheFailed):
+		return CodeCacheFailed
+	case errors.Is(err, tok.ErrOffline):
+		return CodeOffline
+	case errors.Is(err, tok.ErrSlowConsumer):
+		return CodeSlowConsumer
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return CodeTimeout
+	}
+	return CodeInternal
+}
+
+// RoomResolver resolves room to its member uids for room send
+type RoomResolver interface {
+	// Members returns uids of room, ErrRoomNotFound if room is unknown
+	Members(ctx context.Context, room string) ([]interface{}, error)
+}
+
+const (
+	defaultTimeout     = 10 * time.Second
+	defaultConcurrency = 32
+	defaultMaxBatch    = 1000
+	maxBodySize        = 4 << 20
+)
+
+// Option option of Handler
+type Option func(*Handler)
+
+// WithRoomResolver set resolver of room members, room send is not implemented without it
+func WithRoomResolver(rooms RoomResolver) Option {
+	return func(h *Handler) {
+		h.rooms = rooms
+	}
+}
+
+// WithIdempotencyStore set store of idempotency keys, default is memory store keeping responses for 24 hours.
+// nil disables idempotency keys.
+func WithIdempotencyStore(store IdempotencyStore) Option {
+	return func(h *Handler) {
+		h.idempotency = store
+	}
+}
+
+// WithUIDParser set parser of uid in requests, default keeps uid as string
+func WithUIDParser(parse func(s string) (interface{}, error)) Option {
+	return func(h *Handler) {
+		h.parseUID = parse
+	}
+}
+
+// WithTimeout set timeout of each request, default 10s
+func WithTimeout(d time.Duration) Option {
+	return func(h *Handler) {
+		h.timeout = d
+	}
+}
+
+// WithConcurrency set how many uids of a batch or room are sent in parallel, default 32
+func WithConcurrency(n int) Option {
+	return func(h *Handler) {
+		h.concurrency = max(n, 1)
+	}
+}
+
+// WithMaxBatch set upper limit of messages in a batch, default 1000
+func WithMaxBatch(n int) Option {
+	return func(h *Handler) {
+		h.maxBatch = n
+	}
+}
+
+// Handler is http.Handler pushing messages into hub
+type Handler struct {
+	hub         *tok.Hub
+	auth        Authenticator
+	rooms       RoomResolver
+	idempotency IdempotencyStore
+	parseUID    func(s string) (interface{}, error)
+	timeout     time.Duration
+	concurrency int
+	maxBatch    int
+	mux         *http.ServeMux
+}
+
+// New create push handler of hub, callers are authenticated by auth, nil auth denies all requests.
+// Mount it with http.StripPrefix if it's not served at root.
+func New(hub *tok.Hub, auth Authenticator, opts ...Option) *Handler {
+	h := &Handler{
+		hub:         hub,
+		auth:        auth,
+		idempotency: NewMemoryIdempotencyStore(24 * time.Hour),
+		parseUID:    httpapi.StringUID,
+		timeout:     defaultTimeout,
+		concurrency: defaultConcurrency,
+		maxBatch:    defaultMaxBatch,
+		mux:         http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	h.handle("POST /send", h.send)
+	h.handle("POST /send/batch", h.sendBatch)
+	h.handle("POST /send/room", h.sendRoom)
+	h.handle("POST /kick", h.kick)
+	return h
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Message is a message to uid, payload is base64 encoded if Base64 is true
+type Message struct {
+	UID     string `json:"uid"`
+	Payload string `json:"payload"`
+	Base64  bool   `json:"base64,omitempty"`
+	TTL     uint32 `json:"ttl,omitempty"`
+}
+
+// BatchRequest is body of batch send
+type BatchRequest struct {
+	Messages []Message `json:"messages"`
+}
+
+// RoomRequest is body of room send
+type RoomRequest struct {
+	Room    string `json:"room"`
+	Payload string `json:"payload"`
+	Base64  bool   `json:"base64,omitempty"`
+	TTL     uint32 `json:"ttl,omitempty"`
+}
+
+// KickRequest is body of kick, all connections of uid are kicked if device is empty
+type KickRequest struct {
+	UID    string `json:"uid"`
+	Device string `json:"device,omitempty"`
+}
+
+// Result is outcome of uid
+type Result struct {
+	UID   string `json:"uid"`
+	Code  string `json:"code"`            // one of CodeXxx
+	Error string `json:"error,omitempty"` // error message if code isn't CodeOK
+}
+
+// Results is outcome of batch or room send, in order of messages or members
+type Results struct {
+	Results []Result `json:"results"`
+}
+
+// ErrorBody is body of failed request
+type ErrorBody struct {
+	Code  string `json:"code"` // one of CodeXxx
+	Error string `json:"error"`
+}
+
+// endpoint handles authenticated request body, it returns status and response
+type endpoint func(ctx context.Context, body []byte) (int, interface{})
+
+// handle registers endpoint, wrapping it with authentication and idempotency
+func (h *Handler) handle(pattern string, ep endpoint) {
+	h.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		caller, err := h.authenticate(r)
+		if err != nil {
+			if errors.Is(err, ErrUnauthorized) {
+				writeResponse(w, errorResponse(http.StatusUnauthorized, CodeUnauthorized, err))
+			} else {
+				writeResponse(w, errorResponse(http.StatusForbidden, CodeForbidden, err))
+			}
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			writeResponse(w, errorResponse(http.StatusBadRequest, CodeBadRequest, err))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+		defer cancel()
+
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || h.idempotency == nil {
+			writeResponse(w, respond(ep(ctx, body)))
+			return
+		}
+
+		// keys of different callers and endpoints never clash
+		key = caller + "\x00" + r.URL.Path + "\x00" + key
+		sum := sha256.Sum256(body)
+		hash := hex.EncodeToString(sum[:])
+		saved, err := h.idempotency.Begin(ctx, key)
+		switch {
+		case errors.Is(err, ErrInProgress):
+			writeResponse(w, errorResponse(http.StatusConflict, CodeInProgress, err))
+			return
+		case err != nil:
+			writeResponse(w, errorResponse(http.StatusInternalServerError, CodeInternal, err))
+			return
+		case saved != nil && saved.BodyHash != hash:
+			writeResponse(w, errorResponse(http.StatusUnprocessableEntity, CodeKeyReused,
+				errors.New("idempotency key was used for a different body")))
+			return
+		case saved != nil:
+			w.Header().Set("Idempotent-Replayed", "true")
+			writeResponse(w, *saved)
+			return
+		}
+
+		status, v := ep(ctx, body)
+		resp := respond(status, v)
+		resp.BodyHash = hash
+		// save with fresh ctx, as request ctx might be done already
+		sctx, scancel := context.WithTimeout(context.Background(), h.timeout)
+		defer scancel()
+		if resp.Status >= http.StatusInternalServerError || !final(v) {
+			err = h.idempotency.Release(sctx, key)
+		} else {
+			err = h.idempotency.Done(sctx, key, resp)
+		}
+		if err != nil {
+			slog.Warn("[tok] push save idempotency key failed", "err", err, "caller", caller)
+		}
+		writeResponse(w, resp)
+	})
+}
+
+func (h *Handler) authenticate(r *http.Request) (string, error) {
+	if h.auth == nil {
+		return "", errors.New("no authenticator")
+	}
+	return h.auth.Authenticate(r)
+}
+
+func (h *Handler) send(ctx context.Context, body []byte) (int, interface{}) {
+	var msg Message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return badRequest(err)
+	}
+	t, err := h.target(msg.UID, msg.Payload, msg.Base64, msg.TTL)
+	if err != nil {
+		return badRequest(err)
+	}
+	return http.StatusOK, h.sendAll(ctx, []target{t})[0]
+}
+
+func (h *Handler) sendBatch(ctx context.Context, body []byte) (int, interface{}) {
+	var req BatchRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return badRequest(err)
+	}
+	if len(req.Messages) > h.maxBatch {
+		return badRequest(fmt.Errorf("too many messages, %d > %d", len(req.Messages), h.maxBatch))
+	}
+
+	// bad messages are reported in results, the others are still sent
+	targets := make([]target, len(req.Messages))
+	for i, msg := range req.Messages {
+		t, err := h.target(msg.UID, msg.Payload, msg.Base64, msg.TTL)
+		if err != nil {
+			t = target{name: msg.UID, err: err}
+		}
+		targets[i] = t
+	}
+	return http.StatusOK, Results{Results: h.sendAll(ctx, targets)}
+}
+
+func (h *Handler) sendRoom(ctx context.Context, body []byte) (int, interface{}) {
+	if h.rooms == nil {
+		return http.StatusNotImplemented, ErrorBody{Code: CodeNotImplemented, Error: "room resolver is not set"}
+	}
+	var req RoomRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return badRequest(err)
+	}
+	if req.Room == "" {
+		return badRequest(errors.New("room is required"))
+	}
+	payload, err := decodePayload(req.Payload, req.Base64)
+	if err != nil {
+		return badRequest(err)
+	}
+
+	members, err := h.rooms.Members(ctx, req.Room)
+	switch {
+	case errors.Is(err, ErrRoomNotFound):
+		return http.StatusNotFound, ErrorBody{Code: CodeRoomNotFound, Error: err.Error()}
+	case err != nil:
+		return http.StatusBadGateway, ErrorBody{Code: ErrorCode(err), Error: err.Error()}
+	}
+
+	targets := make([]target, len(members))
+	for i, uid := range members {
+		targets[i] = target{uid: uid, name: fmt.Sprint(uid), payload: payload, ttl: req.TTL}
+	}
+	return http.StatusOK, Results{Results: h.sendAll(ctx, targets)}
+}
+
+func (h *Handler) kick(ctx context.Context, body []byte) (int, interface{}) {
+	var req KickRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return badRequest(err)
+	}
+	uid, err := h.uid(req.UID)
+	if err != nil {
+		return badRequest(err)
+	}
+
+	if req.Device != "" {
+		return http.StatusOK, result(req.UID, h.hub.KickDevice(ctx, uid, req.Device))
+	}
+	if !h.hub.CheckOnline(ctx, uid) {
+		if err := ctx.Err(); err != nil {
+			return http.StatusOK, result(req.UID, err)
+		}
+		return http.StatusOK, result(req.UID, tok.ErrOffline)
+	}
+	h.hub.Kick(ctx, uid)
+	return http.StatusOK, result(req.UID, ctx.Err())
+}
+
+// target is a message ready to send
+type target struct {
+	uid     interface{}
+	name    string // uid in results
+	payload []byte
+	ttl     uint32
+	err     error // error of request, it's not sent
+}
+
+func (h *Handler) target(s, payload string, b64 bool, ttl uint32) (target, error) {
+	uid, err := h.uid(s)
+	if err != nil {
+		return target{}, err
+	}
+	b, err := decodePayload(payload, b64)
+	if err != nil {
+		return target{}, err
+	}
+	return target{uid: uid, name: s, payload: b, ttl: ttl}, nil
+}
+
+func (h *Handler) uid(s string) (interface{}, error) {
+	return httpapi.ParseUID(h.parseUID, s)
+}
+
+// sendAll sends targets in parallel, results are in order of targets
+func (h *Handler) sendAll(ctx context.Context, targets []target) []Result {
+	results := make([]Result, len(targets))
+	sem := make(chan struct{}, h.concurrency)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		if t.err != nil {
+			results[i] = Result{UID: t.name, Code: CodeBadRequest, Error: t.err.Error()}
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = result(t.name, h.hub.Send(ctx, t.uid, t.payload, t.ttl))
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// final reports whether response of endpoint is final, so that it's saved for idempotency key.
+// It's not if any uid got a transient code, which a retry might change.
+func final(v interface{}) bool {
+	switch v := v.(type) {
+	case Result:
+		return finalCode(v.Code)
+	case Results:
+		for _, r := range v.Results {
+			if !finalCode(r.Code) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func finalCode(code string) bool {
+	switch code {
+	case CodeTimeout, CodeSlowConsumer, CodeCacheFailed, CodeInternal:
+		return false
+	}
+	return true
+}
+
+func result(uid string, err error) Result {
+	r := Result{UID: uid, Code: ErrorCode(err)}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+func decodePayload(payload string, b64 bool) ([]byte, error) {
+	if !b64 {
+		return []byte(payload), nil
+	}
+	b, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("bad payload: %w", err)
+	}
+	return b, nil
+}
+
+func badRequest(err error) (int, interface{}) {
+	return http.StatusBadRequest, ErrorBody{Code: CodeBadRequest, Error: err.Error()}
+}
+
+func errorResponse(status int, code string, err error) Response {
+	return respond(status, ErrorBody{Code: code, Error: err.Error()})
+}
+
+// respond marshals response of endpoint
+func respond(status int, v interface{}) Response {
+	b, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		b, _ = json.Marshal(ErrorBody{Code: CodeInternal, Error: err.Error()})
+	}
+	return Response{Status: status, Body: b}
+}
+
+func writeResponse(w http.ResponseWriter, resp Response) {
+	httpapi.WriteBody(w, resp.Status, resp.Body)
+}
@@ -0,0 +1,214 @@
+package push_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/internal/testutil"
+	"github.com/quexer/tok/push"
+)
+
+// rooms is RoomResolver of fixed rooms
+type rooms map[string][]interface{}
+
+func (p rooms) Members(_ context.Context, room string) ([]interface{}, error) {
+	members, ok := p[room]
+	if !ok {
+		return nil, push.ErrRoomNotFound
+	}
+	return members, nil
+}
+
+var _ = Describe("Push", func() {
+	var (
+		hub     *tok.Hub
+		handler http.Handler
+	)
+
+	auth := push.BearerTokens(map[string]string{"t1": "svc1", "t2": "svc2"})
+
+	BeforeEach(func() {
+		hub = testutil.NewHub(ctl)
+		handler = push.New(hub, auth, push.WithRoomResolver(rooms{"r1": {"u1", "u2"}}))
+	})
+
+	// post serves request with token and optional idempotency key, and decodes json response into v
+	post := func(target, token, key, body string, v interface{}) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if v != nil {
+			Ω(json.Unmarshal(w.Body.Bytes(), v)).To(Succeed())
+		}
+		return w
+	}
+
+	It("should authenticate caller", func() {
+		var e push.ErrorBody
+		Ω(post("/send", "bad", "", `{}`, &e).Code).To(Equal(http.StatusUnauthorized))
+		Ω(e.Code).To(Equal(push.CodeUnauthorized))
+
+		handler = push.New(hub, push.AuthenticatorFunc(func(*http.Request) (string, error) { return "", io.EOF }))
+		Ω(post("/send", "t1", "", `{}`, &e).Code).To(Equal(http.StatusForbidden))
+		Ω(e.Code).To(Equal(push.CodeForbidden))
+
+		handler = push.New(hub, nil)
+		Ω(post("/send", "t1", "", `{}`, nil).Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should send and map errors to codes", func() {
+		adapter := testutil.Connect(ctx, hub, tok.CreateDevice("u1", ""))
+
+		var r push.Result
+		Ω(post("/send", "t1", "", `{"uid":"u1","payload":"hi"}`, &r).Code).To(Equal(http.StatusOK))
+		Ω(r).To(Equal(push.Result{UID: "u1", Code: push.CodeOK}))
+		Eventually(adapter.ChWritten).Should(Receive(Equal([]byte("hi"))))
+
+		Ω(post("/send", "t1", "", `{"uid":"u2","payload":"hi"}`, &r).Code).To(Equal(http.StatusOK))
+		Ω(r.Code).To(Equal(push.CodeOffline))
+		Ω(post("/send", "t1", "", `{"uid":"u2","payload":"hi","ttl":60}`, &r).Code).To(Equal(http.StatusOK))
+		Ω(r.Code).To(Equal(push.CodeCacheFailed))
+		Ω(r.Error).NotTo(BeEmpty())
+
+		var e push.ErrorBody
+		Ω(post("/send", "t1", "", `{"payload":"hi"}`, &e).Code).To(Equal(http.StatusBadRequest))
+		Ω(e.Code).To(Equal(push.CodeBadRequest))
+	})
+
+	It("should send batch with per-uid results", func() {
+		adapter := testutil.Connect(ctx, hub, tok.CreateDevice("u1", ""))
+
+		var rs push.Results
+		Ω(post("/send/batch", "t1", "", `{"messages":[
+			{"uid":"u1","payload":"AAE=","base64":true},
+			{"uid":"u2","payload":"hi"},
+			{"uid":"u1","payload":"!","base64":true}
+		]}`, &rs).Code).To(Equal(http.StatusOK))
+		Ω(rs.Results).To(HaveLen(3))
+		Ω(rs.Results[0]).To(Equal(push.Result{UID: "u1", Code: push.CodeOK}))
+		Ω(rs.Results[1].Code).To(Equal(push.CodeOffline))
+		Ω(rs.Results[2].Code).To(Equal(push.CodeBadRequest))
+		Eventually(adapter.ChWritten).Should(Receive(Equal([]byte{0, 1})))
+
+		handler = push.New(hub, auth, push.WithMaxBatch(1))
+		Ω(post("/send/batch", "t1", "", `{"messages":[{"uid":"u1"},{"uid":"u2"}]}`, nil).Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should send to room members", func() {
+		a1 := testutil.Connect(ctx, hub, tok.CreateDevice("u1", ""))
+		a2 := testutil.Connect(ctx, hub, tok.CreateDevice("u2", ""))
+
+		var rs push.Results
+		Ω(post("/send/room", "t1", "", `{"room":"r1","payload":"hi"}`, &rs).Code).To(Equal(http.StatusOK))
+		Ω(rs.Results).To(ConsistOf(
+			push.Result{UID: "u1", Code: push.CodeOK},
+			push.Result{UID: "u2", Code: push.CodeOK},
+		))
+		Eventually(a1.ChWritten).Should(Receive(Equal([]byte("hi"))))
+		Eventually(a2.ChWritten).Should(Receive(Equal([]byte("hi"))))
+
+		var e push.ErrorBody
+		Ω(post("/send/room", "t1", "", `{"room":"r2","payload":"hi"}`, &e).Code).To(Equal(http.StatusNotFound))
+		Ω(e.Code).To(Equal(push.CodeRoomNotFound))
+
+		handler = push.New(hub, auth)
+		Ω(post("/send/room", "t1", "", `{"room":"r1","payload":"hi"}`, &e).Code).To(Equal(http.StatusNotImplemented))
+		Ω(e.Code).To(Equal(push.CodeNotImplemented))
+	})
+
+	It("should kick uid or device", func() {
+		phone := testutil.Connect(ctx, hub, tok.CreateDevice("u1", "phone"))
+		tablet := testutil.Connect(ctx, hub, tok.CreateDevice("u1", "tablet"))
+
+		var r push.Result
+		post("/kick", "t1", "", `{"uid":"u1","device":"phone"}`, &r)
+		Ω(r.Code).To(Equal(push.CodeOK))
+		Eventually(phone.ChClosed).Should(BeClosed())
+
+		post("/kick", "t1", "", `{"uid":"u1","device":"phone"}`, &r)
+		Ω(r.Code).To(Equal(push.CodeOffline))
+
+		post("/kick", "t1", "", `{"uid":"u1"}`, &r)
+		Ω(r.Code).To(Equal(push.CodeOK))
+		Eventually(tablet.ChClosed).Should(BeClosed())
+		Eventually(func() bool { return hub.CheckOnline(ctx, "u1") }).Should(BeFalse())
+
+		post("/kick", "t1", "", `{"uid":"u1"}`, &r)
+		Ω(r.Code).To(Equal(push.CodeOffline))
+	})
+
+	It("should answer request once per idempotency key", func() {
+		adapter := testutil.Connect(ctx, hub, tok.CreateDevice("u1", ""))
+
+		w := post("/send", "t1", "k1", `{"uid":"u1","payload":"hi"}`, nil)
+		Ω(w.Code).To(Equal(http.StatusOK))
+		Eventually(adapter.ChWritten).Should(Receive(Equal([]byte("hi"))))
+
+		replay := post("/send", "t1", "k1", `{"uid":"u1","payload":"hi"}`, nil)
+		Ω(replay.Code).To(Equal(http.StatusOK))
+		Ω(replay.Header().Get("Idempotent-Replayed")).To(Equal("true"))
+		Ω(replay.Body.String()).To(Equal(w.Body.String()))
+		Consistently(adapter.ChWritten, "50ms").ShouldNot(Receive())
+
+		// keys are scoped by caller
+		Ω(post("/send", "t2", "k1", `{"uid":"u1","payload":"hi"}`, nil).Code).To(Equal(http.StatusOK))
+		Eventually(adapter.ChWritten).Should(Receive(Equal([]byte("hi"))))
+	})
+
+	It("should reject key reused for different body", func() {
+		testutil.Connect(ctx, hub, tok.CreateDevice("u1", ""))
+		Ω(post("/send", "t1", "k1", `{"uid":"u1","payload":"hi"}`, nil).Code).To(Equal(http.StatusOK))
+
+		var e push.ErrorBody
+		Ω(post("/send", "t1", "k1", `{"uid":"u1","payload":"bye"}`, &e).Code).To(Equal(http.StatusUnprocessableEntity))
+		Ω(e.Code).To(Equal(push.CodeKeyReused))
+	})
+
+	It("should release key while any uid result is transient", func() {
+		adapter := testutil.Connect(ctx, hub, tok.CreateDevice("u1", ""))
+
+		// caching fails without queue
+		body := `{"messages":[{"uid":"u1","payload":"hi"},{"uid":"u2","payload":"hi","ttl":60}]}`
+		var rs push.Results
+		Ω(post("/send/batch", "t1", "k1", body, &rs).Code).To(Equal(http.StatusOK))
+		Ω(rs.Results[1].Code).To(Equal(push.CodeCacheFailed))
+		Eventually(adapter.ChWritten).Should(Receive(Equal([]byte("hi"))))
+
+		retry := post("/send/batch", "t1", "k1", body, nil)
+		Ω(retry.Code).To(Equal(http.StatusOK))
+		Ω(retry.Header().Get("Idempotent-Replayed")).To(BeEmpty())
+		Eventually(adapter.ChWritten).Should(Receive(Equal([]byte("hi"))))
+
+		// final results are saved
+		Ω(post("/send", "t1", "k2", `{"uid":"u2","payload":"hi"}`, nil).Code).To(Equal(http.StatusOK))
+		Ω(post("/send", "t1", "k2", `{"uid":"u2","payload":"hi"}`, nil).Header().Get("Idempotent-Replayed")).To(Equal("true"))
+	})
+
+	It("should reject key in progress and expire keys", func() {
+		store := push.NewMemoryIdempotencyStore(50 * time.Millisecond)
+		Ω(store.Begin(ctx, "k")).To(BeNil())
+		_, err := store.Begin(ctx, "k")
+		Ω(err).To(MatchError(push.ErrInProgress))
+
+		Ω(store.Done(ctx, "k", push.Response{Status: 200, Body: []byte("{}")})).To(Succeed())
+		Ω(store.Begin(ctx, "k")).To(Equal(&push.Response{Status: 200, Body: []byte("{}")}))
+
+		Eventually(func() (*push.Response, error) { return store.Begin(ctx, "k") }).Should(BeNil())
+
+		Ω(store.Release(ctx, "k")).To(Succeed())
+		Ω(store.Begin(ctx, "k")).To(BeNil())
+	})
+})
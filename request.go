@@ -0,0 +1,201 @@
+/**
+ * request/response over connections
+ */
+
+package tok
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrRequestCodecRequired occurs while using request/response without RequestCodec, see WithHubConfigRequestCodec
+var ErrRequestCodecRequired = errors.New("tok: request codec is required")
+
+// ErrNotRequest occurs while replying to a message which is not a request
+var ErrNotRequest = errors.New("tok: not a request")
+
+// RequestFrame is a correlated message
+type RequestFrame struct {
+	ID      uint64 // correlation id, reply carries id of its request
+	Reply   bool   // true for reply, false for request
+	Payload []byte // payload
+}
+
+// BinaryRequestCodec is a RequestCodec framing correlated message as
+//
+//	0x00 'T' 'O' 'K' version(1 byte, 1) kind(1 byte, 'Q' for request, 'R' for reply) id(8 bytes, big-endian) payload
+//
+// Data without the complete header, including unknown version or kind, is plain message.
+type BinaryRequestCodec struct{}
+
+const (
+	binaryRequestVersion   = 1
+	binaryRequestHeaderLen = 14
+)
+
+// binaryRequestMagic leads header of BinaryRequestCodec
+var binaryRequestMagic = [4]byte{0x00, 'T', 'O', 'K'}
+
+// Encode implements RequestCodec
+func (BinaryRequestCodec) Encode(f RequestFrame) ([]byte, error) {
+	b := make([]byte, binaryRequestHeaderLen, binaryRequestHeaderLen+len(f.Payload))
+	copy(b, binaryRequestMagic[:])
+	b[4], b[5] = binaryRequestVersion, 'Q'
+	if f.Reply {
+		b[5] = 'R'
+	}
+	binary.BigEndian.PutUint64(b[6:], f.ID)
+	return append(b, f.Payload...), nil
+}
+
+// Decode implements RequestCodec, it never fails
+func (BinaryRequestCodec) Decode(data []byte) (RequestFrame, bool, error) {
+	if len(data) < binaryRequestHeaderLen || [4]byte(data[:4]) != binaryRequestMagic ||
+		data[4] != binaryRequestVersion || (data[5] != 'Q' && data[5] != 'R') {
+		return RequestFrame{}, false, nil
+	}
+	return RequestFrame{
+		ID:      binary.BigEndian.Uint64(data[6:]),
+		Reply:   data[5] == 'R',
+		Payload: data[binaryRequestHeaderLen:],
+	}, true, nil
+}
+
+type findFrame struct {
+	uid    interface{}      // user id
+	id     string           // device id
+	chConn chan *connection // channel to return connection, nil if not found
+}
+
+// pendingRequest waits for reply from conn
+type pendingRequest struct {
+	conn    *connection
+	chReply chan []byte
+}
+
+// inboundRequest is request from device, carried by handler context
+type inboundRequest struct {
+	conn *connection
+	id   uint64
+}
+
+// Request sends payload to device deviceID of uid as request, and waits for its reply.
+// RequestCodec is required, see WithHubConfigRequestCodec.
+// ErrOffline is returned if the device is offline, or it goes offline before reply.
+// If ctx is done before reply, ctx.Err() is returned.
+func (p *Hub) Request(ctx context.Context, uid interface{}, deviceID string, payload []byte) ([]byte, error) {
+	if p.config.requestCodec == nil {
+		return nil, ErrRequestCodecRequired
+	}
+
+	conn, err := p.findConn(ctx, uid, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	id := p.requestSeq.Add(1)
+	data, err := p.encodeRequest(conn, RequestFrame{ID: id, Payload: payload})
+	if err != nil {
+		return nil, err
+	}
+
+	req := &pendingRequest{conn: conn, chReply: make(chan []byte, 1)}
+	p.requests.Store(id, req)
+	defer p.requests.Delete(id)
+
+	if err := p.writeTo(conn.messageContext(false, ctx), conn, data, 0); err != nil {
+		return nil, err
+	}
+
+	select {
+	case b := <-req.chReply:
+		return b, nil
+	case <-conn.ctx.Done():
+		return nil, ErrOffline
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Reply sends payload as reply to the request being handled, ctx is the handler context passed to actor.
+// ErrNotRequest is returned if the message being handled is not a request, see RequestIDFromContext.
+func Reply(ctx context.Context, payload []byte) error {
+	req, ok := ctx.Value(ctxKeyRequest).(*inboundRequest)
+	if !ok {
+		return ErrNotRequest
+	}
+
+	hub := req.conn.hub
+	data, err := hub.encodeRequest(req.conn, RequestFrame{ID: req.id, Reply: true, Payload: payload})
+	if err != nil {
+		return err
+	}
+	return hub.writeTo(req.conn.messageContext(false, ctx), req.conn, data, 0)
+}
+
+// RequestIDFromContext returns correlation id of the request being handled, ok is false for plain message
+func RequestIDFromContext(ctx context.Context) (uint64, bool) {
+	req, ok := ctx.Value(ctxKeyRequest).(*inboundRequest)
+	if !ok {
+		return 0, false
+	}
+	return req.id, true
+}
+
+// findConn returns connection of device deviceID of uid, ErrOffline if not found
+func (p *Hub) findConn(ctx context.Context, uid interface{}, deviceID string) (*connection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ff := &findFrame{uid: uid, id: deviceID, chConn: make(chan *connection, 1)}
+	select {
+	case p.shard(uid).chFind <- ff:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case conn := <-ff.chConn:
+		if conn == nil {
+			return nil, ErrOffline
+		}
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// correlate decodes incoming data with RequestCodec, request is marked in returned ctx.
+// Reply is delivered to its pending request, ok is false then, as nothing is left for actor.
+func (p *Hub) correlate(ctx context.Context, conn *connection, data []byte) (context.Context, []byte, bool, error) {
+	f, ok, err := p.decodeRequest(conn, data)
+	if err != nil || !ok {
+		return ctx, data, err == nil, err
+	}
+
+	if !f.Reply {
+		return context.WithValue(ctx, ctxKeyRequest, &inboundRequest{conn: conn, id: f.ID}), f.Payload, true, nil
+	}
+
+	// reply from other connection is ignored, so that device can't answer requests of others
+	if v, found := p.requests.Load(f.ID); found && v.(*pendingRequest).conn == conn {
+		select {
+		case v.(*pendingRequest).chReply <- f.Payload:
+		default:
+			// replied already
+		}
+	}
+	return ctx, nil, false, nil
+}
+
+func (p *Hub) encodeRequest(conn *connection, f RequestFrame) (b []byte, err error) {
+	defer p.recoverHook("Encode", conn, &err)
+	return p.config.requestCodec.Encode(f)
+}
+
+func (p *Hub) decodeRequest(conn *connection, data []byte) (f RequestFrame, ok bool, err error) {
+	defer p.recoverHook("Decode", conn, &err)
+	return p.config.requestCodec.Decode(data)
+}
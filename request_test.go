@@ -0,0 +1,208 @@
+package tok_test
+
+import (
+	"context"
+	"io"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/mocks"
+)
+
+var _ = Describe("Request", func() {
+	var (
+		mockAdapter *mocks.MockConAdapter
+		mockActor   *mocks.MockActorWithResult
+		hub         *tok.Hub
+		device      *tok.Device
+		codec       tok.BinaryRequestCodec
+		chRead      chan []byte
+		chWritten   chan []byte
+		chClosed    chan struct{}
+	)
+
+	BeforeEach(func() {
+		mockAdapter = mocks.NewMockConAdapter(ctl)
+		mockActor = mocks.NewMockActorWithResult(ctl)
+		device = tok.CreateDevice("rpc-user", "phone")
+
+		read := make(chan []byte)
+		writes := make(chan []byte, 10)
+		closed := make(chan struct{})
+		mockAdapter.EXPECT().Read().DoAndReturn(func() ([]byte, error) {
+			select {
+			case b := <-read:
+				return b, nil
+			case <-closed:
+				return nil, io.EOF
+			}
+		}).AnyTimes()
+		mockAdapter.EXPECT().Close().DoAndReturn(func() error {
+			close(closed)
+			return nil
+		})
+		mockAdapter.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) error {
+			writes <- b
+			return nil
+		}).AnyTimes()
+		chRead, chWritten, chClosed = read, writes, closed
+	})
+
+	AfterEach(func() {
+		hub.Kick(ctx, "rpc-user")
+		Eventually(chClosed).Should(BeClosed())
+	})
+
+	// register creates hub with opts and registers the connection
+	register := func(opts ...tok.HubConfigOption) {
+		opts = append([]tok.HubConfigOption{
+			tok.WithHubConfigPingProducer(mocks.NewMockPingGenerator(ctl)),
+			tok.WithHubConfigQueue(nil),
+			tok.WithHubConfigRequestCodec(codec),
+		}, opts...)
+		config := tok.NewHubConfigWithResult(mockActor, opts...)
+		hub, _ = tok.CreateWsHandler(nil, tok.WithWsHandlerHubConfig(config))
+
+		go hub.RegisterConnection(ctx, device, mockAdapter)
+		Eventually(func() bool { return hub.CheckOnline(ctx, "rpc-user") }).Should(BeTrue())
+	}
+
+	// written decodes request frame written to the connection
+	written := func() tok.RequestFrame {
+		var b []byte
+		Eventually(chWritten).Should(Receive(&b))
+		f, ok, err := codec.Decode(b)
+		Ω(err).To(Succeed())
+		Ω(ok).To(BeTrue())
+		return f
+	}
+
+	encode := func(f tok.RequestFrame) []byte {
+		b, err := codec.Encode(f)
+		Ω(err).To(Succeed())
+		return b
+	}
+
+	It("should wait for reply of device", func() {
+		register()
+
+		chResult := make(chan []byte, 1)
+		go func() {
+			defer GinkgoRecover()
+			b, err := hub.Request(ctx, "rpc-user", "phone", []byte("ping?"))
+			Ω(err).To(Succeed())
+			chResult <- b
+		}()
+
+		f := written()
+		Ω(f.Reply).To(BeFalse())
+		Ω(f.Payload).To(Equal([]byte("ping?")))
+
+		chRead <- encode(tok.RequestFrame{ID: f.ID, Reply: true, Payload: []byte("pong")})
+		Eventually(chResult).Should(Receive(Equal([]byte("pong"))))
+	})
+
+	It("should fail without codec", func() {
+		register(tok.WithHubConfigRequestCodec(nil))
+		_, err := hub.Request(ctx, "rpc-user", "phone", []byte("ping?"))
+		Ω(err).To(MatchError(tok.ErrRequestCodecRequired))
+	})
+
+	It("should fail for offline device", func() {
+		register()
+		_, err := hub.Request(ctx, "rpc-user", "tablet", []byte("ping?"))
+		Ω(err).To(MatchError(tok.ErrOffline))
+		_, err = hub.Request(ctx, "nobody", "phone", []byte("ping?"))
+		Ω(err).To(MatchError(tok.ErrOffline))
+	})
+
+	It("should time out with ctx", func() {
+		register()
+		c, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		_, err := hub.Request(c, "rpc-user", "phone", []byte("ping?"))
+		Ω(err).To(MatchError(context.DeadlineExceeded))
+		written()
+	})
+
+	It("should fail while device goes offline", func() {
+		register()
+
+		chErr := make(chan error, 1)
+		go func() {
+			_, err := hub.Request(ctx, "rpc-user", "phone", []byte("ping?"))
+			chErr <- err
+		}()
+		written()
+
+		hub.Kick(ctx, "rpc-user")
+		Eventually(chErr).Should(Receive(MatchError(tok.ErrOffline)))
+	})
+
+	It("should let actor reply to request", func() {
+		mockActor.EXPECT().OnReceive(gomock.Any(), device, []byte("time?")).DoAndReturn(
+			func(ctx context.Context, _ *tok.Device, _ []byte) error {
+				id, ok := tok.RequestIDFromContext(ctx)
+				Ω(ok).To(BeTrue())
+				Ω(id).To(BeEquivalentTo(7))
+				return tok.Reply(ctx, []byte("noon"))
+			})
+		mockActor.EXPECT().OnReceive(gomock.Any(), device, []byte("plain")).DoAndReturn(
+			func(ctx context.Context, _ *tok.Device, _ []byte) error {
+				_, ok := tok.RequestIDFromContext(ctx)
+				Ω(ok).To(BeFalse())
+				Ω(tok.Reply(ctx, []byte("x"))).To(MatchError(tok.ErrNotRequest))
+				return nil
+			})
+		register()
+
+		chRead <- encode(tok.RequestFrame{ID: 7, Payload: []byte("time?")})
+		f := written()
+		Ω(f).To(Equal(tok.RequestFrame{ID: 7, Reply: true, Payload: []byte("noon")}))
+
+		chRead <- []byte("plain")
+		Consistently(chWritten, 50*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("should pass malformed frames to actor as plain messages", func() {
+		plain := [][]byte{
+			{0x00, 'T'},
+			{0x00, 'T', 'Q', 0, 0, 0, 0, 0, 0, 0, 7},
+			[]byte("\x00TOK\x01Q1234567"),         // short
+			[]byte("\x00TOK\x02Q12345678payload"), // unknown version
+			[]byte("\x00TOK\x01X12345678payload"), // unknown kind
+			encode(tok.RequestFrame{ID: 1})[:13],  // truncated id
+		}
+		chPlain := make(chan []byte, len(plain))
+		mockActor.EXPECT().OnReceive(gomock.Any(), device, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, _ *tok.Device, b []byte) error {
+				_, ok := tok.RequestIDFromContext(ctx)
+				Ω(ok).To(BeFalse())
+				chPlain <- b
+				return nil
+			}).Times(len(plain))
+		register()
+
+		for _, b := range plain {
+			chRead <- b
+		}
+		var got [][]byte
+		for range plain {
+			var b []byte
+			Eventually(chPlain).Should(Receive(&b))
+			got = append(got, b)
+		}
+		Ω(got).To(ConsistOf(plain))
+		Consistently(chWritten, 50*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("should not pass unmatched reply to actor", func() {
+		register()
+		chRead <- encode(tok.RequestFrame{ID: 12345, Reply: true, Payload: []byte("stray")})
+		Consistently(chWritten, 50*time.Millisecond).ShouldNot(Receive())
+	})
+})
@@ -0,0 +1,138 @@
+package tok_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/quexer/tok"
+)
+
+// writeSyscalls returns write syscalls of this process so far, -1 if unknown (non-linux)
+func writeSyscalls() int64 {
+	b, err := os.ReadFile("/proc/self/io")
+	if err != nil {
+		return -1
+	}
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		if v, ok := bytes.CutPrefix(line, []byte("syscw: ")); ok {
+			n, _ := strconv.ParseInt(string(v), 10, 64)
+			return n
+		}
+	}
+	return -1
+}
+
+// tcpPair returns a loopback tcp connection, whose peer is drained in background
+func tcpPair(b *testing.B) net.Conn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		peer, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = io.Copy(io.Discard, peer)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// benchWrite writes at least b.N messages of 128 bytes, batch messages at a time, reports syscalls per message written
+func benchWrite(b *testing.B, batch int) {
+	adapter := tok.NewTCPAdapter(tcpPair(b), tok.Uint32Framer)
+	data := make([][]byte, batch)
+	for i := range data {
+		data[i] = make([]byte, 128)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	calls := writeSyscalls()
+	written := 0 // whole batches are written, it's more than b.N if b.N isn't a multiple of batch
+	for written < b.N {
+		var err error
+		if batch == 1 {
+			err = adapter.Write(data[0])
+		} else {
+			err = adapter.(tok.BatchWriter).WriteBatch(data)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		written += batch
+	}
+	b.StopTimer()
+	if calls >= 0 {
+		b.ReportMetric(float64(writeSyscalls()-calls)/float64(written), "syscalls/msg")
+	}
+}
+
+// BenchmarkTCPWrite writes messages one by one, as synchronous Send does
+func BenchmarkTCPWrite(b *testing.B) { benchWrite(b, 1) }
+
+// BenchmarkTCPWriteBatch writes 64 messages with one writev, as write queue does under load
+func BenchmarkTCPWriteBatch(b *testing.B) { benchWrite(b, 64) }
+
+// BenchmarkTCPCopyWrite is the baseline, header and payload are copied into a new buffer per message
+func BenchmarkTCPCopyWrite(b *testing.B) {
+	conn := tcpPair(b)
+	data := make([]byte, 128)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	calls := writeSyscalls()
+	for i := 0; i < b.N; i++ {
+		_ = conn.SetWriteDeadline(time.Now().Add(time.Minute))
+		buf, _ := tok.Uint32Framer.AppendHeader(make([]byte, 0, 5+len(data)), data)
+		if _, err := conn.Write(append(buf, data...)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	if calls >= 0 {
+		b.ReportMetric(float64(writeSyscalls()-calls)/float64(b.N), "syscalls/msg")
+	}
+}
+
+// BenchmarkTCPRead reads pipelined messages, read buffers are borrowed from pool
+func BenchmarkTCPRead(b *testing.B) {
+	var frame []byte
+	frame, _ = tok.Uint32Framer.AppendHeader(frame, make([]byte, 128))
+	frame = append(frame, make([]byte, 128)...)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		w := bufio.NewWriter(client)
+		for {
+			if _, err := w.Write(frame); err != nil {
+				return
+			}
+		}
+	}()
+
+	adapter := tok.NewTCPAdapter(server, tok.Uint32Framer)
+	defer adapter.Close()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := adapter.Read(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
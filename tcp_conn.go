@@ -5,28 +5,54 @@
 package tok
 
 import (
-	"bytes"
+	"bufio"
 	"context"
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
 	"net"
+	"sync"
 	"time"
 )
 
-const (
-	tcpHeaderLen = 4
-)
-
 var (
-	// TCPMaxPackLen upper limit for single message
+	// TCPMaxPackLen default upper limit for single message, used while max frame length is not set on listener.
+	//
+	// Deprecated: use WithTCPListenerMaxFrameLen instead.
 	TCPMaxPackLen uint32 = 4 * 1024 * 1024
 )
 
+// readerPool holds buffered readers of tcp connections, they are borrowed only while frames are being read,
+// so idle connections hold no read buffer
+var readerPool = sync.Pool{
+	New: func() any { return bufio.NewReader(nil) },
+}
+
+// writeBufPool holds buffers for coalesced and vectored writes
+var writeBufPool = sync.Pool{
+	New: func() any { return &writeBuf{buf: make([]byte, 0, coalesceLimit)} },
+}
+
+// coalesceLimit is the upper limit of bytes copied into one buffer for a single write, larger writes are vectored
+const coalesceLimit = 4096
+
+// writeBuf is a reusable write, small frames are copied into buf, while large ones are referenced by bufs
+// with their headers in buf
+type writeBuf struct {
+	buf  []byte
+	bufs net.Buffers
+	out  net.Buffers // consumed copy of bufs
+}
+
 type tcpAdapter struct {
 	conn         net.Conn
+	raw          net.Conn      // underlying conn of conn, written directly so that net.Buffers uses writev
+	br           *bufio.Reader // buffered reader from readerPool, framer needs it to peek/scan. nil while idle
+	src          leadingByteReader
+	framer       Framer // framing codec of tcp stream
+	maxFrameLen  uint32 // upper limit of single inbound message
 	readTimeout  time.Duration
 	writeTimeout time.Duration
 }
@@ -42,52 +68,86 @@ func (p *tcpAdapter) Read() ([]byte, error) {
 		return nil, fmt.Errorf("setting read deadline error: %w", err)
 	}
 
-	// read header
-	b := make([]byte, tcpHeaderLen)
-	if _, err := io.ReadFull(p.conn, b); err != nil {
-		return nil, err
-	}
-
-	buf := bytes.NewBuffer(b)
-	var n uint32
-	if err := binary.Read(buf, binary.BigEndian, &n); err != nil {
-		return nil, err
-	}
-
-	if n > TCPMaxPackLen {
-		return nil, fmt.Errorf("pack length %dM can't greater than %dM", n/1024/1024, TCPMaxPackLen/1024/1024)
+	if p.br == nil {
+		// wait for the first byte without buffer, then borrow one for the frame
+		if _, err := io.ReadFull(p.conn, p.src.b[:]); err != nil {
+			return nil, err
+		}
+		p.src.pending = true
+		p.src.r = p.conn
+		p.br = readerPool.Get().(*bufio.Reader)
+		p.br.Reset(&p.src)
 	}
 
-	if p.readTimeout > 0 {
-		deadline = time.Now().Add(p.readTimeout)
-	} else {
-		deadline = time.Time{}
-	}
-	if err := p.conn.SetReadDeadline(deadline); err != nil {
-		return nil, fmt.Errorf("setting read deadline err: %w", err)
+	b, err := p.framer.ReadFrame(p.br, p.maxFrameLen)
+	if err != nil || p.br.Buffered() == 0 {
+		// nothing pipelined, give the buffer back
+		p.br.Reset(nil)
+		readerPool.Put(p.br)
+		p.br = nil
+		p.src.r = nil
 	}
-
-	b = make([]byte, n)
-	_, err := io.ReadFull(p.conn, b)
 	return b, err
-
 }
 
 func (p *tcpAdapter) Write(b []byte) error {
+	return p.WriteBatch([][]byte{b})
+}
+
+// WriteBatch implements BatchWriter. Small frames are copied and written together,
+// large ones are written with one writev syscall.
+func (p *tcpAdapter) WriteBatch(data [][]byte) error {
 	// set write deadline
 	if err := p.conn.SetWriteDeadline(time.Now().Add(p.writeTimeout)); err != nil {
 		return fmt.Errorf("setting write deadline err: %w", err)
 	}
 
-	n := uint32(len(b))
+	wb := writeBufPool.Get().(*writeBuf)
+	defer func() {
+		clear(wb.bufs[:cap(wb.bufs)])
+		wb.bufs, wb.out = wb.bufs[:0], nil
+		if cap(wb.buf) <= 64*coalesceLimit {
+			wb.buf = wb.buf[:0]
+			writeBufPool.Put(wb)
+		}
+	}()
 
-	buf := new(bytes.Buffer)
+	trailer := p.framer.Trailer()
+	size := 0
+	for _, b := range data {
+		size += len(b) + len(trailer)
+	}
 
-	if err := binary.Write(buf, binary.BigEndian, &n); err != nil {
+	if size <= coalesceLimit {
+		for _, b := range data {
+			buf, err := p.framer.AppendHeader(wb.buf, b)
+			if err != nil {
+				return err
+			}
+			buf = append(buf, b...)
+			wb.buf = append(buf, trailer...)
+		}
+		_, err := p.raw.Write(wb.buf)
 		return err
 	}
-	_, err := p.conn.Write(append(buf.Bytes(), b...))
 
+	for _, b := range data {
+		// header slices stay valid even if buf grows, they keep the old array
+		start := len(wb.buf)
+		buf, err := p.framer.AppendHeader(wb.buf, b)
+		if err != nil {
+			return err
+		}
+		wb.buf = buf
+		wb.bufs = append(wb.bufs, buf[start:len(buf):len(buf)], b)
+		if len(trailer) > 0 {
+			wb.bufs = append(wb.bufs, trailer)
+		}
+	}
+
+	// WriteTo consumes the buffers, keep wb.bufs for reuse
+	wb.out = wb.bufs
+	_, err := wb.out.WriteTo(p.raw)
 	return err
 }
 
@@ -103,12 +163,49 @@ func (p *tcpAdapter) ShareConn(adapter ConAdapter) bool {
 	return p.conn == tcpAdp.conn
 }
 
+func (p *tcpAdapter) transport() string {
+	return TransportTCP
+}
+
+// leadingByteReader replays a byte read ahead before reading r
+type leadingByteReader struct {
+	b       [1]byte
+	pending bool
+	r       io.Reader
+}
+
+func (p *leadingByteReader) Read(buf []byte) (int, error) {
+	if p.pending {
+		if len(buf) == 0 {
+			return 0, nil
+		}
+		buf[0] = p.b[0]
+		p.pending = false
+		return 1, nil
+	}
+	return p.r.Read(buf)
+}
+
+// tcpListener accepts tcp connections and registers them to hub after auth
+type tcpListener struct {
+	hub            *Hub
+	auth           TCPAuthFunc     // auth function is used for user authorization
+	addrAuth       TCPAddrAuthFunc // auth function with remote address, it replaces auth if set
+	proxyProtocol  bool            // parse PROXY protocol header before auth frame
+	trustedCIDRs   []string        // networks allowed to send PROXY protocol header
+	trusted        []*net.IPNet    // parsed trustedCIDRs
+	framer         Framer          // framing codec, default is Uint32Framer
+	maxFrameLen    uint32          // upper limit for single inbound message, default is TCPMaxPackLen
+	netpollWorkers int             // workers of netpoller, 0 means netpoll is disabled
+	poller         *netpoller      // reads connections if netpoll is enabled
+}
+
 // Listen create Tcp listener with hub.
 // If config is not nil, a new hub will be created and replace the old one.
 // addr is the tcp address to be listened on.
 // auth function is used for user authorization
 // return error if listen failed.
-func Listen(hub *Hub, config *HubConfig, addr string, auth TCPAuthFunc) (*Hub, error) {
+func Listen(hub *Hub, config *HubConfig, addr string, auth TCPAuthFunc, opts ...TCPListenerOption) (*Hub, error) {
 	if config != nil {
 		hub = createHub(config)
 	}
@@ -117,46 +214,41 @@ func Listen(hub *Hub, config *HubConfig, addr string, auth TCPAuthFunc) (*Hub, e
 		log.Fatal("hub is needed")
 	}
 
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		return nil, err
+	l := &tcpListener{
+		hub:         hub,
+		auth:        auth,
+		framer:      Uint32Framer,
+		maxFrameLen: TCPMaxPackLen,
 	}
 
-	initAuth := func(conn net.Conn) {
-		slog.Debug("raw tcp connection", "addr", conn.RemoteAddr())
-		if err := conn.SetReadDeadline(time.Now().Add(config.authTimeout)); err != nil {
-			slog.Warn("set auth deadline err", "err", err)
-			_ = conn.Close()
-			return
-		}
+	for _, opt := range opts {
+		opt(l)
+	}
 
-		// set auth timeout at auth stage
-		adapter := &tcpAdapter{
-			conn:         conn,
-			readTimeout:  config.authTimeout,
-			writeTimeout: config.writeTimeout,
+	if l.proxyProtocol {
+		if len(l.trustedCIDRs) == 0 {
+			return nil, errors.New("trusted cidr is needed for proxy protocol")
 		}
-		b, err := adapter.Read()
-		if err != nil {
-			slog.Warn("tcp auth, read err", "err", err)
-			_ = adapter.Close()
-			return
+		for _, s := range l.trustedCIDRs {
+			_, n, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, fmt.Errorf("parse trusted cidr err: %w", err)
+			}
+			l.trusted = append(l.trusted, n)
 		}
+	}
 
-		dv, err := auth(b)
+	if l.netpollWorkers > 0 {
+		poller, err := newNetpoller(l.netpollWorkers)
 		if err != nil {
-			slog.Warn("tcp auth, auth err", "err", err)
-			_ = adapter.Close()
-			return
-		}
-
-		if config.readTimeout > 0 {
-			adapter.readTimeout = config.readTimeout
-		} else {
-			adapter.readTimeout = 0
+			slog.Warn("[tok] netpoll disabled, fallback to goroutine per connection", "err", err)
 		}
+		l.poller = poller
+	}
 
-		hub.RegisterConnection(context.Background(), dv, adapter)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
 	}
 
 	go func() {
@@ -167,13 +259,89 @@ func Listen(hub *Hub, config *HubConfig, addr string, auth TCPAuthFunc) (*Hub, e
 				continue
 			}
 
-			go initAuth(conn)
+			go l.initAuth(conn)
 		}
 	}()
 
 	return hub, nil
 }
 
+func (l *tcpListener) initAuth(conn net.Conn) {
+	config := l.hub.config
+
+	slog.Debug("raw tcp connection", "addr", conn.RemoteAddr())
+	if err := conn.SetReadDeadline(time.Now().Add(config.authTimeout)); err != nil {
+		slog.Warn("set auth deadline err", "err", err)
+		_ = conn.Close()
+		return
+	}
+
+	raw := conn
+	if l.proxyProtocol {
+		pc, err := acceptProxy(conn, l.trusted)
+		if err != nil {
+			slog.Warn("tcp proxy protocol err", "err", err, "addr", conn.RemoteAddr())
+			_ = conn.Close()
+			return
+		}
+		conn = pc
+	}
+
+	// set auth timeout at auth stage
+	adapter := &tcpAdapter{
+		conn:         conn,
+		raw:          raw,
+		framer:       l.framer,
+		maxFrameLen:  l.maxFrameLen,
+		readTimeout:  config.authTimeout,
+		writeTimeout: config.writeTimeout,
+	}
+	b, err := adapter.Read()
+	if err != nil {
+		slog.Warn("tcp auth, read err", "err", err)
+		_ = adapter.Close()
+		return
+	}
+
+	dv, err := l.authenticate(conn.RemoteAddr(), b)
+	if err != nil {
+		slog.Warn("tcp auth, auth err", "err", err)
+		_ = adapter.Close()
+		return
+	}
+	dv.setRemoteAddr(conn.RemoteAddr())
+
+	if config.readTimeout > 0 {
+		adapter.readTimeout = config.readTimeout
+	} else {
+		adapter.readTimeout = 0
+	}
+
+	if l.poller != nil {
+		// a started frame must not hold worker forever
+		if adapter.readTimeout == 0 {
+			adapter.readTimeout = config.authTimeout
+		}
+		l.poller.register(l.hub, dv, adapter)
+		return
+	}
+
+	l.hub.RegisterConnection(context.Background(), dv, adapter)
+}
+
+// authenticate calls auth function, panic is reported to PanicHandler
+func (l *tcpListener) authenticate(addr net.Addr, b []byte) (dv *Device, err error) {
+	defer l.hub.recoverHook("Auth", nil, &err)
+	if l.addrAuth != nil {
+		return l.addrAuth(addr, b)
+	}
+	return l.auth(b)
+}
+
 // TCPAuthFunc tcp auth function
 // parameter is the first package content of connection. return Device interface
 type TCPAuthFunc func([]byte) (*Device, error)
+
+// TCPAddrAuthFunc is TCPAuthFunc with remote address of connection, e.g. to allow or deny by client ip.
+// addr is the client address carried by PROXY header if PROXY protocol is enabled, see WithTCPListenerAddrAuth.
+type TCPAddrAuthFunc func(addr net.Addr, b []byte) (*Device, error)
@@ -0,0 +1,467 @@
+package tok_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"runtime"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/mocks"
+)
+
+// freeAddr returns a local tcp address which is free to listen on
+func freeAddr() string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	Ω(err).To(Succeed())
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// writeFrame writes a 4-byte big-endian length prefixed frame
+func writeFrame(conn net.Conn, b []byte) {
+	hdr := make([]byte, 4)
+	binary.BigEndian.PutUint32(hdr, uint32(len(b)))
+	_, err := conn.Write(append(hdr, b...))
+	Ω(err).To(Succeed())
+}
+
+var _ = Describe("TcpConn", func() {
+	var (
+		addr   string
+		chDv   chan *tok.Device
+		auth   tok.TCPAuthFunc
+		config *tok.HubConfig
+	)
+
+	BeforeEach(func() {
+		addr = freeAddr()
+		chDv = make(chan *tok.Device, 1)
+		auth = func(b []byte) (*tok.Device, error) {
+			dv := tok.CreateDevice(string(b), "")
+			chDv <- dv
+			return dv, nil
+		}
+		config = tok.NewHubConfig(mocks.NewMockActor(ctl),
+			tok.WithHubConfigPingProducer(mocks.NewMockPingGenerator(ctl)))
+	})
+
+	Describe("PROXY protocol", func() {
+		var chAddr chan net.Addr
+
+		// addrAuth records address seen by auth function
+		addrAuth := tok.WithTCPListenerAddrAuth(func(addr net.Addr, b []byte) (*tok.Device, error) {
+			chAddr <- addr
+			return tok.CreateDevice(string(b), ""), nil
+		})
+
+		BeforeEach(func() {
+			chAddr = make(chan net.Addr, 1)
+		})
+
+		dial := func(header []byte) net.Conn {
+			conn, err := net.Dial("tcp", addr)
+			Ω(err).To(Succeed())
+			_, err = conn.Write(header)
+			Ω(err).To(Succeed())
+			writeFrame(conn, []byte("u1"))
+			return conn
+		}
+
+		// expectAddr checks address seen by auth function, and device address after registration
+		expectAddr := func(hub *tok.Hub, want string) {
+			var got net.Addr
+			Eventually(chAddr).Should(Receive(&got))
+			Ω(got.String()).To(Equal(want))
+			Eventually(func() []tok.ConnInfo { return hub.Devices(ctx, "u1") }).Should(ConsistOf(HaveField("RemoteAddr", want)))
+		}
+
+		It("should require trusted cidr", func() {
+			_, err := tok.Listen(nil, config, addr, auth, tok.WithTCPListenerProxyProtocol())
+			Ω(err).To(HaveOccurred())
+		})
+
+		It("should keep peer address without header", func() {
+			hub, err := tok.Listen(nil, config, addr, nil, addrAuth, tok.WithTCPListenerProxyProtocol("127.0.0.0/8"))
+			Ω(err).To(Succeed())
+
+			conn := dial(nil)
+			defer conn.Close()
+			expectAddr(hub, conn.LocalAddr().String())
+		})
+
+		It("should use client address of v1 header", func() {
+			hub, err := tok.Listen(nil, config, addr, nil, addrAuth, tok.WithTCPListenerProxyProtocol("127.0.0.0/8"))
+			Ω(err).To(Succeed())
+
+			conn := dial([]byte("PROXY TCP4 192.168.1.2 10.0.0.1 56324 443\r\n"))
+			defer conn.Close()
+			expectAddr(hub, "192.168.1.2:56324")
+		})
+
+		It("should use client address of v2 header", func() {
+			hub, err := tok.Listen(nil, config, addr, nil, addrAuth, tok.WithTCPListenerProxyProtocol("127.0.0.0/8"))
+			Ω(err).To(Succeed())
+
+			header := []byte("\r\n\r\n\x00\r\nQUIT\n")
+			header = append(header, 0x21, 0x11, 0x00, 0x0C) // v2 PROXY, TCP over IPv4, 12 bytes
+			header = append(header, 192, 168, 1, 2, 10, 0, 0, 1)
+			header = binary.BigEndian.AppendUint16(header, 56324)
+			header = binary.BigEndian.AppendUint16(header, 443)
+			conn := dial(header)
+			defer conn.Close()
+			expectAddr(hub, "192.168.1.2:56324")
+		})
+
+		It("should reject header from untrusted source", func() {
+			_, err := tok.Listen(nil, config, addr, nil, addrAuth, tok.WithTCPListenerProxyProtocol("10.0.0.0/8"))
+			Ω(err).To(Succeed())
+
+			conn := dial([]byte("PROXY TCP4 192.168.1.2 10.0.0.1 56324 443\r\n"))
+			defer conn.Close()
+
+			Consistently(chAddr, 100*time.Millisecond).ShouldNot(Receive())
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = conn.Read(make([]byte, 1))
+			Ω(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Framer", func() {
+		It("should exchange messages with listener framer", func() {
+			mActor := mocks.NewMockActor(ctl)
+			chData := make(chan []byte, 1)
+			mActor.EXPECT().OnReceive(gomock.Any(), gomock.Any()).Do(func(_ *tok.Device, data []byte) {
+				chData <- data
+			})
+			config = tok.NewHubConfig(mActor,
+				tok.WithHubConfigPingProducer(mocks.NewMockPingGenerator(ctl)))
+
+			hub, err := tok.Listen(nil, config, addr, auth,
+				tok.WithTCPListenerFramer(tok.LineFramer),
+				tok.WithTCPListenerMaxFrameLen(16))
+			Ω(err).To(Succeed())
+
+			conn, err := net.Dial("tcp", addr)
+			Ω(err).To(Succeed())
+			defer conn.Close()
+
+			_, err = conn.Write([]byte("u1\n{\"a\":1}\n"))
+			Ω(err).To(Succeed())
+			Eventually(chDv).Should(Receive())
+			Eventually(chData).Should(Receive(Equal([]byte(`{"a":1}`))))
+			Eventually(func() bool { return hub.CheckOnline(ctx, "u1") }).Should(BeTrue())
+
+			Ω(hub.Send(ctx, "u1", []byte("hi"), 0)).To(Succeed())
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			Ω(err).To(Succeed())
+			Ω(line).To(Equal("hi\n"))
+
+			// frame longer than max length breaks the connection
+			_, err = conn.Write([]byte("01234567890123456789\n"))
+			Ω(err).To(Succeed())
+			Eventually(func() bool { return hub.CheckOnline(ctx, "u1") }).Should(BeFalse())
+		})
+	})
+	Describe("Panic", func() {
+		It("should recover auth panic, and close the socket", func() {
+			mockPanic := mocks.NewMockPanicHandler(ctl)
+			mockPanic.EXPECT().OnPanic(nil, "Auth", "auth", gomock.Any())
+			config = tok.NewHubConfig(mocks.NewMockActor(ctl),
+				tok.WithHubConfigPingProducer(mocks.NewMockPingGenerator(ctl)),
+				tok.WithHubConfigPanicHandler(mockPanic, true))
+			auth = func([]byte) (*tok.Device, error) {
+				panic("auth")
+			}
+			_, err := tok.Listen(nil, config, addr, auth)
+			Ω(err).To(Succeed())
+
+			conn, err := net.Dial("tcp", addr)
+			Ω(err).To(Succeed())
+			defer conn.Close()
+			writeFrame(conn, []byte("u1"))
+
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = conn.Read(make([]byte, 1))
+			Ω(err).To(MatchError(io.EOF))
+		})
+	})
+
+	Describe("Batching", func() {
+		var (
+			hub    *tok.Hub
+			chData chan []byte
+			conn   net.Conn
+		)
+
+		BeforeEach(func() {
+			mActor := mocks.NewMockActor(ctl)
+			ch := make(chan []byte, 100)
+			chData = ch
+			mActor.EXPECT().OnReceive(gomock.Any(), gomock.Any()).Do(func(_ *tok.Device, data []byte) {
+				ch <- data
+			}).AnyTimes()
+			config = tok.NewHubConfig(mActor,
+				tok.WithHubConfigPingProducer(mocks.NewMockPingGenerator(ctl)),
+				tok.WithHubConfigWriteQueue(1000, tok.SlowConsumerDisconnect))
+
+			var err error
+			hub, err = tok.Listen(nil, config, addr, auth)
+			Ω(err).To(Succeed())
+
+			conn, err = net.Dial("tcp", addr)
+			Ω(err).To(Succeed())
+			writeFrame(conn, []byte("u1"))
+			Eventually(func() bool { return hub.CheckOnline(ctx, "u1") }).Should(BeTrue())
+		})
+
+		AfterEach(func() {
+			_ = conn.Close()
+		})
+
+		It("should read pipelined frames", func() {
+			var buf []byte
+			for i := 0; i < 50; i++ {
+				buf = binary.BigEndian.AppendUint32(buf, 1)
+				buf = append(buf, byte(i))
+			}
+			_, err := conn.Write(buf)
+			Ω(err).To(Succeed())
+
+			// actor is called concurrently, order is not kept
+			got := map[byte]bool{}
+			for i := 0; i < 50; i++ {
+				var b []byte
+				Eventually(chData).Should(Receive(&b))
+				got[b[0]] = true
+			}
+			Ω(got).To(HaveLen(50))
+		})
+
+		It("should write queued frames in order", func() {
+			for i := 0; i < 500; i++ {
+				Ω(hub.Send(ctx, "u1", binary.BigEndian.AppendUint32(nil, uint32(i)), 0)).To(Succeed())
+			}
+
+			br := bufio.NewReader(conn)
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			for i := 0; i < 500; i++ {
+				b, err := tok.Uint32Framer.ReadFrame(br, 4)
+				Ω(err).To(Succeed())
+				Ω(binary.BigEndian.Uint32(b)).To(BeEquivalentTo(i))
+			}
+		})
+	})
+	Describe("Netpoll", func() {
+		var (
+			hub     *tok.Hub
+			chData  chan []byte
+			mClose  *mocks.MockCloseHandler
+			hubOpts []tok.HubConfigOption // extra options of hub config
+		)
+
+		BeforeEach(func() {
+			hubOpts = nil
+		})
+
+		JustBeforeEach(func() {
+			if runtime.GOOS != "linux" {
+				Skip("netpoll is linux only")
+			}
+
+			mActor := mocks.NewMockActor(ctl)
+			ch := make(chan []byte, 100)
+			chData = ch
+			mActor.EXPECT().OnReceive(gomock.Any(), gomock.Any()).Do(func(_ *tok.Device, data []byte) {
+				ch <- data
+			}).AnyTimes()
+			mClose = mocks.NewMockCloseHandler(ctl)
+			mClose.EXPECT().OnClose(gomock.Any()).AnyTimes()
+			config = tok.NewHubConfig(mActor, append([]tok.HubConfigOption{
+				tok.WithHubConfigPingProducer(mocks.NewMockPingGenerator(ctl)),
+				tok.WithHubConfigCloseHandler(mClose)}, hubOpts...)...)
+
+			auth = func(b []byte) (*tok.Device, error) {
+				return tok.CreateDevice(string(b), ""), nil
+			}
+			var err error
+			hub, err = tok.Listen(nil, config, addr, auth, tok.WithTCPListenerNetpoll(2))
+			Ω(err).To(Succeed())
+		})
+
+		dial := func(uid string) net.Conn {
+			conn, err := net.Dial("tcp", addr)
+			Ω(err).To(Succeed())
+			writeFrame(conn, []byte(uid))
+			Eventually(func() bool { return hub.CheckOnline(ctx, uid) }).Should(BeTrue())
+			return conn
+		}
+
+		It("should exchange messages", func() {
+			conn := dial("u1")
+			defer conn.Close()
+
+			writeFrame(conn, []byte("hello"))
+			Eventually(chData).Should(Receive(Equal([]byte("hello"))))
+			writeFrame(conn, []byte("again"))
+			Eventually(chData).Should(Receive(Equal([]byte("again"))))
+
+			Ω(hub.Send(ctx, "u1", []byte("world"), 0)).To(Succeed())
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			b, err := tok.Uint32Framer.ReadFrame(bufio.NewReader(conn), 16)
+			Ω(err).To(Succeed())
+			Ω(b).To(Equal([]byte("world")))
+		})
+
+		It("should read frames pipelined with auth frame", func() {
+			conn, err := net.Dial("tcp", addr)
+			Ω(err).To(Succeed())
+			defer conn.Close()
+
+			var buf []byte
+			for _, s := range []string{"u1", "a", "b"} {
+				buf = binary.BigEndian.AppendUint32(buf, uint32(len(s)))
+				buf = append(buf, s...)
+			}
+			_, err = conn.Write(buf)
+			Ω(err).To(Succeed())
+
+			var got []string
+			for i := 0; i < 2; i++ {
+				var b []byte
+				Eventually(chData).Should(Receive(&b))
+				got = append(got, string(b))
+			}
+			Ω(got).To(ConsistOf("a", "b"))
+
+			// rearmed after buffered frames
+			writeFrame(conn, []byte("c"))
+			Eventually(chData).Should(Receive(Equal([]byte("c"))))
+		})
+
+		It("should go offline when peer closed", func() {
+			conn := dial("u1")
+			Ω(conn.Close()).To(Succeed())
+			Eventually(func() bool { return hub.CheckOnline(ctx, "u1") }).Should(BeFalse())
+		})
+
+		It("should close socket when kicked", func() {
+			conn := dial("u1")
+			defer conn.Close()
+
+			hub.Kick(ctx, "u1")
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err := conn.Read(make([]byte, 1))
+			Ω(err).To(MatchError(io.EOF))
+		})
+
+		It("should not cost a goroutine per idle connection", func() {
+			const n = 200
+			goroutines := runtime.NumGoroutine()
+			var conns []net.Conn
+			for i := 0; i < n; i++ {
+				conn, err := net.Dial("tcp", addr)
+				Ω(err).To(Succeed())
+				writeFrame(conn, []byte(strconv.Itoa(i)))
+				conns = append(conns, conn)
+			}
+			defer func() {
+				for _, conn := range conns {
+					_ = conn.Close()
+				}
+			}()
+
+			Eventually(func() []interface{} { return hub.Online(ctx) }).Should(HaveLen(n))
+			Eventually(runtime.NumGoroutine).Should(BeNumerically("<", goroutines+n/10))
+
+			// still readable after all
+			writeFrame(conns[n-1], []byte("last"))
+			Eventually(chData).Should(Receive(Equal([]byte("last"))))
+		})
+
+		It("should assemble frames split across reads", func() {
+			conn := dial("u1")
+			defer conn.Close()
+
+			frame := binary.BigEndian.AppendUint32(nil, 5)
+			frame = append(frame, "hello"...)
+			frame = binary.BigEndian.AppendUint32(frame, 2)
+			frame = append(frame, "hi"...)
+			for i := range frame {
+				_, err := conn.Write(frame[i : i+1])
+				Ω(err).To(Succeed())
+				time.Sleep(time.Millisecond)
+			}
+			Eventually(chData).Should(Receive(Equal([]byte("hello"))))
+			Eventually(chData).Should(Receive(Equal([]byte("hi"))))
+		})
+
+		It("should not block workers on partial frames", func() {
+			// more slow peers than workers, each stops in the middle of a frame
+			var slow []net.Conn
+			for i := 0; i < 4; i++ {
+				conn := dial("slow" + strconv.Itoa(i))
+				defer conn.Close()
+				_, err := conn.Write([]byte{0, 0, 0, 4, 's'})
+				Ω(err).To(Succeed())
+				slow = append(slow, conn)
+			}
+
+			conn := dial("u1")
+			defer conn.Close()
+			writeFrame(conn, []byte("hello"))
+			Eventually(chData, time.Second).Should(Receive(Equal([]byte("hello"))))
+
+			for _, conn := range slow {
+				_, err := conn.Write([]byte("low"))
+				Ω(err).To(Succeed())
+				Eventually(chData).Should(Receive(Equal([]byte("slow"))))
+			}
+		})
+
+		Context("with read timeout", func() {
+			BeforeEach(func() {
+				hubOpts = append(hubOpts, tok.WithHubConfigReadTimeout(100*time.Millisecond))
+			})
+
+			It("should close connection whose partial frame isn't completed in time", func() {
+				conn := dial("u1")
+				defer conn.Close()
+
+				// completed frames keep connection
+				for i := 0; i < 3; i++ {
+					writeFrame(conn, []byte("hello"))
+					Eventually(chData).Should(Receive())
+					time.Sleep(60 * time.Millisecond)
+				}
+				Ω(hub.CheckOnline(ctx, "u1")).To(BeTrue())
+
+				_, err := conn.Write([]byte{0, 0, 0, 5, 'h'})
+				Ω(err).To(Succeed())
+				Eventually(func() bool { return hub.CheckOnline(ctx, "u1") }).Should(BeFalse())
+			})
+
+			It("should close connection silent between frames", func() {
+				conn := dial("u1")
+				defer conn.Close()
+
+				writeFrame(conn, []byte("hello"))
+				Eventually(chData).Should(Receive())
+				Ω(hub.CheckOnline(ctx, "u1")).To(BeTrue())
+
+				Eventually(func() bool { return hub.CheckOnline(ctx, "u1") }).Should(BeFalse())
+				buf := make([]byte, 1)
+				_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+				_, err := conn.Read(buf)
+				Ω(err).To(MatchError(io.EOF))
+			})
+		})
+	})
+})
@@ -0,0 +1,53 @@
+package tok
+
+import "runtime"
+
+type TCPListenerOption func(*tcpListener)
+
+// WithTCPListenerProxyProtocol enable HAProxy PROXY protocol v1/v2 parsing before the auth frame.
+// trustedCIDRs is the list of load balancer networks allowed to send PROXY headers, at least one is needed.
+// A header received from other sources is rejected, connections without header keep their own address.
+// The real client address is available via Device.RemoteAddr, and to auth function of WithTCPListenerAddrAuth.
+func WithTCPListenerProxyProtocol(trustedCIDRs ...string) TCPListenerOption {
+	return func(l *tcpListener) {
+		l.proxyProtocol = true
+		l.trustedCIDRs = trustedCIDRs
+	}
+}
+
+// WithTCPListenerAddrAuth set auth function receiving remote address of connection, it replaces auth of Listen
+func WithTCPListenerAddrAuth(auth TCPAddrAuthFunc) TCPListenerOption {
+	return func(l *tcpListener) {
+		l.addrAuth = auth
+	}
+}
+
+// WithTCPListenerFramer set framing codec for tcp listener, default is Uint32Framer
+func WithTCPListenerFramer(framer Framer) TCPListenerOption {
+	return func(l *tcpListener) {
+		l.framer = framer
+	}
+}
+
+// WithTCPListenerMaxFrameLen set upper limit for single inbound message, default is TCPMaxPackLen
+func WithTCPListenerMaxFrameLen(n uint32) TCPListenerOption {
+	return func(l *tcpListener) {
+		l.maxFrameLen = n
+	}
+}
+
+// WithTCPListenerNetpoll enable epoll reactor for connections of tcp listener, linux only.
+// Sockets are read by a pool of workers when they are readable, instead of a goroutine per connection,
+// which saves memory for mostly idle connections. workers < 1 means runtime.GOMAXPROCS(0).
+// Workers never wait for slow peers, bytes of partial frame are buffered by connection until the rest arrives.
+// A frame must be completed within read timeout, or auth timeout if read timeout is 0, once its first byte arrived.
+// Read timeout of hub closes connection silent for longer, as it does without netpoll.
+// On other platforms, it falls back to a goroutine per connection.
+func WithTCPListenerNetpoll(workers int) TCPListenerOption {
+	return func(l *tcpListener) {
+		if workers < 1 {
+			workers = runtime.GOMAXPROCS(0)
+		}
+		l.netpollWorkers = workers
+	}
+}
@@ -0,0 +1,192 @@
+/**
+ * hierarchical timing wheel, drives connection keepalive
+ */
+
+package tok
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	wheelBits   = 6
+	wheelSize   = 1 << wheelBits // slots per level
+	wheelMask   = wheelSize - 1
+	wheelLevels = 4 // span is wheelSize^wheelLevels ticks
+	wheelSpan   = uint64(1) << (wheelBits * wheelLevels)
+)
+
+// wheelTimer is a one-shot timer of timingWheel, it can be rescheduled after fired.
+type wheelTimer struct {
+	expire     uint64 // absolute tick to fire
+	fn         func() // callback, runs in wheel goroutine
+	slot       *wheelSlot
+	prev, next *wheelTimer
+}
+
+// wheelSlot is a doubly linked list of timers
+type wheelSlot struct {
+	head *wheelTimer
+}
+
+func (s *wheelSlot) push(t *wheelTimer) {
+	t.slot = s
+	t.prev = nil
+	t.next = s.head
+	if s.head != nil {
+		s.head.prev = t
+	}
+	s.head = t
+}
+
+func (s *wheelSlot) remove(t *wheelTimer) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		s.head = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.slot, t.prev, t.next = nil, nil, nil
+}
+
+// take detaches and returns all timers of slot
+func (s *wheelSlot) take() *wheelTimer {
+	head := s.head
+	s.head = nil
+	return head
+}
+
+// timingWheel is a hierarchical timing wheel.
+// Level n slot covers wheelSize^n ticks, timers are cascaded to lower level while time goes by.
+// Scheduling and stopping are O(1), thousands of timers cost no goroutine but one.
+//
+// Callbacks run in wheel goroutine one by one, they must not block.
+type timingWheel struct {
+	mu     sync.Mutex
+	tick   time.Duration
+	start  time.Time
+	base   uint64 // next tick to process
+	levels [wheelLevels][wheelSize]wheelSlot
+}
+
+// newTimingWheel create timing wheel with tick precision and start it
+func newTimingWheel(tick time.Duration) *timingWheel {
+	w := &timingWheel{
+		tick:  tick,
+		start: time.Now(),
+	}
+	go w.run()
+	return w
+}
+
+func (w *timingWheel) run() {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		w.advance(uint64(now.Sub(w.start) / w.tick))
+	}
+}
+
+// advance processes ticks up to now, catching up if the wheel lags behind
+func (w *timingWheel) advance(now uint64) {
+	for {
+		w.mu.Lock()
+		if w.base > now {
+			w.mu.Unlock()
+			return
+		}
+		expired := w.processTick()
+		w.mu.Unlock()
+
+		for _, fn := range expired {
+			fn()
+		}
+	}
+}
+
+// processTick cascades timers of upper levels if lower level wraps, and detaches timers expired at base.
+// It returns callbacks of expired timers, and must be called with lock held.
+func (w *timingWheel) processTick() []func() {
+	index := w.base & wheelMask
+	if index == 0 {
+		for level := 1; level < wheelLevels; level++ {
+			i := (w.base >> (wheelBits * level)) & wheelMask
+			for t := w.levels[level][i].take(); t != nil; {
+				next := t.next
+				w.add(t)
+				t = next
+			}
+			if i != 0 {
+				break
+			}
+		}
+	}
+
+	w.base++
+	var expired []func()
+	for t := w.levels[0][index].take(); t != nil; {
+		next := t.next
+		t.slot, t.prev, t.next = nil, nil, nil
+		expired = append(expired, t.fn)
+		t = next
+	}
+	return expired
+}
+
+// add places timer in slot according to its expire tick, it must be called with lock held.
+func (w *timingWheel) add(t *wheelTimer) {
+	expire := t.expire
+	if expire < w.base {
+		expire = w.base
+	}
+	delta := expire - w.base
+	if delta >= wheelSpan {
+		// out of range, park at the farthest slot and re-add while cascading
+		expire = w.base + wheelSpan - 1
+		delta = wheelSpan - 1
+	}
+
+	level := 0
+	for delta >= uint64(1)<<(wheelBits*(level+1)) {
+		level++
+	}
+	w.levels[level][(expire>>(wheelBits*level))&wheelMask].push(t)
+}
+
+// newTimer create timer calling fn in wheel goroutine, it's not scheduled until reset
+func (w *timingWheel) newTimer(fn func()) *wheelTimer {
+	return &wheelTimer{fn: fn}
+}
+
+// reset (re)schedules timer to fire after d
+func (w *timingWheel) reset(t *wheelTimer, d time.Duration) {
+	w.schedule(t, uint64((time.Since(w.start)+d+w.tick-1)/w.tick))
+}
+
+// schedule (re)schedules timer to fire at tick expire
+func (w *timingWheel) schedule(t *wheelTimer, expire uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if t.slot != nil {
+		t.slot.remove(t)
+	}
+	t.expire = expire
+	w.add(t)
+}
+
+// stop prevents timer from firing, it returns false if timer has fired or been stopped
+func (w *timingWheel) stop(t *wheelTimer) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if t.slot == nil {
+		return false
+	}
+	t.slot.remove(t)
+	return true
+}
@@ -0,0 +1,141 @@
+package tok_test
+
+import (
+	"strconv"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/quexer/tok"
+)
+
+var _ = Describe("TimingWheel", func() {
+	var (
+		wheel *tok.TestWheel
+		fired []string // names of fired timers in order
+	)
+
+	BeforeEach(func() {
+		wheel = tok.NewTestWheel()
+		fired = nil
+	})
+
+	timer := func(name string) *tok.WheelTimer {
+		return wheel.NewTimer(func() {
+			fired = append(fired, name)
+		})
+	}
+
+	// expectFireAt advances to the tick before expire and checks nothing fired, then advances to expire
+	expectFireAt := func(expire uint64, name string) {
+		n := len(fired)
+		wheel.Advance(expire - 1)
+		Ω(fired).To(HaveLen(n), "fired before tick %d", expire)
+		wheel.Advance(expire)
+		Ω(fired).To(HaveLen(n+1), "not fired at tick %d", expire)
+		Ω(fired[n]).To(Equal(name))
+	}
+
+	It("should fire at expire tick", func() {
+		wheel.Schedule(timer("t"), 5)
+		expectFireAt(5, "t")
+		Ω(wheel.Base()).To(BeEquivalentTo(6))
+
+		wheel.Advance(1000)
+		Ω(fired).To(HaveLen(1))
+	})
+
+	It("should fire expired timers in order while catching up", func() {
+		wheel.Schedule(timer("c"), 300)
+		wheel.Schedule(timer("a"), 3)
+		wheel.Schedule(timer("b"), 70)
+		wheel.Advance(1000)
+		Ω(fired).To(Equal([]string{"a", "b", "c"}))
+	})
+
+	It("should fire past expire at next tick", func() {
+		wheel.Advance(10)
+		wheel.Schedule(timer("t"), 2)
+		Ω(wheel.ProcessTick()).To(Equal(1))
+	})
+
+	It("should cascade timers across levels", func() {
+		// boundaries of level 1, 2 and 3
+		expires := []uint64{63, 64, 65, 4095, 4096, 4097, 262143, 262144, 262145, 300000}
+		for _, expire := range expires {
+			wheel.Schedule(timer(strconv.FormatUint(expire, 10)), expire)
+		}
+		for _, expire := range expires {
+			expectFireAt(expire, strconv.FormatUint(expire, 10))
+		}
+	})
+
+	It("should cascade timers scheduled after wheel moved", func() {
+		wheel.Advance(4000)
+		wheel.Schedule(timer("t"), 4000+5000)
+		expectFireAt(9000, "t")
+	})
+
+	It("should reset pending timer", func() {
+		t := timer("t")
+		wheel.Schedule(t, 10)
+		wheel.Schedule(t, 5000)
+		wheel.Advance(4999)
+		Ω(fired).To(BeEmpty())
+		expectFireAt(5000, "t")
+
+		// earlier
+		wheel.Schedule(t, 9000)
+		wheel.Schedule(t, 5100)
+		expectFireAt(5100, "t")
+		wheel.Advance(10000)
+		Ω(fired).To(HaveLen(2))
+	})
+
+	It("should reschedule timer in its callback", func() {
+		var t *tok.WheelTimer
+		count := 0
+		t = wheel.NewTimer(func() {
+			count++
+			if count < 3 {
+				wheel.Schedule(t, wheel.Base()+100)
+			}
+		})
+		wheel.Schedule(t, 1)
+		wheel.Advance(1000)
+		Ω(count).To(Equal(3))
+	})
+
+	It("should stop timer", func() {
+		t := timer("t")
+		Ω(wheel.Stop(t)).To(BeFalse(), "not scheduled")
+
+		wheel.Schedule(t, 100)
+		Ω(wheel.Stop(t)).To(BeTrue())
+		Ω(wheel.Stop(t)).To(BeFalse(), "stopped")
+		wheel.Advance(1000)
+		Ω(fired).To(BeEmpty())
+
+		wheel.Schedule(t, 1100)
+		wheel.Advance(1100)
+		Ω(fired).To(HaveLen(1))
+		Ω(wheel.Stop(t)).To(BeFalse(), "fired")
+	})
+
+	It("should keep other timers of slot while stopping one", func() {
+		t1, t2, t3 := timer("1"), timer("2"), timer("3")
+		wheel.Schedule(t1, 7)
+		wheel.Schedule(t2, 7)
+		wheel.Schedule(t3, 7)
+		Ω(wheel.Stop(t2)).To(BeTrue())
+		wheel.Advance(7)
+		Ω(fired).To(ConsistOf("1", "3"))
+	})
+
+	It("should fire timers beyond span of wheel", func() {
+		wheel.Schedule(timer("far"), tok.WheelSpan+10)
+		wheel.Schedule(timer("farther"), 2*tok.WheelSpan+3)
+		expectFireAt(tok.WheelSpan+10, "far")
+		expectFireAt(2*tok.WheelSpan+3, "farther")
+	})
+})
@@ -5,10 +5,12 @@
 package tok
 
 import (
+	"context"
 	"errors"
+	"time"
 )
 
-//go:generate mockgen -destination=mocks/tok.go -package=mocks . Actor,BeforeReceiveHandler,BeforeSendHandler,AfterSendHandler,CloseHandler,PingGenerator,ByeGenerator
+//go:generate mockgen -destination=mocks/tok.go -package=mocks . Actor,BeforeReceiveHandler,BeforeSendHandler,AfterSendHandler,CloseHandler,PingGenerator,ByeGenerator,ContextActor,ContextBeforeReceiveHandler,ContextBeforeSendHandler,ContextAfterSendHandler,ContextCloseHandler,ActorWithResult,ErrorPolicy,PanicHandler,RequestCodec,LastSeenStore
 
 // ErrOffline occurs while sending message to online user only. see Hub.Send
 var ErrOffline = errors.New("tok: offline")
@@ -19,6 +21,9 @@ var ErrQueueRequired = errors.New("tok: queue is required")
 // ErrCacheFailed occurs while sending "cacheable" message with queue but failed to cache
 var ErrCacheFailed = errors.New("tok: cache error")
 
+// ErrHookPanic is wrapped by error of a user hook which panicked, see PanicHandler
+var ErrHookPanic = errors.New("tok: hook panicked")
+
 // BeforeReceiveHandler is an interface for preprocessing incoming data before OnReceive
 type BeforeReceiveHandler interface {
 	// BeforeReceive is called to preprocess incoming data before OnReceive
@@ -53,6 +58,7 @@ type PingGenerator interface {
 type ByeGenerator interface {
 	// Bye builds the payload to notify clients before a connection is closed for a specific reason.
 	// kicker is the device that initiated the kick, reason is the reason for the kick, dv is the device being kicked.
+	// reason is one of ByeReasonXxx, or ErrorDecision.Reason. kicker is dv itself while it's closed by ErrorPolicy.
 	Bye(kicker *Device, reason string, dv *Device) []byte
 }
 
@@ -62,3 +68,74 @@ type Actor interface {
 	// dv represents the sender the data, data is the received byte slice.
 	OnReceive(dv *Device, data []byte)
 }
+
+// ContextActor is the context-aware version of Actor.
+// ctx is derived from the connection context, with message-level values, see MessageInfoFromContext.
+type ContextActor interface {
+	// OnReceive is called whenever the server receives a valid payload.
+	OnReceive(ctx context.Context, dv *Device, data []byte)
+}
+
+// ContextBeforeReceiveHandler is the context-aware version of BeforeReceiveHandler
+type ContextBeforeReceiveHandler interface {
+	// BeforeReceive is called to preprocess incoming data before OnReceive, with the same ctx as OnReceive
+	BeforeReceive(ctx context.Context, dv *Device, data []byte) ([]byte, error)
+}
+
+// ContextBeforeSendHandler is the context-aware version of BeforeSendHandler.
+// ctx is derived from the connection context of receiver, see SendContext for the context of sender.
+type ContextBeforeSendHandler interface {
+	// BeforeSend is called to preprocess outgoing data before sending
+	BeforeSend(ctx context.Context, dv *Device, data []byte) ([]byte, error)
+}
+
+// ContextAfterSendHandler is the context-aware version of AfterSendHandler
+type ContextAfterSendHandler interface {
+	// AfterSend is called after data has been sent to a device, with the same ctx as BeforeSend
+	AfterSend(ctx context.Context, dv *Device, data []byte)
+}
+
+// ContextCloseHandler is the context-aware version of CloseHandler
+type ContextCloseHandler interface {
+	// OnClose is called after a connection has been closed.
+	// ctx carries values of the connection context, but it's not cancelled with the connection.
+	OnClose(ctx context.Context, dv *Device)
+}
+
+// ActorWithResult is the context-aware Actor reporting result of handling.
+// Error returned is mapped to action by ErrorPolicy, see WithHubConfigErrorPolicy.
+type ActorWithResult interface {
+	// OnReceive is called whenever the server receives a valid payload.
+	OnReceive(ctx context.Context, dv *Device, data []byte) error
+}
+
+// ErrorPolicy decides what to do with a connection sending bad messages
+type ErrorPolicy interface {
+	// OnError is called with error returned by BeforeReceive or ActorWithResult, ctx is the message context.
+	OnError(ctx context.Context, dv *Device, err error) ErrorDecision
+}
+
+// RequestCodec frames correlated messages of request/response, see Hub.Request and WithHubConfigRequestCodec.
+// Framing is applied inside BeforeSend and BeforeReceive handlers, i.e. before BeforeSend and after BeforeReceive.
+type RequestCodec interface {
+	// Encode frames request or reply with its correlation id
+	Encode(f RequestFrame) ([]byte, error)
+	// Decode parses incoming data. ok is false for plain message, which goes to actor as it is.
+	// Error is handled as receive error, see ErrorPolicy.
+	Decode(data []byte) (f RequestFrame, ok bool, err error)
+}
+
+// PanicHandler is notified of panics recovered from user hooks, e.g. Actor, BeforeSendHandler, PingGenerator, auth function.
+type PanicHandler interface {
+	// OnPanic is called with the device being handled, nil if it's unknown yet (e.g. panic in auth function).
+	// hook is the name of panicked method, e.g. "OnReceive", value is the recovered value, stack is stack trace of the panic.
+	OnPanic(dv *Device, hook string, value interface{}, stack []byte)
+}
+
+// LastSeenStore keeps when uids were last seen, see WithHubConfigLastSeen and Hub.LastSeen
+type LastSeenStore interface {
+	// SetLastSeen records uid was seen at t. Calls may arrive out of order, store should keep the latest time.
+	SetLastSeen(ctx context.Context, uid interface{}, t time.Time) error
+	// LastSeen returns when uid was last seen, zero time if never
+	LastSeen(ctx context.Context, uid interface{}) (time.Time, error)
+}
@@ -0,0 +1,182 @@
+// Package typed is a type-safe facade of tok, with uid type fixed to U.
+// Uids are interface{} in tok, so int64(5) and "5" are two different users there.
+// Hub[U], Device[U], Actor[U] and Queue[U] let the compiler enforce one uid type,
+// they wrap tok types, which remain the compatible interface{} API.
+package typed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/quexer/tok"
+)
+
+// ErrUIDType occurs while uid of tok device or queue call is not of the uid type of typed facade
+var ErrUIDType = errors.New("tok typed: uid type mismatch")
+
+// Device is tok.Device with uid of type U
+type Device[U comparable] struct {
+	*tok.Device
+}
+
+// CreateDevice uid is user id, id is uuid of this device(could be empty)
+func CreateDevice[U comparable](uid U, id string) *Device[U] {
+	return &Device[U]{Device: tok.CreateDevice(uid, id)}
+}
+
+// DeviceOf converts tok device, ok is false if its uid is not U
+func DeviceOf[U comparable](dv *tok.Device) (*Device[U], bool) {
+	if dv == nil {
+		return nil, false
+	}
+	if _, ok := dv.UID().(U); !ok {
+		return nil, false
+	}
+	return &Device[U]{Device: dv}, true
+}
+
+// UID return user id
+func (p *Device[U]) UID() U {
+	return p.Device.UID().(U)
+}
+
+// Hub is tok.Hub with uid of type U
+type Hub[U comparable] struct {
+	hub *tok.Hub
+}
+
+// Wrap wraps tok hub. Devices of the hub should be created by CreateDevice[U], e.g. by TCPAuth or WsAuth.
+func Wrap[U comparable](hub *tok.Hub) *Hub[U] {
+	return &Hub[U]{hub: hub}
+}
+
+// Unwrap returns the underlying tok hub
+func (p *Hub[U]) Unwrap() *tok.Hub {
+	return p.hub
+}
+
+// Send message to someone, see tok.Hub.Send
+func (p *Hub[U]) Send(ctx context.Context, to U, b []byte, ttl uint32) error {
+	return p.hub.Send(ctx, to, b, ttl)
+}
+
+// Request sends payload to device deviceID of uid as request, and waits for its reply, see tok.Hub.Request
+func (p *Hub[U]) Request(ctx context.Context, uid U, deviceID string, payload []byte) ([]byte, error) {
+	return p.hub.Request(ctx, uid, deviceID, payload)
+}
+
+// CheckOnline return whether user online or not, see tok.Hub.CheckOnline
+func (p *Hub[U]) CheckOnline(ctx context.Context, uid U) bool {
+	return p.hub.CheckOnline(ctx, uid)
+}
+
+// Online query online user list, see tok.Hub.Online.
+// Uids of other types, i.e. devices registered by untyped API, are skipped.
+func (p *Hub[U]) Online(ctx context.Context) []U {
+	l := p.hub.Online(ctx)
+	if l == nil {
+		return nil
+	}
+	result := make([]U, 0, len(l))
+	for _, v := range l {
+		if uid, ok := v.(U); ok {
+			result = append(result, uid)
+		}
+	}
+	return result
+}
+
+// Kick all connections of uid, see tok.Hub.Kick
+func (p *Hub[U]) Kick(ctx context.Context, uid U) {
+	p.hub.Kick(ctx, uid)
+}
+
+// RegisterConnection register connection of custom adapter, see tok.Hub.RegisterConnection
+func (p *Hub[U]) RegisterConnection(ctx context.Context, dv *Device[U], adapter tok.ConAdapter) {
+	p.hub.RegisterConnection(ctx, dv.Device, adapter)
+}
+
+// TCPAuth adapts typed auth function to tok.TCPAuthFunc
+func TCPAuth[U comparable](auth func([]byte) (*Device[U], error)) tok.TCPAuthFunc {
+	return func(b []byte) (*tok.Device, error) {
+		dv, err := auth(b)
+		if err != nil {
+			return nil, err
+		}
+		return dv.Device, nil
+	}
+}
+
+// WsAuth adapts typed auth function to tok.WsAuthFunc
+func WsAuth[U comparable](auth func(*http.Request) (*Device[U], error)) tok.WsAuthFunc {
+	return func(r *http.Request) (*tok.Device, error) {
+		dv, err := auth(r)
+		if err != nil {
+			return nil, err
+		}
+		return dv.Device, nil
+	}
+}
+
+// Actor is tok.ActorWithResult with typed device
+type Actor[U comparable] interface {
+	// OnReceive is called whenever the server receives a valid payload.
+	OnReceive(ctx context.Context, dv *Device[U], data []byte) error
+}
+
+// AdaptActor adapts typed actor to tok.ActorWithResult, see tok.NewHubConfigWithResult.
+// Message of device whose uid is not U fails with ErrUIDType.
+func AdaptActor[U comparable](actor Actor[U]) tok.ActorWithResult {
+	return actorAdapter[U]{actor}
+}
+
+type actorAdapter[U comparable] struct{ actor Actor[U] }
+
+func (p actorAdapter[U]) OnReceive(ctx context.Context, dv *tok.Device, data []byte) error {
+	tdv, ok := DeviceOf[U](dv)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrUIDType, dv.UID())
+	}
+	return p.actor.OnReceive(ctx, tdv, data)
+}
+
+// Queue is tok.Queue with uid of type U
+type Queue[U comparable] interface {
+	Enq(ctx context.Context, uid U, data []byte, ttl ...uint32) error
+	Deq(ctx context.Context, uid U) ([]byte, error)
+	Len(ctx context.Context, uid U) (int, error)
+}
+
+// AdaptQueue adapts typed queue to tok.Queue, see tok.WithHubConfigQueue.
+// Calls with uid which is not U fail with ErrUIDType.
+func AdaptQueue[U comparable](q Queue[U]) tok.Queue {
+	return queueAdapter[U]{q}
+}
+
+type queueAdapter[U comparable] struct{ q Queue[U] }
+
+func (p queueAdapter[U]) Enq(ctx context.Context, uid interface{}, data []byte, ttl ...uint32) error {
+	u, ok := uid.(U)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrUIDType, uid)
+	}
+	return p.q.Enq(ctx, u, data, ttl...)
+}
+
+func (p queueAdapter[U]) Deq(ctx context.Context, uid interface{}) ([]byte, error) {
+	u, ok := uid.(U)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUIDType, uid)
+	}
+	return p.q.Deq(ctx, u)
+}
+
+func (p queueAdapter[U]) Len(ctx context.Context, uid interface{}) (int, error) {
+	u, ok := uid.(U)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T", ErrUIDType, uid)
+	}
+	return p.q.Len(ctx, u)
+}
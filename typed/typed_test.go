@@ -0,0 +1,128 @@
+package typed_test
+
+import (
+	"context"
+	"io"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/mocks"
+	"github.com/quexer/tok/typed"
+)
+
+// sliceQueue is a typed queue without ttl
+type sliceQueue map[int64][][]byte
+
+func (q sliceQueue) Enq(_ context.Context, uid int64, data []byte, _ ...uint32) error {
+	q[uid] = append(q[uid], data)
+	return nil
+}
+
+func (q sliceQueue) Deq(_ context.Context, uid int64) ([]byte, error) {
+	if len(q[uid]) == 0 {
+		return nil, nil
+	}
+	b := q[uid][0]
+	q[uid] = q[uid][1:]
+	return b, nil
+}
+
+func (q sliceQueue) Len(_ context.Context, uid int64) (int, error) {
+	return len(q[uid]), nil
+}
+
+type actorFunc func(ctx context.Context, dv *typed.Device[int64], data []byte) error
+
+func (f actorFunc) OnReceive(ctx context.Context, dv *typed.Device[int64], data []byte) error {
+	return f(ctx, dv, data)
+}
+
+var _ = Describe("Typed", func() {
+	It("should convert device of uid type only", func() {
+		dv := typed.CreateDevice(int64(5), "phone")
+		Ω(dv.UID()).To(Equal(int64(5)))
+		Ω(dv.ID()).To(Equal("phone"))
+
+		_, ok := typed.DeviceOf[int64](tok.CreateDevice("5", ""))
+		Ω(ok).To(BeFalse())
+		same, ok := typed.DeviceOf[int64](dv.Device)
+		Ω(ok).To(BeTrue())
+		Ω(same.UID()).To(Equal(int64(5)))
+	})
+
+	It("should reject uid of other type in queue and actor", func() {
+		q := typed.AdaptQueue[int64](sliceQueue{})
+		Ω(q.Enq(ctx, int64(5), []byte("m"))).To(Succeed())
+		Ω(q.Len(ctx, int64(5))).To(Equal(1))
+		Ω(q.Enq(ctx, "5", []byte("m"))).To(MatchError(typed.ErrUIDType))
+		_, err := q.Deq(ctx, "5")
+		Ω(err).To(MatchError(typed.ErrUIDType))
+		_, err = q.Len(ctx, 5)
+		Ω(err).To(MatchError(typed.ErrUIDType))
+
+		actor := typed.AdaptActor[int64](actorFunc(func(context.Context, *typed.Device[int64], []byte) error {
+			return nil
+		}))
+		Ω(actor.OnReceive(ctx, tok.CreateDevice(int64(5), ""), nil)).To(Succeed())
+		Ω(actor.OnReceive(ctx, tok.CreateDevice("5", ""), nil)).To(MatchError(typed.ErrUIDType))
+	})
+
+	It("should serve typed uids through hub", func() {
+		chData := make(chan []byte, 1)
+		actor := actorFunc(func(_ context.Context, dv *typed.Device[int64], data []byte) error {
+			Ω(dv.UID()).To(Equal(int64(5)))
+			chData <- data
+			return nil
+		})
+		q := sliceQueue{}
+		config := tok.NewHubConfigWithResult(typed.AdaptActor[int64](actor),
+			tok.WithHubConfigPingProducer(mocks.NewMockPingGenerator(ctl)),
+			tok.WithHubConfigQueue(typed.AdaptQueue[int64](q)))
+		h, _ := tok.CreateWsHandler(nil, tok.WithWsHandlerHubConfig(config))
+		hub := typed.Wrap[int64](h)
+		Ω(hub.Unwrap()).To(Equal(h))
+
+		// cached for offline user, and delivered while it's online
+		Ω(hub.Send(ctx, 5, []byte("offline"), 60)).To(Succeed())
+		Ω(q[5]).To(HaveLen(1))
+
+		chRead := make(chan []byte)
+		chWritten := make(chan []byte, 2)
+		chClosed := make(chan struct{})
+		adapter := mocks.NewMockConAdapter(ctl)
+		adapter.EXPECT().Read().DoAndReturn(func() ([]byte, error) {
+			select {
+			case b := <-chRead:
+				return b, nil
+			case <-chClosed:
+				return nil, io.EOF
+			}
+		}).AnyTimes()
+		adapter.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) error {
+			chWritten <- b
+			return nil
+		}).AnyTimes()
+		adapter.EXPECT().Close().DoAndReturn(func() error {
+			close(chClosed)
+			return nil
+		})
+		go hub.RegisterConnection(ctx, typed.CreateDevice(int64(5), ""), adapter)
+
+		Eventually(func() []int64 { return hub.Online(ctx) }).Should(Equal([]int64{5}))
+		Ω(hub.CheckOnline(ctx, 5)).To(BeTrue())
+		Eventually(chWritten).Should(Receive(Equal([]byte("offline"))))
+
+		Ω(hub.Send(ctx, 5, []byte("hi"), 0)).To(Succeed())
+		Eventually(chWritten).Should(Receive(Equal([]byte("hi"))))
+
+		chRead <- []byte("up")
+		Eventually(chData).Should(Receive(Equal([]byte("up"))))
+
+		hub.Kick(ctx, 5)
+		Eventually(chClosed).Should(BeClosed())
+		Eventually(func() bool { return hub.CheckOnline(ctx, 5) }).Should(BeFalse())
+	})
+})
@@ -0,0 +1,53 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers of signed webhook request, see WithSecret
+const (
+	HeaderSignature = "X-Tok-Signature"
+	HeaderTimestamp = "X-Tok-Timestamp"
+)
+
+// ErrBadSignature occurs while verifying request of bad or expired signature
+var ErrBadSignature = errors.New("tok webhook: bad signature")
+
+// Sign returns signature of body sent at timestamp, it's "sha256=" followed by hex of HMAC-SHA256 of "timestamp.body"
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reads body of webhook request r and checks its signature, it's for the endpoint receiving webhooks.
+// Requests whose timestamp is more than maxAge away from now, in either direction, are rejected against replay,
+// maxAge <= 0 means no check.
+func Verify(secret []byte, r *http.Request, maxAge time.Duration) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := r.Header.Get(HeaderTimestamp)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, ErrBadSignature
+	}
+	if age := time.Since(time.Unix(sec, 0)); maxAge > 0 && (age > maxAge || age < -maxAge) {
+		return nil, ErrBadSignature
+	}
+	if !hmac.Equal([]byte(r.Header.Get(HeaderSignature)), []byte(Sign(secret, ts, body))) {
+		return nil, ErrBadSignature
+	}
+	return body, nil
+}
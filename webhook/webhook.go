@@ -0,0 +1,419 @@
+// Package webhook forwards messages received by tok hub, and device connect/disconnect events, to an HTTP endpoint.
+// Forwarder is the actor of hub, it posts events in batches with retry and concurrency limit, optionally signed by HMAC.
+// Replies in webhook response could be sent back to the devices, see WithReply.
+//
+//	fwd := webhook.New("https://backend/tok", webhook.WithSecret(secret), webhook.WithReply(true))
+//	hub, hdl := tok.CreateWsHandler(auth, tok.WithWsHandlerHubConfig(tok.NewHubConfigWithResult(fwd, opts...)))
+//	fwd.Start(ctx, hub)
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/quexer/tok"
+)
+
+// Types of Event
+const (
+	TypeMessage      = "message"      // message received from device
+	TypeConnected    = "connected"    // device connected
+	TypeDisconnected = "disconnected" // device disconnected
+)
+
+// ErrQueueFull is returned by OnReceive while events are not forwarded fast enough, see WithQueueSize
+var ErrQueueFull = errors.New("tok webhook: queue is full")
+
+// Event is forwarded to webhook
+type Event struct {
+	ID      string            `json:"id"`                // unique id in forwarder, see Reply
+	Type    string            `json:"type"`              // one of TypeXxx
+	UID     string            `json:"uid"`               // user id, formatted by fmt "%v"
+	Device  string            `json:"device,omitempty"`  // device id
+	Meta    map[string]string `json:"meta,omitempty"`    // device meta, see tok.Device.PutMeta
+	Payload []byte            `json:"payload,omitempty"` // payload of TypeMessage, base64 in JSON
+	Seq     uint64            `json:"seq,omitempty"`     // sequence of TypeMessage on the connection, messages may arrive out of order
+	Reason  string            `json:"reason,omitempty"`  // reason of TypeDisconnected, see tok.DisconnectReasonXxx
+	Time    time.Time         `json:"time"`              // when it happened
+}
+
+// Batch is JSON body of webhook request
+type Batch struct {
+	Events []Event `json:"events"`
+}
+
+// Reply is sent to the device of message ID
+type Reply struct {
+	ID      string `json:"id"`
+	Payload []byte `json:"payload"`
+}
+
+// Response is JSON body of webhook response, it's read only if reply is enabled, see WithReply
+type Response struct {
+	Replies []Reply `json:"replies"`
+}
+
+const (
+	defaultBatchSize   = 100
+	defaultBatchWait   = 10 * time.Millisecond
+	defaultRetries     = 3
+	defaultMinBackoff  = 100 * time.Millisecond
+	defaultMaxBackoff  = 5 * time.Second
+	defaultConcurrency = 4
+	defaultQueueSize   = 4096
+	defaultTimeout     = 10 * time.Second
+	maxResponseSize    = 1 << 20
+)
+
+// Option option of Forwarder
+type Option func(*Forwarder)
+
+// WithHTTPClient set http client posting webhooks, default http.DefaultClient
+func WithHTTPClient(client *http.Client) Option {
+	return func(p *Forwarder) {
+		p.client = client
+	}
+}
+
+// WithSecret signs webhook requests with secret, see Sign and Verify
+func WithSecret(secret []byte) Option {
+	return func(p *Forwarder) {
+		p.secret = secret
+	}
+}
+
+// WithBatch set max events of a request, and how long the first event waits for more, default 100 and 10ms.
+// size 1 disables batching.
+func WithBatch(size int, wait time.Duration) Option {
+	return func(p *Forwarder) {
+		p.batchSize = size
+		p.batchWait = wait
+	}
+}
+
+// WithRetry set retries of failed request, and backoff between them, default 3 retries, backoff from 100ms doubling up to 5s.
+// Network errors, 429 and 5xx responses are retried, events are dropped after the last retry.
+func WithRetry(retries int, minBackoff, maxBackoff time.Duration) Option {
+	return func(p *Forwarder) {
+		p.retries = retries
+		p.minBackoff = minBackoff
+		p.maxBackoff = maxBackoff
+	}
+}
+
+// WithConcurrency set max requests in flight, default 4
+func WithConcurrency(n int) Option {
+	return func(p *Forwarder) {
+		p.concurrency = n
+	}
+}
+
+// WithQueueSize set max events waiting for forwarding, default 4096.
+// OnReceive returns ErrQueueFull beyond it, and connect/disconnect events are dropped.
+func WithQueueSize(n int) Option {
+	return func(p *Forwarder) {
+		p.queueSize = n
+	}
+}
+
+// WithTimeout set timeout of each request, default 10s
+func WithTimeout(d time.Duration) Option {
+	return func(p *Forwarder) {
+		p.timeout = d
+	}
+}
+
+// WithReply set whether replies in webhook response are sent back to the devices, default false, see Response
+func WithReply(enabled bool) Option {
+	return func(p *Forwarder) {
+		p.reply = enabled
+	}
+}
+
+// WithEvents set whether connect/disconnect events are forwarded, default true
+func WithEvents(enabled bool) Option {
+	return func(p *Forwarder) {
+		p.events = enabled
+	}
+}
+
+// Forwarder is tok.ActorWithResult forwarding messages to webhook
+type Forwarder struct {
+	endpoint    string
+	client      *http.Client
+	secret      []byte
+	batchSize   int
+	batchWait   time.Duration
+	retries     int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	concurrency int
+	queueSize   int
+	timeout     time.Duration
+	reply       bool
+	events      bool
+
+	hub     *tok.Hub // set by Start
+	seq     atomic.Uint64
+	chItems chan *item
+}
+
+// item is event waiting for forwarding
+type item struct {
+	event Event
+	uid   interface{} // original uid, for reply
+}
+
+var _ tok.ActorWithResult = (*Forwarder)(nil)
+
+// New create forwarder posting to endpoint, it should be started after hub is created, see Start
+func New(endpoint string, opts ...Option) *Forwarder {
+	p := &Forwarder{
+		endpoint:    endpoint,
+		client:      http.DefaultClient,
+		batchSize:   defaultBatchSize,
+		batchWait:   defaultBatchWait,
+		retries:     defaultRetries,
+		minBackoff:  defaultMinBackoff,
+		maxBackoff:  defaultMaxBackoff,
+		concurrency: defaultConcurrency,
+		queueSize:   defaultQueueSize,
+		timeout:     defaultTimeout,
+		events:      true,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.batchSize < 1 {
+		p.batchSize = 1
+	}
+	if p.concurrency < 1 {
+		p.concurrency = 1
+	}
+	p.chItems = make(chan *item, p.queueSize)
+	return p
+}
+
+// OnReceive implements tok.ActorWithResult, message is queued for forwarding, ErrQueueFull if queue is full
+func (p *Forwarder) OnReceive(ctx context.Context, dv *tok.Device, data []byte) error {
+	info, ok := tok.MessageInfoFromContext(ctx)
+	if !ok {
+		info.Time = time.Now()
+	}
+	e := p.newEvent(TypeMessage, dv, info.Time)
+	e.Payload = append([]byte(nil), data...)
+	e.Seq = info.Seq
+	return p.enqueue(&item{event: e, uid: dv.UID()})
+}
+
+// Start forwards events in background until ctx is done, events still queued then are dropped.
+// hub is the hub of forwarder, whose events are followed, and replies are sent through.
+func (p *Forwarder) Start(ctx context.Context, hub *tok.Hub) {
+	p.hub = hub
+	chBatches := make(chan []*item)
+	for i := 0; i < p.concurrency; i++ {
+		go p.work(ctx, chBatches)
+	}
+	go p.batch(ctx, chBatches)
+	if p.events {
+		go p.follow(hub.Subscribe(ctx, tok.WithSubscribeBuffer(p.queueSize, tok.OverflowDropNewest)))
+	}
+}
+
+func (p *Forwarder) newEvent(typ string, dv *tok.Device, t time.Time) Event {
+	return Event{
+		ID:     strconv.FormatUint(p.seq.Add(1), 10),
+		Type:   typ,
+		UID:    fmt.Sprint(dv.UID()),
+		Device: dv.ID(),
+		Meta:   dv.Meta(),
+		Time:   t,
+	}
+}
+
+func (p *Forwarder) enqueue(it *item) error {
+	select {
+	case p.chItems <- it:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// follow queues connect/disconnect events of hub
+func (p *Forwarder) follow(events <-chan tok.Event) {
+	for e := range events {
+		var typ string
+		switch e.Type {
+		case tok.EventConnected:
+			typ = TypeConnected
+		case tok.EventDisconnected:
+			typ = TypeDisconnected
+		default:
+			continue
+		}
+		ev := p.newEvent(typ, e.Device, e.Time)
+		ev.Reason = e.Reason
+		if err := p.enqueue(&item{event: ev, uid: e.UID}); err != nil {
+			slog.Warn("[tok] webhook queue is full, drop event", "type", typ, "uid", e.UID)
+		}
+	}
+}
+
+// batch collects queued items into batches, it blocks while all workers are busy
+func (p *Forwarder) batch(ctx context.Context, chBatches chan<- []*item) {
+	var pending []*item
+	var timer *time.Timer
+	var chTimer <-chan time.Time
+
+	flush := func() bool {
+		if timer != nil {
+			timer.Stop()
+			chTimer = nil
+		}
+		select {
+		case chBatches <- pending:
+			pending = nil
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		select {
+		case it := <-p.chItems:
+			pending = append(pending, it)
+			if len(pending) >= p.batchSize {
+				if !flush() {
+					return
+				}
+			} else if len(pending) == 1 {
+				timer = time.NewTimer(p.batchWait)
+				chTimer = timer.C
+			}
+		case <-chTimer:
+			if !flush() {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *Forwarder) work(ctx context.Context, chBatches <-chan []*item) {
+	for {
+		select {
+		case items := <-chBatches:
+			p.forward(ctx, items)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// forward posts items with retry, and sends replies if enabled
+func (p *Forwarder) forward(ctx context.Context, items []*item) {
+	batch := Batch{Events: make([]Event, 0, len(items))}
+	for _, it := range items {
+		batch.Events = append(batch.Events, it.event)
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		slog.Warn("[tok] webhook marshal failed, drop events", "err", err, "events", len(items))
+		return
+	}
+
+	backoff := p.minBackoff
+	for attempt := 0; ; attempt++ {
+		resp, retry, err := p.post(ctx, body)
+		if err == nil {
+			if p.reply {
+				p.sendReplies(ctx, items, resp)
+			}
+			return
+		}
+		if !retry || attempt >= p.retries {
+			slog.Warn("[tok] webhook failed, drop events", "err", err, "events", len(items), "attempts", attempt+1)
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, p.maxBackoff)
+	}
+}
+
+// post sends body to endpoint, and returns body of 2xx response. retry tells whether failure is worth retrying.
+func (p *Forwarder) post(ctx context.Context, body []byte) (resp []byte, retry bool, err error) {
+	reqCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.secret != nil {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(HeaderTimestamp, ts)
+		req.Header.Set(HeaderSignature, Sign(p.secret, ts, body))
+	}
+
+	r, err := p.client.Do(req)
+	if err != nil {
+		return nil, ctx.Err() == nil, err
+	}
+	defer r.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(r.Body, maxResponseSize))
+	if r.StatusCode < 200 || r.StatusCode >= 300 {
+		retry := r.StatusCode == http.StatusTooManyRequests || r.StatusCode >= 500
+		return nil, retry, fmt.Errorf("tok webhook: unexpected status %d", r.StatusCode)
+	}
+	if err != nil {
+		// delivered anyway, so not retried
+		slog.Warn("[tok] webhook read response failed", "err", err)
+	}
+	return b, false, nil
+}
+
+// sendReplies sends replies in webhook response to devices of their messages
+func (p *Forwarder) sendReplies(ctx context.Context, items []*item, body []byte) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return
+	}
+	var resp Response
+	if err := json.Unmarshal(body, &resp); err != nil {
+		slog.Warn("[tok] bad webhook response", "err", err)
+		return
+	}
+
+	messages := make(map[string]*item, len(items))
+	for _, it := range items {
+		if it.event.Type == TypeMessage {
+			messages[it.event.ID] = it
+		}
+	}
+	for _, r := range resp.Replies {
+		it, ok := messages[r.ID]
+		if !ok {
+			slog.Warn("[tok] webhook reply to unknown message", "id", r.ID)
+			continue
+		}
+		if err := p.hub.SendDevice(ctx, it.uid, it.event.Device, r.Payload); err != nil {
+			slog.Debug("[tok] webhook reply failed", "err", err, "uid", it.uid, "device", it.event.Device)
+		}
+	}
+}
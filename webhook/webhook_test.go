@@ -0,0 +1,225 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/quexer/tok"
+	"github.com/quexer/tok/internal/testutil"
+	"github.com/quexer/tok/webhook"
+)
+
+var _ = Describe("Forwarder", func() {
+	var (
+		hub      *tok.Hub
+		fwd      *webhook.Forwarder
+		server   *httptest.Server
+		chBatch  chan webhook.Batch
+		respond  func(w http.ResponseWriter, b webhook.Batch) // writes response of webhook
+		requests atomic.Int32
+	)
+
+	secret := []byte("secret")
+
+	BeforeEach(func() {
+		chBatch = make(chan webhook.Batch, 100)
+		respond = func(http.ResponseWriter, webhook.Batch) {}
+		requests.Store(0)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			requests.Add(1)
+			body, err := webhook.Verify(secret, r, time.Minute)
+			Ω(err).To(Succeed())
+			var b webhook.Batch
+			Ω(json.Unmarshal(body, &b)).To(Succeed())
+			chBatch <- b
+			respond(w, b)
+		}))
+		DeferCleanup(server.Close)
+	})
+
+	// start creates hub with forwarder of opts, and starts it
+	start := func(opts ...webhook.Option) {
+		fwd = webhook.New(server.URL, append([]webhook.Option{webhook.WithSecret(secret)}, opts...)...)
+		hub = testutil.NewHubWithActor(ctl, fwd)
+		fwdCtx, cancel := context.WithCancel(ctx)
+		DeferCleanup(cancel)
+		fwd.Start(fwdCtx, hub)
+	}
+
+	// connect registers device of uid with app meta
+	connect := func(uid, device string) *testutil.FakeAdapter {
+		dv := tok.CreateDevice(uid, device)
+		dv.PutMeta("app", "v1")
+		return testutil.Connect(ctx, hub, dv)
+	}
+
+	It("should forward messages in signed batches", func() {
+		start(webhook.WithEvents(false), webhook.WithBatch(3, time.Hour))
+		adapter := connect("u1", "d1")
+		for i := 0; i < 3; i++ {
+			adapter.ChRead <- []byte("m" + strconv.Itoa(i))
+		}
+
+		var b webhook.Batch
+		Eventually(chBatch).Should(Receive(&b))
+		Ω(b.Events).To(HaveLen(3))
+		// actor is called concurrently, seq tells the order
+		payloads := make(map[uint64]string)
+		ids := make(map[string]bool)
+		for _, e := range b.Events {
+			Ω(e.Type).To(Equal(webhook.TypeMessage))
+			Ω(e.UID).To(Equal("u1"))
+			Ω(e.Device).To(Equal("d1"))
+			Ω(e.Meta).To(Equal(map[string]string{"app": "v1"}))
+			Ω(e.Time).NotTo(BeZero())
+			payloads[e.Seq] = string(e.Payload)
+			ids[e.ID] = true
+		}
+		Ω(payloads).To(Equal(map[uint64]string{1: "m0", 2: "m1", 3: "m2"}))
+		Ω(ids).To(HaveLen(3))
+		Consistently(chBatch).ShouldNot(Receive())
+	})
+
+	It("should forward connect and disconnect events", func() {
+		start(webhook.WithBatch(1, 0))
+		adapter := connect("u1", "d1")
+		adapter.Close()
+
+		var types, reasons []string
+		for len(types) < 2 {
+			var b webhook.Batch
+			Eventually(chBatch).Should(Receive(&b))
+			for _, e := range b.Events {
+				types = append(types, e.Type)
+				reasons = append(reasons, e.Reason)
+				Ω(e.Meta).To(HaveKeyWithValue("app", "v1"))
+			}
+		}
+		Ω(types).To(Equal([]string{webhook.TypeConnected, webhook.TypeDisconnected}))
+		Ω(reasons[0]).To(BeEmpty())
+		Ω(reasons[1]).NotTo(BeEmpty())
+	})
+
+	It("should send replies back to the device", func() {
+		respond = func(w http.ResponseWriter, b webhook.Batch) {
+			resp := webhook.Response{}
+			for _, e := range b.Events {
+				resp.Replies = append(resp.Replies, webhook.Reply{ID: e.ID, Payload: append([]byte("re:"), e.Payload...)})
+			}
+			_ = json.NewEncoder(w).Encode(resp)
+		}
+		start(webhook.WithEvents(false), webhook.WithReply(true))
+		a1 := connect("u1", "d1")
+		a2 := connect("u1", "d2")
+
+		a1.ChRead <- []byte("hi")
+		Eventually(a1.ChWritten).Should(Receive(Equal([]byte("re:hi"))))
+		Consistently(a2.ChWritten).ShouldNot(Receive())
+	})
+
+	It("should retry with backoff", func() {
+		respond = func(w http.ResponseWriter, _ webhook.Batch) {
+			if requests.Load() < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}
+		start(webhook.WithEvents(false), webhook.WithRetry(3, time.Millisecond, 5*time.Millisecond))
+		adapter := connect("u1", "d1")
+		adapter.ChRead <- []byte("hi")
+
+		Eventually(requests.Load).Should(BeEquivalentTo(3))
+		Consistently(requests.Load).Should(BeEquivalentTo(3))
+		Ω(chBatch).To(HaveLen(3))
+	})
+
+	It("should not retry client errors", func() {
+		respond = func(w http.ResponseWriter, _ webhook.Batch) {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		start(webhook.WithEvents(false), webhook.WithRetry(3, time.Millisecond, 5*time.Millisecond))
+		adapter := connect("u1", "d1")
+		adapter.ChRead <- []byte("hi")
+
+		Eventually(requests.Load).Should(BeEquivalentTo(1))
+		Consistently(requests.Load).Should(BeEquivalentTo(1))
+	})
+
+	It("should limit concurrency", func() {
+		var inFlight, maxInFlight atomic.Int32
+		chRelease := make(chan struct{})
+		respond = func(http.ResponseWriter, webhook.Batch) {
+			n := inFlight.Add(1)
+			for {
+				m := maxInFlight.Load()
+				if n <= m || maxInFlight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			<-chRelease
+			inFlight.Add(-1)
+		}
+		start(webhook.WithEvents(false), webhook.WithBatch(1, 0), webhook.WithConcurrency(2))
+		adapter := connect("u1", "d1")
+		for i := 0; i < 5; i++ {
+			adapter.ChRead <- []byte("m" + strconv.Itoa(i))
+		}
+
+		Eventually(inFlight.Load).Should(BeEquivalentTo(2))
+		Consistently(inFlight.Load).Should(BeEquivalentTo(2))
+		close(chRelease)
+		Eventually(requests.Load).Should(BeEquivalentTo(5))
+		Ω(maxInFlight.Load()).To(BeEquivalentTo(2))
+	})
+
+	It("should fail while queue is full", func() {
+		fwd = webhook.New(server.URL, webhook.WithQueueSize(1))
+		dv := tok.CreateDevice("u1", "d1")
+		Ω(fwd.OnReceive(ctx, dv, []byte("m1"))).To(Succeed())
+		Ω(fwd.OnReceive(ctx, dv, []byte("m2"))).To(MatchError(webhook.ErrQueueFull))
+	})
+})
+
+var _ = Describe("Verify", func() {
+	secret := []byte("secret")
+
+	request := func(ts string, body string, sig string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set(webhook.HeaderTimestamp, ts)
+		r.Header.Set(webhook.HeaderSignature, sig)
+		return r
+	}
+
+	It("should verify signature and its age", func() {
+		now := strconv.FormatInt(time.Now().Unix(), 10)
+		body, err := webhook.Verify(secret, request(now, "hi", webhook.Sign(secret, now, []byte("hi"))), time.Minute)
+		Ω(err).To(Succeed())
+		Ω(string(body)).To(Equal("hi"))
+
+		_, err = webhook.Verify(secret, request(now, "hi!", webhook.Sign(secret, now, []byte("hi"))), time.Minute)
+		Ω(err).To(MatchError(webhook.ErrBadSignature))
+		_, err = webhook.Verify([]byte("other"), request(now, "hi", webhook.Sign(secret, now, []byte("hi"))), time.Minute)
+		Ω(err).To(MatchError(webhook.ErrBadSignature))
+
+		old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+		_, err = webhook.Verify(secret, request(old, "hi", webhook.Sign(secret, old, []byte("hi"))), time.Minute)
+		Ω(err).To(MatchError(webhook.ErrBadSignature))
+		_, err = webhook.Verify(secret, request(old, "hi", webhook.Sign(secret, old, []byte("hi"))), 0)
+		Ω(err).To(Succeed())
+
+		future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+		_, err = webhook.Verify(secret, request(future, "hi", webhook.Sign(secret, future, []byte("hi"))), time.Minute)
+		Ω(err).To(MatchError(webhook.ErrBadSignature))
+		_, err = webhook.Verify(secret, request(future, "hi", webhook.Sign(secret, future, []byte("hi"))), 0)
+		Ω(err).To(Succeed())
+	})
+})
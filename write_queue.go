@@ -0,0 +1,185 @@
+/**
+ * asynchronous per-connection write queue
+ */
+
+package tok
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// ErrSlowConsumer occurs while write queue of connection is full, and message is dropped or connection is closed.
+// see SlowConsumerPolicy
+var ErrSlowConsumer = errors.New("tok: slow consumer")
+
+// SlowConsumerPolicy decides what to do with new message while write queue of connection is full
+type SlowConsumerPolicy int
+
+const (
+	// SlowConsumerDisconnect closes the connection, queued messages with ttl are moved to offline Queue.
+	// The new message is failed with ErrSlowConsumer, so Send caches it if ttl > 0.
+	SlowConsumerDisconnect SlowConsumerPolicy = iota
+	// SlowConsumerDropOldest drops the oldest queued message to make room for the new one
+	SlowConsumerDropOldest
+	// SlowConsumerDropNewest drops the new message with ErrSlowConsumer
+	SlowConsumerDropNewest
+)
+
+// maxWriteBatch is the upper limit of queued frames flushed by writer goroutine at once
+const maxWriteBatch = 64
+
+// outFrame is a message queued for writer goroutine
+type outFrame struct {
+	ctx  context.Context // handler context of message
+	data []byte          // data to write, BeforeSend applied
+	orig []byte          // original data, for AfterSend and offline queue
+	ttl  uint32          // ttl in seconds, message is moved to offline queue if it's not written before connection closed
+}
+
+// enqueue queues frame for writer goroutine, slow consumer policy applies if queue is full
+func (conn *connection) enqueue(f *outFrame) error {
+	if conn.isClosed() {
+		return errors.New("can't write to closed connection")
+	}
+
+	select {
+	case conn.outq <- f:
+		return conn.checkQueued(f)
+	default:
+	}
+
+	switch conn.hub.config.slowConsumerPolicy {
+	case SlowConsumerDropNewest:
+		slog.Debug("[tok] write queue full, drop newest", "uid", conn.uid())
+		return ErrSlowConsumer
+	case SlowConsumerDropOldest:
+		slog.Debug("[tok] write queue full, drop oldest", "uid", conn.uid())
+		for {
+			select {
+			case <-conn.outq:
+			default:
+			}
+			select {
+			case conn.outq <- f:
+				return conn.checkQueued(f)
+			default:
+				// taken by concurrent sender, try again
+			}
+		}
+	default:
+		slog.Warn("[tok] write queue full, close slow consumer", "uid", conn.uid())
+		conn.triggerOffline(DisconnectReasonSlowConsumer)
+		// older messages go to offline queue before the new one, which is cached by Send
+		conn.drainQueue()
+		return ErrSlowConsumer
+	}
+}
+
+// checkQueued re-checks writer goroutine after f is queued, as it may exit meanwhile.
+// If so, frames left in queue are moved to offline queue, except f which is failed for caller to handle.
+func (conn *connection) checkQueued(f *outFrame) error {
+	conn.qLock.Lock()
+	defer conn.qLock.Unlock()
+
+	if !conn.qClosed {
+		return nil
+	}
+	found := false
+	for {
+		select {
+		case q := <-conn.outq:
+			if q == f {
+				found = true
+				continue
+			}
+			conn.hub.cacheFrame(conn.uid(), q)
+		default:
+			if found {
+				return errors.New("can't write to closed connection")
+			}
+			// drained by writer goroutine
+			return nil
+		}
+	}
+}
+
+// writeLoop writes queued frames until ctx is done, then moves rest frames to offline queue.
+// If adapter is a BatchWriter, frames queued meanwhile are flushed together.
+func (conn *connection) writeLoop(ctx context.Context) {
+	defer func() {
+		conn.qLock.Lock()
+		defer conn.qLock.Unlock()
+		conn.qClosed = true
+		conn.drainQueue()
+	}()
+
+	limit := 1
+	if _, ok := conn.adapter.(BatchWriter); ok {
+		limit = maxWriteBatch
+	}
+	batch := make([]*outFrame, 0, maxWriteBatch)
+	data := make([][]byte, 0, maxWriteBatch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case f := <-conn.outq:
+			batch = append(batch[:0], f)
+		collect:
+			for len(batch) < limit {
+				select {
+				case f := <-conn.outq:
+					batch = append(batch, f)
+				default:
+					break collect
+				}
+			}
+
+			data = data[:0]
+			for _, f := range batch {
+				data = append(data, f.data)
+			}
+			n, err := conn.writeBatch(data)
+			clear(data)
+
+			if conn.hub.config.hdlAfterSend != nil {
+				for _, f := range batch[:n] {
+					go conn.hub.afterSend(f.ctx, conn, f.orig)
+				}
+			}
+			if err != nil {
+				slog.Debug("[tok] write queued frame failed", "err", err)
+				for _, f := range batch[n:] {
+					conn.hub.cacheFrame(conn.uid(), f)
+				}
+				return
+			}
+			clear(batch)
+		}
+	}
+}
+
+// drainQueue moves queued frames to offline queue
+func (conn *connection) drainQueue() {
+	for {
+		select {
+		case f := <-conn.outq:
+			conn.hub.cacheFrame(conn.uid(), f)
+		default:
+			return
+		}
+	}
+}
+
+// cacheFrame caches unsent frame in offline queue, if it has ttl
+func (p *Hub) cacheFrame(uid interface{}, f *outFrame) {
+	if f.ttl == 0 || p.config.q == nil {
+		return
+	}
+	expEnq.Add(1)
+	if err := p.enq(context.Background(), uid, f.orig, f.ttl); err != nil {
+		slog.Warn("[tok] cache unsent frame failed", "err", err, "uid", uid)
+	}
+}
@@ -2,6 +2,8 @@ package tok
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/coder/websocket"
@@ -15,6 +17,11 @@ type coderWsAdapter struct {
 	txt          bool            // If true, use text frames; otherwise, use binary frames
 	writeTimeout time.Duration   // Timeout for write operations
 	readTimeout  time.Duration   // Timeout for read operations
+	lastPong     atomic.Int64    // Unix nano time of the last pong received
+	pingOnce     sync.Once       // starts pong waiter on the first control ping
+	chPing       chan struct{}   // asks pong waiter to ping, a ping in flight absorbs new ones
+	closeOnce    sync.Once
+	closed       chan struct{} // closed by Close, stops pong waiter
 }
 
 func (p *coderWsAdapter) Read() ([]byte, error) {
@@ -48,6 +55,9 @@ func (p *coderWsAdapter) Write(b []byte) error {
 }
 
 func (p *coderWsAdapter) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
 	// Send close message with normal closure code
 	return p.conn.Close(websocket.StatusNormalClosure, "")
 }
@@ -60,3 +70,46 @@ func (p *coderWsAdapter) ShareConn(adapter ConAdapter) bool {
 
 	return p.conn == coderAdapter.conn
 }
+
+func (p *coderWsAdapter) transport() string {
+	return TransportCoder
+}
+
+// ControlPing sends websocket ping control frame, implements ControlPinger.
+// coder/websocket blocks until pong arrives, so the pong is awaited by pong waiter of the connection.
+func (p *coderWsAdapter) ControlPing() error {
+	p.pingOnce.Do(func() {
+		p.chPing = make(chan struct{}, 1)
+		go p.pongWaiter()
+	})
+	select {
+	case p.chPing <- struct{}{}:
+	default:
+		// waiting for pong of the last ping, it's counted as missed if it doesn't arrive in time
+	}
+	return nil
+}
+
+// pongWaiter pings and waits for pong one by one until adapter is closed
+func (p *coderWsAdapter) pongWaiter() {
+	for {
+		select {
+		case <-p.chPing:
+		case <-p.closed:
+			return
+		}
+		ctx, cancel := context.WithTimeout(p.ctx, p.writeTimeout)
+		if err := p.conn.Ping(ctx); err == nil {
+			p.lastPong.Store(time.Now().UnixNano())
+		}
+		cancel()
+	}
+}
+
+// LastPong implements PongTracker
+func (p *coderWsAdapter) LastPong() time.Time {
+	if n := p.lastPong.Load(); n > 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
@@ -32,7 +32,7 @@ func (p *WsHandler) hdlFromXwebSocket() xwebsocket.Handler {
 			writeTimeout: p.hubConfig.writeTimeout,
 		}
 
-		if dv, err := p.auth(ws.Request()); err != nil {
+		if dv, err := p.authenticate(ws.Request()); err != nil {
 			slog.Warn("websocket auth err", "err", err)
 			_ = adapter.Close()
 		} else {
@@ -62,8 +62,9 @@ func (p *WsHandler) hdlFromGorillaWebSocket() http.HandlerFunc {
 			writeTimeout: p.hubConfig.writeTimeout,
 			readTimeout:  p.hubConfig.readTimeout,
 		}
+		conn.SetPongHandler(adapter.onPong)
 
-		if dv, err := p.auth(r); err != nil {
+		if dv, err := p.authenticate(r); err != nil {
 			slog.Warn("gorilla websocket auth err", "err", err)
 			_ = adapter.Close()
 		} else {
@@ -88,9 +89,10 @@ func (p *WsHandler) hdlFromCoderWebSocket() http.HandlerFunc {
 			txt:          p.txt,
 			writeTimeout: p.hubConfig.writeTimeout,
 			readTimeout:  p.hubConfig.readTimeout,
+			closed:       make(chan struct{}),
 		}
 
-		if dv, err := p.auth(r); err != nil {
+		if dv, err := p.authenticate(r); err != nil {
 			slog.Warn("coder websocket auth err", "err", err)
 			_ = adapter.Close()
 		} else {
@@ -99,6 +101,15 @@ func (p *WsHandler) hdlFromCoderWebSocket() http.HandlerFunc {
 	}
 }
 
+// authenticate calls auth function, panic is reported to PanicHandler
+func (p *WsHandler) authenticate(r *http.Request) (dv *Device, err error) {
+	defer p.hub.recoverHook("Auth", nil, &err)
+	if dv, err = p.auth(r); err == nil && dv != nil {
+		dv.setRemoteAddr(requestAddr(r))
+	}
+	return dv, err
+}
+
 // CreateWsHandler create websocket http handler
 // auth function is used for user authorization
 // Return hub and http handler
@@ -3,7 +3,11 @@ package tok_test
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
 
+	"github.com/gorilla/websocket"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
@@ -45,4 +49,66 @@ var _ = Describe("WsConn", func() {
 		Entry("XNet WebSocket", tok.WsEngineX),
 		Entry("Gorilla WebSocket", tok.WsEngineGorilla),
 	)
+
+	Describe("control ping", func() {
+		const interval = 50 * time.Millisecond
+
+		// dial connects to ws handler served by engine, pong replies are sent only if pong is true
+		dial := func(engine tok.WsEngine, pong bool) (*tok.Hub, *atomic.Int32) {
+			auth := func(r *http.Request) (*tok.Device, error) {
+				return tok.CreateDevice("u1", ""), nil
+			}
+			hub, hdl := tok.CreateWsHandler(auth,
+				tok.WithWsHandlerEngine(engine),
+				tok.WithWsHandlerHubConfig(tok.NewHubConfig(mActor,
+					tok.WithHubConfigServerPingInterval(interval),
+					tok.WithHubConfigPingProducer(mPingGen), // no payload ping is expected
+					tok.WithHubConfigControlPing(2))))
+			server := httptest.NewServer(hdl)
+			DeferCleanup(server.Close)
+
+			// x/net/websocket requires Origin header
+			ws, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], http.Header{"Origin": {server.URL}})
+			Ω(err).To(Succeed())
+			DeferCleanup(ws.Close)
+
+			pings := &atomic.Int32{}
+			ws.SetPingHandler(func(data string) error {
+				pings.Add(1)
+				if !pong {
+					return nil
+				}
+				return ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+			})
+			go func() {
+				for {
+					if _, _, err := ws.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}()
+			return hub, pings
+		}
+
+		DescribeTable("should keep connection answering pongs",
+			func(engine tok.WsEngine) {
+				hub, pings := dial(engine, true)
+				Eventually(pings.Load).Should(BeNumerically(">=", 4))
+				Ω(hub.CheckOnline(ctx, "u1")).To(BeTrue())
+			},
+			Entry("Coder WebSocket", tok.WsEngineCoder),
+			Entry("XNet WebSocket", tok.WsEngineX),
+			Entry("Gorilla WebSocket", tok.WsEngineGorilla),
+		)
+
+		DescribeTable("should close connection missing pongs",
+			func(engine tok.WsEngine) {
+				hub, pings := dial(engine, false)
+				Eventually(pings.Load).Should(BeNumerically(">=", 1))
+				Eventually(func() bool { return hub.CheckOnline(ctx, "u1") }).Should(BeFalse())
+			},
+			Entry("Coder WebSocket", tok.WsEngineCoder),
+			Entry("Gorilla WebSocket", tok.WsEngineGorilla),
+		)
+	})
 })
@@ -2,6 +2,7 @@ package tok
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -14,6 +15,7 @@ type gorillaWsAdapter struct {
 	txt          bool            // If true, use text frames; otherwise, use binary frames
 	writeTimeout time.Duration   // Timeout for write operations
 	readTimeout  time.Duration   // Timeout for read operations
+	lastPong     atomic.Int64    // Unix nano time of the last pong received
 }
 
 func (p *gorillaWsAdapter) Read() ([]byte, error) {
@@ -53,3 +55,26 @@ func (p *gorillaWsAdapter) ShareConn(adapter ConAdapter) bool {
 	}
 	return p.conn == gorillaAdapter.conn
 }
+
+func (p *gorillaWsAdapter) transport() string {
+	return TransportGorilla
+}
+
+// ControlPing sends websocket ping control frame, implements ControlPinger
+func (p *gorillaWsAdapter) ControlPing() error {
+	return p.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(p.writeTimeout))
+}
+
+// LastPong implements PongTracker, pongs are recorded by onPong while reading
+func (p *gorillaWsAdapter) LastPong() time.Time {
+	if n := p.lastPong.Load(); n > 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
+// onPong is the pong handler of underlying connection
+func (p *gorillaWsAdapter) onPong(string) error {
+	p.lastPong.Store(time.Now().UnixNano())
+	return nil
+}
@@ -58,3 +58,20 @@ func (p *xWsAdapter) ShareConn(adapter ConAdapter) bool {
 	}
 	return p.conn == wsAdapter.conn
 }
+
+func (p *xWsAdapter) transport() string {
+	return TransportX
+}
+
+// ControlPing sends websocket ping control frame, implements ControlPinger.
+// golang.org/x/net/websocket answers pings but discards pongs, so it doesn't implement PongTracker.
+func (p *xWsAdapter) ControlPing() error {
+	if err := p.conn.SetWriteDeadline(time.Now().Add(p.writeTimeout)); err != nil {
+		return fmt.Errorf("setting x ws write deadline failed: %w", err)
+	}
+
+	// PayloadType is only used by Conn.Write, messages are sent by websocket.Message with their own frame type
+	p.conn.PayloadType = websocket.PingFrame
+	_, err := p.conn.Write(nil)
+	return err
+}